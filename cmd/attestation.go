@@ -2,18 +2,22 @@ package cmd
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
 	"net"
 	"net/http"
 	"net/rpc"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/spf13/viper"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/vulcanize/lotus-utils/pkg/attestation"
 )
@@ -35,13 +39,22 @@ func attestationService() {
 	if err != nil {
 		logWithCommand.Fatal(err)
 	}
-	logWithCommand.Debug("attestation config: %+v", attestationConfig)
+	logWithCommand.Debugf("attestation config: %+v", attestationConfig)
 	service, err := attestation.NewServiceFromConfig(attestationConfig)
 	if err != nil {
 		logWithCommand.Fatal(err)
 	}
+	if attestationConfig.Offline {
+		code := checksumOffline(service)
+		if err := service.Close(); err != nil {
+			logWithCommand.Fatal(err)
+		}
+		os.Exit(code)
+	}
 	wg := new(sync.WaitGroup)
-	ctx := context.Background()
+	// the root context is cancelled on the first SIGINT or SIGTERM, after which a second one kills the process
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 	errChan := make(<-chan error)
 	if attestationConfig.Checksum {
 		logWithCommand.Info("beginning attestation checksumming process")
@@ -50,55 +63,216 @@ func attestationService() {
 			logWithCommand.Fatal(err)
 		}
 	}
+	if attestationConfig.Compare {
+		logWithCommand.Info("beginning attestation peer comparison process")
+		if err := service.Compare(ctx, wg); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+	var server *http.Server
 	if attestationConfig.Serve {
 		logWithCommand.Info("beginning attestation server")
 		if err := service.Register(rpc.Register); err != nil {
 			logWithCommand.Fatal(err)
 		}
-		rpc.HandleHTTP()
-		listener, err := net.Listen("tcp", fmt.Sprintf(":%s", attestationConfig.ServerPort))
+		// net/rpc cannot check permissions per method, so it only serves the read methods
+		http.Handle(rpc.DefaultRPCPath, service.AuthHandler(attestation.PermRead, attestation.NewRPCHandler(rpc.DefaultServer)))
+		// the same API is served as JSON-RPC 2.0 over HTTP and WebSocket for non-Go clients
+		// with the admin methods, each method checks the caller's permissions
+		rpcServer := jsonrpc.NewServer()
+		service.RegisterJSONRPC(rpcServer.Register)
+		http.Handle(attestation.JSONRPCPath, service.AuthHandler("", rpcServer))
+		// and the published checksums as plain REST/JSON
+		http.Handle(attestation.RESTPathPrefix, service.AuthHandler(attestation.PermRead, service.RESTHandler()))
+		// the health endpoints are not authenticated, so that orchestrators can probe them
+		http.Handle(attestation.HealthzPath, service.HealthHandler())
+		http.Handle(attestation.ReadyzPath, service.HealthHandler())
+		listener, err := net.Listen("tcp", net.JoinHostPort(attestationConfig.ServerHost, attestationConfig.ServerPort))
 		if err != nil {
 			log.Fatal("listen error:", err)
 		}
-		go http.Serve(listener, nil)
+		if attestationConfig.TLSCertPath != "" {
+			tlsConfig, err := attestation.NewServerTLSConfig(attestationConfig.TLSCertPath, attestationConfig.TLSKeyPath,
+				attestationConfig.TLSClientCAPath)
+			if err != nil {
+				logWithCommand.Fatal(err)
+			}
+			listener = tls.NewListener(listener, tlsConfig)
+			if tlsConfig.ClientCAs != nil {
+				logWithCommand.Info("serving over mutual TLS, clients must present a certificate")
+			} else {
+				logWithCommand.Info("serving over TLS")
+			}
+		}
+		server = &http.Server{ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logWithCommand.Errorf("attestation server failed: %s", err.Error())
+			}
+		}()
 		if err := service.Serve(ctx, wg); err != nil {
 			logWithCommand.Fatal(err)
 		}
 	}
 
+	code := 0
+	select {
+	case <-ctx.Done():
+		logWithCommand.Info("shutting down")
+	case err := <-errChan:
+		// the loop only exits with an error if its retry policy gave up on the error and is set to shut down
+		logWithCommand.Errorf("checksumming loop failed, shutting down: %s", err.Error())
+		code = 1
+	}
+	cancel()
+	os.Exit(shutdown(service, server, attestationConfig.ShutdownTimeout, wg, code))
+}
+
+// shutdown stops the service's loops, letting the checksumming loop publish the chunk in flight, and the HTTP servers,
+// giving in-flight requests until the timeout to finish, before closing the databases; it returns the exit status
+func shutdown(service *attestation.Service, server *http.Server, timeout time.Duration, wg *sync.WaitGroup, code int) int {
+	// ends the checksum subscriptions, so that their streams do not hold up the server shutdown
+	service.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	shutdownServer(ctx, "API", server, timeout)
+	shutdownServer(ctx, "metrics", promServer, timeout)
+	wg.Wait()
+	if err := service.Close(); err != nil {
+		logWithCommand.Error(err)
+		return 1
+	}
+	logWithCommand.Info("attestation service shut down")
+	return code
+}
+
+// shutdownServer gracefully shuts down the server if it is running, closing the connections still open at the deadline
+func shutdownServer(ctx context.Context, name string, server *http.Server, timeout time.Duration) {
+	if server == nil {
+		return
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		logWithCommand.Warnf("closing %s connections still open after %s: %s", name, timeout, err.Error())
+		server.Close()
+	}
+}
+
+// checksumOffline checksums every complete chunk in msgindex.db once and returns the exit status
+// it exits with status 2 if any chunk was skipped, or could not be backfilled, because its range is not fully
+// populated in msgindex.db
+func checksumOffline(service *attestation.Service) int {
+	logWithCommand.Info("beginning offline attestation checksumming")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
 	go func() {
-		for {
-			select {
-			case err := <-errChan:
-				// TODO: add additional error handling logic e.g. shutdown on error
-				logWithCommand.Error(err)
-			}
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
-
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt)
-	<-shutdown
-	if err := service.Close(); err != nil {
-		logWithCommand.Fatal(err)
+	report, err := service.ChecksumUntilExhausted(ctx)
+	if report.Backfilled > 0 || report.BackfillSkipped > 0 {
+		logWithCommand.Infof("backfilled %d chunks missing from checksums.db, %d could not be backfilled",
+			report.Backfilled, report.BackfillSkipped)
 	}
-	wg.Wait()
+	logWithCommand.Infof("checksummed epochs %d to %d: %d chunks written, %d skipped, %d gaps in msgindex.db",
+		report.Start, report.Stop, report.Written, report.Skipped, len(report.Gaps))
+	for _, gap := range report.Gaps {
+		logWithCommand.Warnf("msgindex.db is missing epochs %d to %d", gap[0], gap[1])
+	}
+	if err != nil {
+		logWithCommand.Error(err)
+		return 1
+	}
+	if report.Skipped > 0 || report.BackfillSkipped > 0 {
+		return 2
+	}
+	return 0
 }
 
 func init() {
 	rootCmd.AddCommand(attestationCmd)
 
+	attestationCmd.SetGlobalNormalizationFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "until-exhausted" {
+			name = "offline"
+		}
+		return pflag.NormalizedName(name)
+	})
+
 	attestationCmd.PersistentFlags().String("checksum-db-directory", "", "path for directory that contains a checksums.db")
 	attestationCmd.PersistentFlags().Uint("checksum-chunk-size", 2880, "epoch range size for caluclating checksums over")
 	attestationCmd.PersistentFlags().Bool("checksum-on", true, "turn checksumming on")
+	attestationCmd.PersistentFlags().String("checksum-algorithm", "sha3-256", "hash algorithm for checksums (sha3-256, blake2b-256, sha256)")
+	attestationCmd.PersistentFlags().String("signing-key-path", "", "path to a Lotus wallet key file (secp256k1, bls or ed25519) used to sign checksums")
+	attestationCmd.PersistentFlags().Bool("offline", false, "checksum every complete chunk in msgindex.db and exit, alias --until-exhausted")
+	attestationCmd.PersistentFlags().Bool("check-for-gaps", true, "backfill gaps in checksums.db at startup and periodically")
+	attestationCmd.PersistentFlags().Duration("gap-check-interval", time.Hour, "how often to check checksums.db for gaps")
+	attestationCmd.PersistentFlags().Uint("checksum-max-retries", 5, "times to retry a transient checksumming error (e.g. SQLITE_BUSY) before giving up on it")
+	attestationCmd.PersistentFlags().Duration("checksum-retry-min-delay", time.Second, "delay before the first retry, doubled for every retry after")
+	attestationCmd.PersistentFlags().Duration("checksum-retry-max-delay", time.Minute, "maximum delay between retries")
+	attestationCmd.PersistentFlags().String("checksum-on-failure", "pause", "what to do about a checksumming error given up on (continue, pause, shutdown)")
+
+	attestationCmd.PersistentFlags().String("lotus-api-url", "", "websocket URL of a Lotus full-node or gateway API to follow the chain head of (e.g. ws://127.0.0.1:1234/rpc/v1)")
+	attestationCmd.PersistentFlags().String("lotus-auth-token-path", "", "path to the Lotus API auth token file")
+	attestationCmd.PersistentFlags().Uint("finality-depth", 900, "number of epochs below the chain head after which chunks are checksummed")
+
+	attestationCmd.PersistentFlags().Bool("compare-on", false, "turn on periodic comparison of checksums with peers")
+	attestationCmd.PersistentFlags().StringSlice("compare-peers", []string{}, "comma separated list of host:port addresses of peer attestation servers")
+	attestationCmd.PersistentFlags().Duration("compare-interval", 10*time.Minute, "how often to compare checksums with peers")
+	attestationCmd.PersistentFlags().String("compare-auth-token-path", "", "path to the API token file to authenticate to peers with")
+	attestationCmd.PersistentFlags().Bool("compare-tls", false, "connect to peers over TLS, implied by the other --compare-tls flags")
+	attestationCmd.PersistentFlags().String("compare-tls-ca-path", "", "path to the PEM bundle of CAs to verify peer certificates against, system roots if empty")
+	attestationCmd.PersistentFlags().String("compare-tls-cert-path", "", "path to the PEM client certificate to present to peers requiring mutual TLS")
+	attestationCmd.PersistentFlags().String("compare-tls-key-path", "", "path to the PEM key of the client certificate")
 
 	attestationCmd.PersistentFlags().Bool("server-on", false, "turn on the http rpc server")
 	attestationCmd.PersistentFlags().String("server-port", "8087", "port http rpc server")
+	attestationCmd.PersistentFlags().String("server-host", "", "host or IP address to bind the http rpc server to, all interfaces if empty")
+	attestationCmd.PersistentFlags().String("auth-secret-path", "", "path to the secret API tokens are signed with, created if it does not exist")
+	attestationCmd.PersistentFlags().Bool("require-token", false, "reject API requests without a token, instead of giving them read permission")
+	attestationCmd.PersistentFlags().String("server-tls-cert-path", "", "path to the PEM certificate to serve over TLS with")
+	attestationCmd.PersistentFlags().String("server-tls-key-path", "", "path to the PEM key of the server certificate")
+	attestationCmd.PersistentFlags().String("server-tls-client-ca-path", "", "path to the PEM bundle of CAs to require and verify client certificates against (mutual TLS)")
+	attestationCmd.PersistentFlags().Duration("server-shutdown-timeout", 30*time.Second, "how long to wait for in-flight API requests when shutting down")
 
 	viper.BindPFlag(attestation.CHECKSUM_DB_DIRECTORY_TOML, attestationCmd.PersistentFlags().Lookup("checksum-db-directory"))
 	viper.BindPFlag(attestation.CHECKSUM_CHUNK_SIZE_TOML, attestationCmd.PersistentFlags().Lookup("checksum-chunk-size"))
 	viper.BindPFlag(attestation.SUPPORTS_CHECKSUMMING_TOML, attestationCmd.PersistentFlags().Lookup("checksum-on"))
+	viper.BindPFlag(attestation.CHECKSUM_ALGORITHM_TOML, attestationCmd.PersistentFlags().Lookup("checksum-algorithm"))
+	viper.BindPFlag(attestation.SIGNING_KEY_PATH_TOML, attestationCmd.PersistentFlags().Lookup("signing-key-path"))
+	viper.BindPFlag(attestation.CHECKSUM_OFFLINE_TOML, attestationCmd.PersistentFlags().Lookup("offline"))
+	viper.BindPFlag(attestation.CHECK_FOR_GAPS_TOML, attestationCmd.PersistentFlags().Lookup("check-for-gaps"))
+	viper.BindPFlag(attestation.GAP_CHECK_INTERVAL_TOML, attestationCmd.PersistentFlags().Lookup("gap-check-interval"))
+	viper.BindPFlag(attestation.CHECKSUM_MAX_RETRIES_TOML, attestationCmd.PersistentFlags().Lookup("checksum-max-retries"))
+	viper.BindPFlag(attestation.CHECKSUM_RETRY_MIN_DELAY_TOML, attestationCmd.PersistentFlags().Lookup("checksum-retry-min-delay"))
+	viper.BindPFlag(attestation.CHECKSUM_RETRY_MAX_DELAY_TOML, attestationCmd.PersistentFlags().Lookup("checksum-retry-max-delay"))
+	viper.BindPFlag(attestation.CHECKSUM_ON_FAILURE_TOML, attestationCmd.PersistentFlags().Lookup("checksum-on-failure"))
+
+	viper.BindPFlag(attestation.LOTUS_API_URL_TOML, attestationCmd.PersistentFlags().Lookup("lotus-api-url"))
+	viper.BindPFlag(attestation.LOTUS_AUTH_TOKEN_PATH_TOML, attestationCmd.PersistentFlags().Lookup("lotus-auth-token-path"))
+	viper.BindPFlag(attestation.FINALITY_DEPTH_TOML, attestationCmd.PersistentFlags().Lookup("finality-depth"))
+
+	viper.BindPFlag(attestation.SUPPORTS_COMPARING_TOML, attestationCmd.PersistentFlags().Lookup("compare-on"))
+	viper.BindPFlag(attestation.COMPARE_PEERS_TOML, attestationCmd.PersistentFlags().Lookup("compare-peers"))
+	viper.BindPFlag(attestation.COMPARE_INTERVAL_TOML, attestationCmd.PersistentFlags().Lookup("compare-interval"))
+	viper.BindPFlag(attestation.COMPARE_AUTH_TOKEN_PATH_TOML, attestationCmd.PersistentFlags().Lookup("compare-auth-token-path"))
+	viper.BindPFlag(attestation.COMPARE_TLS_TOML, attestationCmd.PersistentFlags().Lookup("compare-tls"))
+	viper.BindPFlag(attestation.COMPARE_TLS_CA_PATH_TOML, attestationCmd.PersistentFlags().Lookup("compare-tls-ca-path"))
+	viper.BindPFlag(attestation.COMPARE_TLS_CERT_PATH_TOML, attestationCmd.PersistentFlags().Lookup("compare-tls-cert-path"))
+	viper.BindPFlag(attestation.COMPARE_TLS_KEY_PATH_TOML, attestationCmd.PersistentFlags().Lookup("compare-tls-key-path"))
 
 	viper.BindPFlag(attestation.SERVER_PORT_TOML, attestationCmd.PersistentFlags().Lookup("server-port"))
 	viper.BindPFlag(attestation.SUPPORTS_SERVER_TOML, attestationCmd.PersistentFlags().Lookup("server-on"))
+	viper.BindPFlag(attestation.SERVER_HOST_TOML, attestationCmd.PersistentFlags().Lookup("server-host"))
+	viper.BindPFlag(attestation.AUTH_SECRET_PATH_TOML, attestationCmd.PersistentFlags().Lookup("auth-secret-path"))
+	viper.BindPFlag(attestation.AUTH_REQUIRE_TOKEN_TOML, attestationCmd.PersistentFlags().Lookup("require-token"))
+	viper.BindPFlag(attestation.SERVER_TLS_CERT_PATH_TOML, attestationCmd.PersistentFlags().Lookup("server-tls-cert-path"))
+	viper.BindPFlag(attestation.SERVER_TLS_KEY_PATH_TOML, attestationCmd.PersistentFlags().Lookup("server-tls-key-path"))
+	viper.BindPFlag(attestation.SERVER_TLS_CLIENT_CA_PATH_TOML, attestationCmd.PersistentFlags().Lookup("server-tls-client-ca-path"))
+	viper.BindPFlag(attestation.SERVER_SHUTDOWN_TIMEOUT_TOML, attestationCmd.PersistentFlags().Lookup("server-shutdown-timeout"))
 }
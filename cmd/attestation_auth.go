@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation"
+)
+
+// authCmd groups the attestation API token commands
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "manage attestation API tokens",
+}
+
+// createTokenCmd represents the attestation auth create-token command
+var createTokenCmd = &cobra.Command{
+	Use:   "create-token",
+	Short: "create an attestation API token",
+	Long: `This command creates a JWT for the attestation API with the given permission, signed with the secret at
+--auth-secret-path, which is generated if it does not exist yet. The token is printed to stdout.
+A read token can call every read method, an admin token can also trigger recomputation, backfills and deletions.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		createToken()
+	},
+}
+
+func createToken() {
+	viper.BindEnv(attestation.AUTH_SECRET_PATH_TOML, attestation.AUTH_SECRET_PATH)
+	secretPath := viper.GetString(attestation.AUTH_SECRET_PATH_TOML)
+	if secretPath == "" {
+		logWithCommand.Fatal("an auth secret path must be provided")
+	}
+	perms, err := attestation.PermissionsFor(viper.GetString("auth.perm"))
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	secret, err := attestation.LoadOrCreateAuthSecret(secretPath)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	token, err := attestation.NewAuthenticator(secret, false).CreateToken(perms)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	fmt.Println(token)
+}
+
+func init() {
+	attestationCmd.AddCommand(authCmd)
+	authCmd.AddCommand(createTokenCmd)
+
+	createTokenCmd.Flags().String("perm", "read", "permission to grant the token (read, admin)")
+
+	viper.BindPFlag("auth.perm", createTokenCmd.Flags().Lookup("perm"))
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation"
+)
+
+// bisectCmd represents the attestation bisect command
+var bisectCmd = &cobra.Command{
+	Use:   "bisect",
+	Short: "narrow a checksum divergence with a peer down to the offending epochs and message CIDs",
+	Long: `This command has the local msgindex.db and a peer attestation server checksum halves of the given range
+until the single epochs that differ are found, and then diffs the message CIDs both sides index for those epochs.
+It exits with status 1 if the range diverges from the peer.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		if code := bisect(); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// bisect bisects the range against the peer and returns the exit status
+func bisect() int {
+	viper.BindEnv(attestation.MSG_INDEX_DB_DIRECTORY_TOML, attestation.MSG_INDEX_DB_DIRECTORY)
+	viper.BindEnv(attestation.CHECKSUM_ALGORITHM_TOML, attestation.CHECKSUM_ALGORITHM)
+	srcDir := viper.GetString(attestation.MSG_INDEX_DB_DIRECTORY_TOML)
+	if srcDir == "" {
+		logWithCommand.Fatal("a source msgindex.db directory path must be provided")
+	}
+	addr := viper.GetString("bisect.peer")
+	if addr == "" {
+		logWithCommand.Fatal("a peer address must be provided")
+	}
+	start, stop := viper.GetUint("bisect.start"), viper.GetUint("bisect.stop")
+	if start > stop {
+		logWithCommand.Fatal("start epoch cannot be greater than stop epoch")
+	}
+	algo, err := attestation.ParseHashAlgorithm(viper.GetString(attestation.CHECKSUM_ALGORITHM_TOML))
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	cs, err := attestation.NewChecksummer(srcDir, algo)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	defer cs.Close()
+	peerConfig, err := attestation.NewPeerConfig()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	opts, err := peerConfig.Options()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	peer := attestation.NewPeerWithOptions(addr, opts)
+	defer peer.Close()
+
+	report, err := attestation.NewBisector(cs, peer, algo, viper.GetInt("bisect.maxEpochs")).
+		Bisect(context.Background(), start, stop)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	if len(report.Epochs) == 0 {
+		logWithCommand.Infof("range %d to %d agrees with peer %s", start, stop, addr)
+		return 0
+	}
+	logWithCommand.Errorf("range %d to %d diverges from peer %s at epochs %v", start, stop, addr, report.Epochs)
+	if report.Truncated {
+		logWithCommand.Warnf("bisection stopped after %d diverging epochs", len(report.Epochs))
+	}
+	for _, row := range report.LocalOnly {
+		logWithCommand.Errorf("message %s at epoch %d in tipset %s is missing from peer", row.Cid, row.Epoch, row.TipSetCid)
+	}
+	for _, row := range report.RemoteOnly {
+		logWithCommand.Errorf("message %s at epoch %d in tipset %s is missing locally", row.Cid, row.Epoch, row.TipSetCid)
+	}
+	for _, m := range report.TipSetMismatches {
+		logWithCommand.Errorf("message %s is indexed at epoch %d in tipset %s locally, but at epoch %d in tipset %s by peer",
+			m.Cid, m.Local.Epoch, m.Local.TipSetCid, m.Remote.Epoch, m.Remote.TipSetCid)
+	}
+	return 1
+}
+
+func init() {
+	attestationCmd.AddCommand(bisectCmd)
+
+	bisectCmd.Flags().String("msgindex-db-directory", "", "path for directory that contains the source msgindex.db")
+	bisectCmd.Flags().String("peer", "", "host:port address of the peer attestation server to bisect against")
+	bisectCmd.Flags().Uint("start", 0, "first epoch of the range to bisect")
+	bisectCmd.Flags().Uint("stop", 0, "last epoch of the range to bisect (inclusive)")
+	bisectCmd.Flags().Int("max-epochs", 100, "stop after finding this many diverging epochs, 0 for no limit")
+
+	viper.BindPFlag(attestation.MSG_INDEX_DB_DIRECTORY_TOML, bisectCmd.Flags().Lookup("msgindex-db-directory"))
+	viper.BindPFlag("bisect.peer", bisectCmd.Flags().Lookup("peer"))
+	viper.BindPFlag("bisect.start", bisectCmd.Flags().Lookup("start"))
+	viper.BindPFlag("bisect.stop", bisectCmd.Flags().Lookup("stop"))
+	viper.BindPFlag("bisect.maxEpochs", bisectCmd.Flags().Lookup("max-epochs"))
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// compareCmd represents the attestation compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "compare the checksums in checksums.db with those served by peer attestation servers",
+	Long: `This command fetches each peer's checksum for every range in the local checksums.db,
+records whether they agree, and exits.
+It exits with status 1 if any checksum diverges from a peer's, and with status 2 if a peer could not be reached.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		if code := compare(); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// compare runs a single comparison round and returns the exit status
+func compare() int {
+	viper.BindEnv(attestation.CHECKSUM_DB_DIRECTORY_TOML, attestation.CHECKSUM_DB_DIRECTORY)
+	viper.BindEnv(attestation.CHECKSUM_ALGORITHM_TOML, attestation.CHECKSUM_ALGORITHM)
+	viper.BindEnv(attestation.COMPARE_PEERS_TOML, attestation.COMPARE_PEERS)
+	repoDir := viper.GetString(attestation.CHECKSUM_DB_DIRECTORY_TOML)
+	if repoDir == "" {
+		logWithCommand.Fatal("a checksums.db directory path must be provided")
+	}
+	addrs := viper.GetStringSlice(attestation.COMPARE_PEERS_TOML)
+	if len(addrs) == 0 {
+		logWithCommand.Fatal("at least one peer address must be provided")
+	}
+	algo, err := attestation.ParseHashAlgorithm(viper.GetString(attestation.CHECKSUM_ALGORITHM_TOML))
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	repo, _, err := attestation.NewRepo(repoDir, viper.GetUint(attestation.CHECKSUM_CHUNK_SIZE_TOML))
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	defer repo.Close()
+	peerConfig, err := attestation.NewPeerConfig()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	opts, err := peerConfig.Options()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	peers := make([]types.Peer, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = attestation.NewPeerWithOptions(addr, opts)
+	}
+	comparer := attestation.NewComparer(repo, peers, algo)
+	defer comparer.Close()
+
+	report, err := comparer.Compare(context.Background())
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	logWithCommand.Infof("compared %d ranges: %d agreed, %d diverged, %d missing from peers",
+		report.Compared, report.Agreed, len(report.Divergences), report.Missing)
+	for _, d := range report.Divergences {
+		logWithCommand.Errorf("range %d to %d diverges from peer %s: local %s, remote %s",
+			d.Start, d.Stop, d.Peer, d.LocalHash, d.RemoteHash)
+	}
+	switch {
+	case len(report.Divergences) > 0:
+		return 1
+	case len(report.PeerErrors) > 0:
+		return 2
+	}
+	return 0
+}
+
+func init() {
+	attestationCmd.AddCommand(compareCmd)
+}
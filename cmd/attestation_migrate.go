@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation"
+)
+
+// migrateCmd represents the attestation migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "upgrade the checksums.db schema to the latest version",
+	Long: `This command applies any pending schema migrations to the checksums.db.
+Migrations are also applied automatically when the attestation service opens the database,
+use --dry-run to report the pending migrations without applying them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		migrate()
+	},
+}
+
+func migrate() {
+	viper.BindEnv(attestation.CHECKSUM_DB_DIRECTORY_TOML, attestation.CHECKSUM_DB_DIRECTORY)
+	repoDir := viper.GetString(attestation.CHECKSUM_DB_DIRECTORY_TOML)
+	if repoDir == "" {
+		logWithCommand.Fatal("a checksums.db directory path must be provided")
+	}
+	version, pending, err := attestation.RepoMigrationStatus(repoDir)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	logWithCommand.Infof("checksums.db is at version %d, latest version is %d", version, attestation.LatestRepoVersion())
+	if len(pending) == 0 {
+		logWithCommand.Info("no pending migrations")
+		return
+	}
+	for _, m := range pending {
+		logWithCommand.Infof("pending migration to version %d: %s", m.Version, m.Description)
+	}
+	if viper.GetBool("migrate.dryRun") {
+		return
+	}
+	applied, err := attestation.MigrateRepo(repoDir)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	logWithCommand.Infof("applied %d migrations, checksums.db is at version %d", len(applied), attestation.LatestRepoVersion())
+}
+
+func init() {
+	attestationCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().Bool("dry-run", false, "report pending migrations without applying them")
+
+	viper.BindPFlag("migrate.dryRun", migrateCmd.Flags().Lookup("dry-run"))
+}
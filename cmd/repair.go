@@ -78,58 +78,76 @@ func repair() {
 		logWithCommand.Fatalf("unable to initialize gateway API client: %v", err)
 	}
 	defer closer()
-	missingCIDs, err := getMissingCIDs()
+	plan, err := getRepairPlan()
 	if err != nil {
 		logWithCommand.Fatalf("unable to get missing CIDs: %v", err)
 	}
-	repairService := r.NewRepairService(gapi, bs)
-	if err := repairService.Repair(context.Background(), missingCIDs); err != nil {
+	limits := r.Limits{
+		MaxDepth:   viper.GetUint("repair.max_depth"),
+		MaxObjects: viper.GetUint("repair.max_objects"),
+	}
+	fetch := r.FetchOptions{
+		Concurrency: viper.GetUint("repair.fetch_concurrency"),
+		RateLimit:   viper.GetFloat64("repair.fetch_rate_limit"),
+		MaxRetries:  viper.GetUint("repair.fetch_max_retries"),
+		MinDelay:    viper.GetDuration("repair.fetch_retry_min_delay"),
+		MaxDelay:    viper.GetDuration("repair.fetch_retry_max_delay"),
+	}
+	if err := fetch.Validate(); err != nil {
+		logWithCommand.Fatalf("invalid fetch options: %v", err)
+	}
+	repairService := r.NewRepairService(gapi, bs, limits, fetch)
+	if err := repairService.Repair(context.Background(), plan); err != nil {
 		logWithCommand.Fatalf("repair process failed: %v", err)
 	}
 	logWithCommand.Info("repair process completed successfully")
 }
 
-func getMissingCIDs() ([]cid.Cid, error) {
+// getRepairPlan returns the plan for fetching the CIDs given explicitly, and the objects the errors in the error file
+// failed to load
+func getRepairPlan() (r.Plan, error) {
 	errorFilePath := viper.GetString("repair.error_file_path")
 	missingCIDStrs := viper.GetStringSlice("repair.missing_cids")
 	if len(missingCIDStrs) == 0 && errorFilePath == "" {
-		return nil, fmt.Errorf("need to specifiy either a file path or a list of missing CIDs")
+		return r.Plan{}, fmt.Errorf("need to specifiy either a file path or a list of missing CIDs")
 	}
 	missingCids := make([]cid.Cid, 0)
 	for _, cidStr := range missingCIDStrs {
 		c, err := cid.Decode(cidStr)
 		if err != nil {
-			return nil, err
+			return r.Plan{}, err
 		}
 		missingCids = append(missingCids, c)
 	}
+	plan := r.NewPlan(missingCids)
 	if errorFilePath != "" {
 		file, err := os.OpenFile(errorFilePath, os.O_RDONLY, 0666)
 		if err != nil {
-			return nil, err
+			return r.Plan{}, err
 		}
-		cids, err := r.ParseMissingCIDs(file)
+		filter, err := r.NewFilter(viper.GetStringSlice("repair.cid_codecs"), viper.GetStringSlice("repair.cid_multihashes"))
 		if err != nil {
-			return nil, err
+			return r.Plan{}, err
 		}
-		missingCids = combineCIDs(missingCids, cids)
-	}
-	return missingCids, nil
-}
-
-func combineCIDs(cids1 []cid.Cid, cids2 []cid.Cid) []cid.Cid {
-	cidMap := map[cid.Cid]struct{}{}
-	for _, c := range cids1 {
-		cidMap[c] = struct{}{}
-	}
-	for _, c := range cids2 {
-		cidMap[c] = struct{}{}
-	}
-	returnCIDs := make([]cid.Cid, 0, len(cidMap))
-	for c := range cidMap {
-		returnCIDs = append(returnCIDs, c)
+		if viper.GetBool("repair.all_cids") {
+			cids, err := r.ParseMissingCIDs(file, filter)
+			if err != nil {
+				return r.Plan{}, err
+			}
+			plan.Merge(r.NewPlan(cids))
+			return plan, nil
+		}
+		filePlan, err := r.ParseLotusErrors(file, filter)
+		if err != nil {
+			return r.Plan{}, err
+		}
+		if len(filePlan.Missing) == 0 && len(filePlan.Context) > 0 {
+			logWithCommand.Warnf("no errors in %s failed to load any of the %d CIDs it mentions, use --all-cids to fetch them all",
+				errorFilePath, len(filePlan.Context))
+		}
+		plan.Merge(filePlan)
 	}
-	return returnCIDs
+	return plan, nil
 }
 
 func init() {
@@ -140,10 +158,31 @@ func init() {
 	repairCmd.PersistentFlags().String("auth-token-path", "", "path to API auth token file")
 	repairCmd.PersistentFlags().String("error-file-path", "", "path to file with the error logs from which to extract the missing CIDs")
 	repairCmd.PersistentFlags().StringArray("missing-cids", []string{}, "comma separated list of CIDs that are missing the blockstore")
+	repairCmd.PersistentFlags().Bool("all-cids", false, "fetch every CID in the error file, instead of only those the errors failed to load")
+	repairCmd.PersistentFlags().Uint("max-depth", 0, "levels of links to follow from the fetched blocks to fetch their missing descendants, 0 to fetch only the missing CIDs")
+	repairCmd.PersistentFlags().Uint("max-objects", 0, "maximum number of blocks to fetch, 0 for no limit")
+	defaultFetch := r.DefaultFetchOptions()
+	repairCmd.PersistentFlags().Uint("fetch-concurrency", defaultFetch.Concurrency, "number of blocks to fetch from the gateway at once")
+	repairCmd.PersistentFlags().Float64("fetch-rate-limit", defaultFetch.RateLimit, "maximum number of requests per second to the gateway, 0 for no limit")
+	repairCmd.PersistentFlags().Uint("fetch-max-retries", defaultFetch.MaxRetries, "number of times to retry fetching a block before giving up on it")
+	repairCmd.PersistentFlags().Duration("fetch-retry-min-delay", defaultFetch.MinDelay, "delay before the first retry of a fetch, doubling for every retry after")
+	repairCmd.PersistentFlags().Duration("fetch-retry-max-delay", defaultFetch.MaxDelay, "maximum delay between retries of a fetch")
+	repairCmd.PersistentFlags().StringSlice("cid-codecs", []string{}, "only extract CIDs with these codecs from the error file (e.g. dag-cbor,raw), any if empty")
+	repairCmd.PersistentFlags().StringSlice("cid-multihashes", []string{}, "only extract CIDs with these multihash functions from the error file (e.g. blake2b-256), any if empty")
 
 	viper.BindPFlag("repair.local_blockstore_path", repairCmd.PersistentFlags().Lookup("local-blockstore-path"))
 	viper.BindPFlag("repair.gateway_api_url", repairCmd.PersistentFlags().Lookup("gateway-api-url"))
 	viper.BindPFlag("repair.auth_token_path", repairCmd.PersistentFlags().Lookup("auth-token-path"))
 	viper.BindPFlag("repair.error_file_path", repairCmd.PersistentFlags().Lookup("error-file-path"))
 	viper.BindPFlag("repair.missing_cids", repairCmd.PersistentFlags().Lookup("missing-cids"))
+	viper.BindPFlag("repair.all_cids", repairCmd.PersistentFlags().Lookup("all-cids"))
+	viper.BindPFlag("repair.max_depth", repairCmd.PersistentFlags().Lookup("max-depth"))
+	viper.BindPFlag("repair.max_objects", repairCmd.PersistentFlags().Lookup("max-objects"))
+	viper.BindPFlag("repair.fetch_concurrency", repairCmd.PersistentFlags().Lookup("fetch-concurrency"))
+	viper.BindPFlag("repair.fetch_rate_limit", repairCmd.PersistentFlags().Lookup("fetch-rate-limit"))
+	viper.BindPFlag("repair.fetch_max_retries", repairCmd.PersistentFlags().Lookup("fetch-max-retries"))
+	viper.BindPFlag("repair.fetch_retry_min_delay", repairCmd.PersistentFlags().Lookup("fetch-retry-min-delay"))
+	viper.BindPFlag("repair.fetch_retry_max_delay", repairCmd.PersistentFlags().Lookup("fetch-retry-max-delay"))
+	viper.BindPFlag("repair.cid_codecs", repairCmd.PersistentFlags().Lookup("cid-codecs"))
+	viper.BindPFlag("repair.cid_multihashes", repairCmd.PersistentFlags().Lookup("cid-multihashes"))
 }
@@ -17,11 +17,14 @@ package cmd
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"runtime"
 	"strings"
 
 	"github.com/vulcanize/lotus-utils/pkg/attestation"
+	"github.com/vulcanize/lotus-utils/pkg/prom"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -33,6 +36,8 @@ var (
 	envFile        string
 	subCommand     string
 	logWithCommand log.Entry
+	// promServer serves the prometheus metrics if they are served over http, shut down with the attestation server
+	promServer *http.Server
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -53,21 +58,17 @@ func Execute() {
 func initFuncs(cmd *cobra.Command, args []string) {
 	logInit()
 
-	// TODO: add metrics
-	/*
-		if viper.GetBool("metrics") {
-			prom.Init()
-		}
+	if viper.GetBool("metrics") {
+		prom.Init()
+	}
 
-		if viper.GetBool("prom.http") {
-			addr := fmt.Sprintf(
-				"%s:%s",
-				viper.GetString("prom.http.addr"),
-				viper.GetString("prom.http.port"),
-			)
-			prom.Serve(addr)
-		}
-	*/
+	if viper.GetBool("prom.http") {
+		addr := net.JoinHostPort(
+			viper.GetString("prom.http.addr"),
+			viper.GetString("prom.http.port"),
+		)
+		promServer = prom.Serve(addr)
+	}
 }
 
 func init() {
@@ -84,20 +85,17 @@ func init() {
 	viper.BindPFlag(attestation.LOG_LEVEL_TOML, rootCmd.PersistentFlags().Lookup("log-level"))
 	viper.BindPFlag(attestation.LOG_FILE_TOML, rootCmd.PersistentFlags().Lookup("log-file"))
 
-	// TODO: add metrics
-	/*
-		rootCmd.PersistentFlags().Bool("metrics", false, "enable metrics")
+	rootCmd.PersistentFlags().Bool("metrics", false, "enable metrics")
 
-		rootCmd.PersistentFlags().Bool("prom-http", false, "enable http service for prometheus")
-		rootCmd.PersistentFlags().String("prom-http-addr", "127.0.0.1", "http host for prometheus")
-		rootCmd.PersistentFlags().String("prom-http-port", "8090", "http port for prometheus")
+	rootCmd.PersistentFlags().Bool("prom-http", false, "enable http service for prometheus")
+	rootCmd.PersistentFlags().String("prom-http-addr", "127.0.0.1", "http host for prometheus")
+	rootCmd.PersistentFlags().String("prom-http-port", "8090", "http port for prometheus")
 
-		viper.BindPFlag("metrics", rootCmd.PersistentFlags().Lookup("metrics"))
+	viper.BindPFlag("metrics", rootCmd.PersistentFlags().Lookup("metrics"))
 
-		viper.BindPFlag("prom.http", rootCmd.PersistentFlags().Lookup("prom-http"))
-		viper.BindPFlag("prom.http.addr", rootCmd.PersistentFlags().Lookup("prom-http-addr"))
-		viper.BindPFlag("prom.http.port", rootCmd.PersistentFlags().Lookup("prom-http-port"))
-	*/
+	viper.BindPFlag("prom.http", rootCmd.PersistentFlags().Lookup("prom-http"))
+	viper.BindPFlag("prom.http.addr", rootCmd.PersistentFlags().Lookup("prom-http-addr"))
+	viper.BindPFlag("prom.http.port", rootCmd.PersistentFlags().Lookup("prom-http-port"))
 }
 
 // initConfig reads in config file and ENV variables if set.
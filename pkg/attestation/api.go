@@ -1,27 +1,49 @@
 package attestation
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/sirupsen/logrus"
+
 	"github.com/vulcanize/lotus-utils/pkg/types"
 )
 
 var _ types.API = (*API)(nil)
 
-// API is kind of an unnecessary abstraction since this only wraps a single backing struct, but it will make it easier
-// to extend the API in the future (add and use new backing components)
+// maxMessagesRange is the largest number of epochs GetMessages returns rows for in a single request
+const maxMessagesRange = 100
+
+// API wraps the checksum repository, and optionally the checksummer for serving on-demand checksums and msgindex.db rows
 type API struct {
-	backend types.ChecksumRepository
+	backend  types.ChecksumRepository
+	cs       types.Checksummer
+	backfill *Backfiller
+	jobs     *checksumJobs
+	broker   *ChecksumBroker
+	status   func() types.Status
+	resume   func() error
+	merkle   merkleRepository
+}
+
+// merkleRepository is implemented by checksum repositories that keep a Merkle tree over their consecutive chunk
+// checksums from epoch 0
+type merkleRepository interface {
+	MerkleRoot(algorithm string, size uint) (types.MerkleRoot, error)
+	InclusionProof(start, stop uint, algorithm string, size uint) (types.InclusionProof, error)
 }
 
 // NewAPI returns a new API object
-func NewAPI(repo types.ChecksumRepository) *API {
-	return &API{backend: repo}
+// the checksummer may be nil, in which case only the published checksums are served
+func NewAPI(repo types.ChecksumRepository, cs types.Checksummer) *API {
+	a := &API{backend: repo, cs: cs}
+	a.merkle, _ = repo.(merkleRepository)
+	return a
 }
 
 // ChecksumExists returns true if the given checksum is published in the backing checksum repository
-func (a API) ChecksumExists(hash string, res *bool) error {
-	exists, err := a.backend.ChecksumExists(hash)
+func (a API) ChecksumExists(req types.ChecksumExistsRequest, res *bool) error {
+	exists, err := a.backend.ChecksumExists(req.Hash, req.Algorithm)
 	if err != nil {
 		return err
 	}
@@ -29,18 +51,277 @@ func (a API) ChecksumExists(hash string, res *bool) error {
 	return nil
 }
 
-// GetChecksum returns the checksum for the given start and stop values
-func (a API) GetChecksum(rng types.GetChecksumRequest, res *string) error {
-	if rng.Stop-rng.Stop != a.backend.Interval() {
+// validateChunk checks that the range is a chunk of the repo's interval
+func (a API) validateChunk(start, stop uint) error {
+	if stop-start != a.backend.Interval() {
 		return fmt.Errorf("checksum expected to span an interval of size %d", a.backend.Interval())
 	}
-	if rng.Stop%a.backend.Interval() != 0 {
-		return fmt.Errorf("checksum range must start at a multiple of the interval size %d", a.backend.Interval())
+	// chunks are inclusive of both the start and stop epoch, so each one begins one epoch after the previous stop
+	if start%(a.backend.Interval()+1) != 0 {
+		return fmt.Errorf("checksum range must start at a multiple of the interval size + 1 (%d)", a.backend.Interval()+1)
+	}
+	return nil
+}
+
+// computesOnDemand returns whether the server can compute checksums with the given algorithm on demand
+func (a API) computesOnDemand(algorithm string) bool {
+	return a.jobs != nil && (algorithm == "" || algorithm == a.cs.Algorithm())
+}
+
+// GetChecksum returns the checksum for the given start and stop values, optionally filtered by algorithm
+// if the checksum is not published yet but the range is final and fully populated in msgindex.db, it is computed and
+// published, waiting up to lazyChecksumTimeout for it; an empty checksum is returned if it is not available by then
+func (a API) GetChecksum(rng types.GetChecksumRequest, res *string) error {
+	if err := a.validateChunk(rng.Start, rng.Stop); err != nil {
+		return err
 	}
-	hash, err := a.backend.GetChecksum(rng.Start, rng.Stop)
+	hash, err := a.backend.GetChecksum(rng.Start, rng.Stop, rng.Algorithm)
 	if err != nil {
 		return err
 	}
+	if hash == "" && !rng.NoCompute && a.computesOnDemand(rng.Algorithm) {
+		job, err := a.jobs.schedule(rng.Start, rng.Stop)
+		switch {
+		case errors.Is(err, errRangeNotPopulated), errors.Is(err, errRangeNotFinal):
+		case err != nil:
+			return err
+		default:
+			state := a.jobs.wait(job, lazyChecksumTimeout)
+			if state.Status == types.ChecksumJobFailed {
+				return fmt.Errorf("checksum job %s failed: %s", state.ID, state.Error)
+			}
+			hash = state.Hash
+		}
+	}
 	*res = hash
 	return nil
 }
+
+// RequestChecksum has the checksum for a chunk computed and published if it is not published yet
+// it waits up to the requested timeout for the job to finish, the job can be looked up by ID with GetChecksumJob after
+func (a API) RequestChecksum(req types.RequestChecksumRequest, res *types.ChecksumJob) error {
+	if err := a.validateChunk(req.Start, req.Stop); err != nil {
+		return err
+	}
+	if a.jobs == nil {
+		return fmt.Errorf("this server does not compute checksums on demand")
+	}
+	if !a.computesOnDemand(req.Algorithm) {
+		return fmt.Errorf("this server computes %s checksums, not %s", a.cs.Algorithm(), req.Algorithm)
+	}
+	hash, err := a.backend.GetChecksum(req.Start, req.Stop, a.cs.Algorithm())
+	if err != nil {
+		return err
+	}
+	if hash != "" {
+		*res = types.ChecksumJob{
+			Start:     req.Start,
+			Stop:      req.Stop,
+			Algorithm: a.cs.Algorithm(),
+			Status:    types.ChecksumJobDone,
+			Hash:      hash,
+		}
+		return nil
+	}
+	job, err := a.jobs.schedule(req.Start, req.Stop)
+	if err != nil {
+		return err
+	}
+	*res = a.jobs.wait(job, req.Timeout)
+	return nil
+}
+
+// GetChecksumJob returns the state of a checksum job started by RequestChecksum or GetChecksum
+func (a API) GetChecksumJob(req types.GetChecksumJobRequest, res *types.ChecksumJob) error {
+	if a.jobs == nil {
+		return fmt.Errorf("this server does not compute checksums on demand")
+	}
+	job, ok := a.jobs.get(req.ID)
+	if !ok {
+		return fmt.Errorf("no checksum job found with ID %s", req.ID)
+	}
+	*res = job
+	return nil
+}
+
+// GetAttestation returns the checksum for the given start and stop values along with its signer and signature, if any
+// if no algorithm is requested and the range was checksummed with several, the first by algorithm name is returned
+func (a API) GetAttestation(rng types.GetChecksumRequest, res *types.Checksum) error {
+	checksums, err := a.backend.ListChecksums(types.ListChecksumsRequest{
+		Algorithm: rng.Algorithm,
+		From:      rng.Start,
+		To:        rng.Stop,
+	})
+	if err != nil {
+		return err
+	}
+	for _, checksum := range checksums {
+		if checksum.Start == rng.Start && checksum.Stop == rng.Stop {
+			*res = checksum
+			return nil
+		}
+	}
+	return fmt.Errorf("no checksum found for range %d to %d", rng.Start, rng.Stop)
+}
+
+// GetMerkleRoot returns the root of the Merkle tree over the consecutive chunk checksums from epoch 0
+func (a API) GetMerkleRoot(req types.MerkleRootRequest, res *types.MerkleRoot) error {
+	if a.merkle == nil {
+		return errors.New("this server does not keep a Merkle tree of its checksums")
+	}
+	root, err := a.merkle.MerkleRoot(req.Algorithm, req.Size)
+	if err != nil {
+		return err
+	}
+	*res = root
+	return nil
+}
+
+// GetInclusionProof returns a proof that the checksum for the given range is included in the Merkle tree over the
+// consecutive chunk checksums from epoch 0
+func (a API) GetInclusionProof(req types.InclusionProofRequest, res *types.InclusionProof) error {
+	if a.merkle == nil {
+		return errors.New("this server does not keep a Merkle tree of its checksums")
+	}
+	proof, err := a.merkle.InclusionProof(req.Start, req.Stop, req.Algorithm, req.Size)
+	if err != nil {
+		return err
+	}
+	*res = proof
+	return nil
+}
+
+// GetComparisons returns the recorded results of comparing our checksums with our peers' checksums
+func (a API) GetComparisons(req types.ListComparisonsRequest, res *[]types.Comparison) error {
+	comparisons, err := a.backend.ListComparisons(req)
+	if err != nil {
+		return err
+	}
+	*res = comparisons
+	return nil
+}
+
+// GetBackfillStatus returns the progress of backfilling gaps in the checksum repository
+func (a API) GetBackfillStatus(req types.BackfillStatusRequest, res *types.BackfillStatus) error {
+	if a.backfill == nil {
+		return fmt.Errorf("this attestation server does not backfill gaps in its checksum repository")
+	}
+	*res = a.backfill.Status()
+	return nil
+}
+
+// GetStatus returns the state of the attestation service and its checksumming loop
+func (a API) GetStatus(req types.StatusRequest, res *types.Status) error {
+	if a.status == nil {
+		return fmt.Errorf("this attestation server does not report its status")
+	}
+	*res = a.status()
+	return nil
+}
+
+// ComputeChecksum computes the checksum for an arbitrary range (inclusive) from the local msgindex.db, without publishing it
+// the range may span at most the interval size, and is used to bisect diverging chunks
+// the range must be final, and the computation shares the concurrency limit of the on-demand checksum jobs
+func (a API) ComputeChecksum(rng types.GetChecksumRequest, res *string) error {
+	if a.jobs == nil {
+		return fmt.Errorf("this server does not compute checksums on demand")
+	}
+	if rng.Algorithm != "" && rng.Algorithm != a.cs.Algorithm() {
+		return fmt.Errorf("this server computes %s checksums, not %s", a.cs.Algorithm(), rng.Algorithm)
+	}
+	if rng.Start > rng.Stop || rng.Stop-rng.Start > a.backend.Interval() {
+		return fmt.Errorf("range must be ordered and span at most the interval size %d", a.backend.Interval())
+	}
+	var checksum types.Checksum
+	err := a.jobs.compute(rng.Start, rng.Stop, func() (err error) {
+		checksum, err = a.cs.Checksum(rng.Start, rng.Stop)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	*res = checksum.Hash
+	return nil
+}
+
+// GetMessages returns the local msgindex.db rows for the range (inclusive), which may span at most maxMessagesRange epochs
+// the range must be final, and the read shares the concurrency limit of the on-demand checksum jobs
+func (a API) GetMessages(req types.GetMessagesRequest, res *[]types.MessageRow) error {
+	if a.jobs == nil {
+		return fmt.Errorf("this server does not serve msgindex.db rows")
+	}
+	if req.Start > req.Stop || req.Stop-req.Start >= maxMessagesRange {
+		return fmt.Errorf("range must be ordered and span at most %d epochs", maxMessagesRange)
+	}
+	var messages []types.MessageRow
+	err := a.jobs.compute(req.Start, req.Stop, func() (err error) {
+		messages, err = a.cs.Messages(req.Start, req.Stop)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	*res = messages
+	return nil
+}
+
+// The admin methods below are unexported so that they are not served over net/rpc, which cannot check permissions
+// per method; they are only served over JSON-RPC, to callers with the admin permission
+
+// recomputeChecksum has the checksum for a chunk computed and published again, replacing the published checksum
+// it waits up to the requested timeout for the job to finish
+func (a API) recomputeChecksum(req types.RequestChecksumRequest) (types.ChecksumJob, error) {
+	if err := a.validateChunk(req.Start, req.Stop); err != nil {
+		return types.ChecksumJob{}, err
+	}
+	if a.jobs == nil {
+		return types.ChecksumJob{}, fmt.Errorf("this server does not compute checksums on demand")
+	}
+	if !a.computesOnDemand(req.Algorithm) {
+		return types.ChecksumJob{}, fmt.Errorf("this server computes %s checksums, not %s", a.cs.Algorithm(), req.Algorithm)
+	}
+	job, err := a.jobs.schedule(req.Start, req.Stop)
+	if err != nil {
+		return types.ChecksumJob{}, err
+	}
+	return a.jobs.wait(job, req.Timeout), nil
+}
+
+// triggerBackfill searches the checksum repository for gaps now, instead of waiting for the next periodic search
+// the gaps found are queued for the checksumming loop to backfill
+func (a API) triggerBackfill() (types.BackfillStatus, error) {
+	if a.backfill == nil {
+		return types.BackfillStatus{}, fmt.Errorf("this attestation server does not backfill gaps in its checksum repository")
+	}
+	if _, err := a.backfill.FindGaps(); err != nil {
+		return types.BackfillStatus{}, err
+	}
+	return a.backfill.Status(), nil
+}
+
+// resumeChecksumming resumes the checksumming loop after the retry policy paused it on an error
+func (a API) resumeChecksumming() (types.Status, error) {
+	if a.resume == nil || a.status == nil {
+		return types.Status{}, fmt.Errorf("this attestation server does not have a checksumming loop to resume")
+	}
+	if err := a.resume(); err != nil {
+		return types.Status{}, err
+	}
+	return a.status(), nil
+}
+
+// deleteChecksum deletes the published checksum for a range, optionally only the one produced by the given algorithm
+// a deleted chunk is left as a gap, to be recomputed by the backfill or on demand
+func (a API) deleteChecksum(req types.DeleteChecksumRequest) (bool, error) {
+	if req.Start > req.Stop {
+		return false, fmt.Errorf("start (%d) is higher than stop (%d) epoch", req.Start, req.Stop)
+	}
+	deleted, err := a.backend.DeleteChecksum(req.Start, req.Stop, req.Algorithm)
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		logrus.Warnf("deleted the published checksum for range %d to %d", req.Start, req.Stop)
+	}
+	return deleted, nil
+}
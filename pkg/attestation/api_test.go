@@ -1 +1,306 @@
 package attestation
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation/mocks"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// newTestChecksums returns n consecutive chunk checksums of the given interval
+func newTestChecksums(n int, interval uint) []types.Checksum {
+	checksums := make([]types.Checksum, n)
+	for i := range checksums {
+		digest := sha3.Sum256([]byte(fmt.Sprintf("chunk %d", i)))
+		start := uint(i) * (interval + 1)
+		checksums[i] = types.Checksum{
+			Start:         start,
+			Stop:          start + interval,
+			Hash:          hex.EncodeToString(digest[:]),
+			Algorithm:     SHA3_256.String(),
+			FormatVersion: uint(ChecksumFormatVersion),
+		}
+	}
+	return checksums
+}
+
+// newTestMockRepo returns a mock repo with the given checksums published
+func newTestMockRepo(t *testing.T, interval uint, checksums []types.Checksum) *mocks.Repo {
+	t.Helper()
+	repo := mocks.NewRepo(interval, nil)
+	for _, checksum := range checksums {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// newTestAPI returns an API backed by a mock repo with n consecutive chunks of the given interval
+func newTestAPI(t *testing.T, n int, interval uint) (*API, []types.Checksum) {
+	t.Helper()
+	checksums := newTestChecksums(n, interval)
+	return NewAPI(newTestMockRepo(t, interval, checksums), nil), checksums
+}
+
+// newTestPublishedRepo returns a sqlite repo with the given checksums published, which keeps a Merkle tree over them
+func newTestPublishedRepo(t *testing.T, interval uint, checksums []types.Checksum) *Repo {
+	t.Helper()
+	repo, _, err := NewRepo(t.TempDir(), interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	for _, checksum := range checksums {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// newTestMerkleAPI returns an API backed by a sqlite repo with n consecutive chunks of the given interval
+func newTestMerkleAPI(t *testing.T, n int, interval uint) (*API, []types.Checksum) {
+	t.Helper()
+	checksums := newTestChecksums(n, interval)
+	return NewAPI(newTestPublishedRepo(t, interval, checksums), nil), checksums
+}
+
+func TestGetChecksum(t *testing.T) {
+	api, checksums := newTestAPI(t, 3, 10)
+	var hash string
+	if err := api.GetChecksum(types.GetChecksumRequest{Start: 11, Stop: 21}, &hash); err != nil {
+		t.Fatal(err)
+	}
+	if hash != checksums[1].Hash {
+		t.Fatalf("expected checksum %s, got %s", checksums[1].Hash, hash)
+	}
+	if err := api.GetChecksum(types.GetChecksumRequest{Start: 10, Stop: 20}, &hash); err == nil {
+		t.Fatal("expected an error for a range that is not chunk aligned")
+	}
+}
+
+func TestInclusionProofs(t *testing.T) {
+	api, checksums := newTestMerkleAPI(t, 7, 10)
+	for size := 1; size <= len(checksums); size++ {
+		var root types.MerkleRoot
+		if err := api.GetMerkleRoot(types.MerkleRootRequest{Size: uint(size)}, &root); err != nil {
+			t.Fatal(err)
+		}
+		if root.Size != uint(size) || root.Start != 0 || root.Stop != checksums[size-1].Stop {
+			t.Fatalf("unexpected root %+v for size %d", root, size)
+		}
+		for i := 0; i < size; i++ {
+			var proof types.InclusionProof
+			req := types.InclusionProofRequest{Start: checksums[i].Start, Stop: checksums[i].Stop, Size: uint(size)}
+			if err := api.GetInclusionProof(req, &proof); err != nil {
+				t.Fatal(err)
+			}
+			if proof.Root != root.Root {
+				t.Fatalf("proof root %s does not match tree root %s", proof.Root, root.Root)
+			}
+			if err := VerifyInclusionProof(proof); err != nil {
+				t.Fatalf("size %d index %d: %v", size, i, err)
+			}
+			proof.Checksum.Hash = checksums[(i+1)%len(checksums)].Hash
+			if err := VerifyInclusionProof(proof); err == nil {
+				t.Fatalf("size %d index %d: expected a tampered proof to fail verification", size, i)
+			}
+		}
+	}
+}
+
+func TestMerkleRootPrefixes(t *testing.T) {
+	api, _ := newTestMerkleAPI(t, 5, 10)
+	var full, prefix types.MerkleRoot
+	if err := api.GetMerkleRoot(types.MerkleRootRequest{}, &full); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.GetMerkleRoot(types.MerkleRootRequest{Size: 4}, &prefix); err != nil {
+		t.Fatal(err)
+	}
+	if full.Size != 5 || full.Root == prefix.Root {
+		t.Fatalf("expected distinct roots for different tree sizes, got %+v and %+v", full, prefix)
+	}
+	// a peer with the same first four chunks has the same prefix root
+	other, _ := newTestMerkleAPI(t, 4, 10)
+	var otherPrefix types.MerkleRoot
+	if err := other.GetMerkleRoot(types.MerkleRootRequest{Size: 4}, &otherPrefix); err != nil {
+		t.Fatal(err)
+	}
+	if otherPrefix.Root != prefix.Root {
+		t.Fatalf("expected matching prefix roots, got %s and %s", otherPrefix.Root, prefix.Root)
+	}
+	if err := api.GetMerkleRoot(types.MerkleRootRequest{Size: 6}, &prefix); err == nil {
+		t.Fatal("expected an error for a tree size larger than the number of chunks")
+	}
+	// a repo without a Merkle tree says so
+	mockAPI, _ := newTestAPI(t, 5, 10)
+	if err := mockAPI.GetMerkleRoot(types.MerkleRootRequest{}, &prefix); err == nil {
+		t.Fatal("expected an error for a repo that does not keep a Merkle tree")
+	}
+}
+
+// blockingChecksummer counts the checksums it computes, and holds them until released
+type blockingChecksummer struct {
+	types.Checksummer
+	release chan struct{}
+	mu      sync.Mutex
+	calls   int
+}
+
+func (b *blockingChecksummer) Checksum(start, stop uint) (types.Checksum, error) {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	<-b.release
+	return b.Checksummer.Checksum(start, stop)
+}
+
+func TestRequestChecksum(t *testing.T) {
+	local, err := NewChecksummer(newTestMsgIndex(t, newTestRowsForEpochs(0, 14)), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	cs := &blockingChecksummer{Checksummer: local, release: make(chan struct{})}
+	repo := mocks.NewRepo(4, nil)
+	service, err := NewService(cs, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := service.api
+
+	// concurrent requests for the same chunk are coalesced into a single job
+	jobs := make([]types.ChecksumJob, 5)
+	wg := new(sync.WaitGroup)
+	for i := range jobs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := api.RequestChecksum(types.RequestChecksumRequest{Start: 0, Stop: 4}, &jobs[i]); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, job := range jobs {
+		if job.ID == "" || job.ID != jobs[0].ID || job.Status != types.ChecksumJobPending {
+			t.Fatalf("expected a single pending job, got %+v and %+v", jobs[0], job)
+		}
+	}
+	close(cs.release)
+	var job types.ChecksumJob
+	waitFor(t, "the checksum job", func() bool {
+		return api.GetChecksumJob(types.GetChecksumJobRequest{ID: jobs[0].ID}, &job) == nil && job.Status == types.ChecksumJobDone
+	})
+	expected, err := local.Checksum(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Hash != expected.Hash || cs.calls != 1 {
+		t.Fatalf("expected one computation of checksum %s, got %d computations of %s", expected.Hash, cs.calls, job.Hash)
+	}
+	if hash, _ := repo.GetChecksum(0, 4, SHA3_256.String()); hash != expected.Hash {
+		t.Fatalf("expected the checksum to be published, got %s", hash)
+	}
+	// requesting a published checksum returns it without a job
+	if err := api.RequestChecksum(types.RequestChecksumRequest{Start: 0, Stop: 4}, &job); err != nil || job.ID != "" || job.Hash != expected.Hash {
+		t.Fatalf("expected the published checksum without a job, got %+v (err: %v)", job, err)
+	}
+
+	// GetChecksum does not compute a missing checksum if asked not to
+	var hash string
+	if err := api.GetChecksum(types.GetChecksumRequest{Start: 5, Stop: 9, NoCompute: true}, &hash); err != nil || hash != "" || cs.calls != 1 {
+		t.Fatalf("expected no checksum and no computation, got %s after %d computations (err: %v)", hash, cs.calls, err)
+	}
+	// otherwise it computes a missing checksum and waits for it
+	if err := api.GetChecksum(types.GetChecksumRequest{Start: 5, Stop: 9}, &hash); err != nil {
+		t.Fatal(err)
+	}
+	expected, err = local.Checksum(5, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != expected.Hash {
+		t.Fatalf("expected checksum %s, got %s", expected.Hash, hash)
+	}
+	// but not for a range that is not populated
+	if err := api.GetChecksum(types.GetChecksumRequest{Start: 15, Stop: 19}, &hash); err != nil || hash != "" {
+		t.Fatalf("expected no checksum for an unpopulated range, got %s (err: %v)", hash, err)
+	}
+	if err := api.RequestChecksum(types.RequestChecksumRequest{Start: 15, Stop: 19}, &job); err == nil {
+		t.Fatal("expected an error requesting a checksum for an unpopulated range")
+	}
+	if err := api.RequestChecksum(types.RequestChecksumRequest{Start: 10, Stop: 14, Algorithm: SHA256.String()}, &job); err == nil {
+		t.Fatal("expected an error requesting a checksum with a different algorithm")
+	}
+}
+
+func TestRequestChecksumLimits(t *testing.T) {
+	local, err := NewChecksummer(newTestMsgIndex(t, newTestRowsForEpochs(0, 99)), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	cs := &blockingChecksummer{Checksummer: local, release: make(chan struct{})}
+	service, err := NewService(cs, mocks.NewRepo(4, nil), nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.follow(mocks.NewGateway(0), 10)
+	service.follower.setHead(20)
+	api := service.api
+
+	// chunks that are not final are neither computed on request nor lazily
+	var job types.ChecksumJob
+	if err := api.RequestChecksum(types.RequestChecksumRequest{Start: 15, Stop: 19}, &job); !errors.Is(err, errRangeNotFinal) {
+		t.Fatalf("expected an error requesting a checksum for a range that is not final, got %v", err)
+	}
+	var hash string
+	if err := api.GetChecksum(types.GetChecksumRequest{Start: 15, Stop: 19}, &hash); err != nil || hash != "" {
+		t.Fatalf("expected no checksum for a range that is not final, got %s (err: %v)", hash, err)
+	}
+	// nor read for bisection
+	if err := api.ComputeChecksum(types.GetChecksumRequest{Start: 15, Stop: 19}, &hash); !errors.Is(err, errRangeNotFinal) {
+		t.Fatalf("expected an error computing a checksum for a range that is not final, got %v", err)
+	}
+	var messages []types.MessageRow
+	if err := api.GetMessages(types.GetMessagesRequest{Start: 15, Stop: 19}, &messages); !errors.Is(err, errRangeNotFinal) {
+		t.Fatalf("expected an error reading the messages of a range that is not final, got %v", err)
+	}
+	// a checksum with another algorithm is refused before it is computed
+	if err := api.ComputeChecksum(types.GetChecksumRequest{Start: 0, Stop: 4, Algorithm: SHA256.String()}, &hash); err == nil || cs.calls != 0 {
+		t.Fatalf("expected an error without computing a checksum, got %v after %d computations", err, cs.calls)
+	}
+
+	// the number of pending jobs is bounded
+	service.follower.setHead(200)
+	for start := uint(0); start < maxPendingChecksumJobs*5; start += 5 {
+		if err := api.RequestChecksum(types.RequestChecksumRequest{Start: start, Stop: start + 4}, &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := types.RequestChecksumRequest{Start: maxPendingChecksumJobs * 5, Stop: maxPendingChecksumJobs*5 + 4}
+	if err := api.RequestChecksum(next, &job); !errors.Is(err, errTooManyChecksumJobs) {
+		t.Fatalf("expected an error with too many pending jobs, got %v", err)
+	}
+	// the bisection methods share the limit
+	if err := api.ComputeChecksum(types.GetChecksumRequest{Start: 100, Stop: 104}, &hash); !errors.Is(err, errTooManyChecksumJobs) {
+		t.Fatalf("expected an error computing a checksum with too many pending jobs, got %v", err)
+	}
+	if err := api.GetMessages(types.GetMessagesRequest{Start: 100, Stop: 104}, &messages); !errors.Is(err, errTooManyChecksumJobs) {
+		t.Fatalf("expected an error reading messages with too many pending jobs, got %v", err)
+	}
+	close(cs.release)
+	waitFor(t, "the pending jobs to finish", func() bool {
+		return api.RequestChecksum(next, &job) == nil
+	})
+	service.Close()
+}
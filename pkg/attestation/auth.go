@@ -0,0 +1,136 @@
+package attestation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// API permissions, each permission includes the ones before it
+const (
+	// PermRead allows reading checksums, attestations, comparisons and the state of the service
+	PermRead auth.Permission = "read"
+	// PermAdmin allows triggering recomputation, backfills and deletions
+	PermAdmin auth.Permission = "admin"
+)
+
+// AllPermissions are the permissions a token can be granted, in increasing order of privilege
+var AllPermissions = []auth.Permission{PermRead, PermAdmin}
+
+// authSecretSize is the size in bytes of the HMAC secret API tokens are signed with
+const authSecretSize = 32
+
+var errAuthNotConfigured = errors.New("token authentication is not configured on this server")
+
+// JWTPayload is the payload of an API token, as in Lotus
+type JWTPayload struct {
+	Allow []auth.Permission
+}
+
+// PermissionsFor returns the permissions granted by a token for the given permission level
+func PermissionsFor(perm string) ([]auth.Permission, error) {
+	for i, p := range AllPermissions {
+		if string(p) == perm {
+			return AllPermissions[:i+1], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown permission %q, expected one of %v", perm, AllPermissions)
+}
+
+// LoadOrCreateAuthSecret reads the hex encoded API token secret from the file at the given path
+// if the file does not exist a new random secret is generated and written to it, readable only by the owner
+func LoadOrCreateAuthSecret(path string) ([]byte, error) {
+	encoded, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil {
+			return nil, fmt.Errorf("decode auth secret %s: %w", path, err)
+		}
+		if len(secret) < authSecretSize {
+			return nil, fmt.Errorf("auth secret %s is shorter than %d bytes", path, authSecretSize)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read auth secret: %w", err)
+	}
+	secret := make([]byte, authSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(secret)), 0600); err != nil {
+		return nil, fmt.Errorf("write auth secret: %w", err)
+	}
+	return secret, nil
+}
+
+// Authenticator verifies the bearer tokens of API requests, and creates tokens
+type Authenticator struct {
+	alg *jwt.HMACSHA
+	// defaultPerms are the permissions of requests without a token
+	defaultPerms []auth.Permission
+}
+
+// NewAuthenticator creates an Authenticator for tokens signed with the given secret
+// requests without a token get read permission, unless requireToken is set
+// if the secret is empty no tokens are accepted, so admin methods cannot be called
+func NewAuthenticator(secret []byte, requireToken bool) *Authenticator {
+	a := &Authenticator{defaultPerms: []auth.Permission{PermRead}}
+	if len(secret) > 0 {
+		a.alg = jwt.NewHS256(secret)
+	}
+	if requireToken {
+		a.defaultPerms = nil
+	}
+	return a
+}
+
+// CreateToken creates a token granting the given permissions
+func (a *Authenticator) CreateToken(perms []auth.Permission) (string, error) {
+	if a.alg == nil {
+		return "", errAuthNotConfigured
+	}
+	token, err := jwt.Sign(&JWTPayload{Allow: perms}, a.alg)
+	return string(token), err
+}
+
+// Verify returns the permissions granted by the token
+func (a *Authenticator) Verify(ctx context.Context, token string) ([]auth.Permission, error) {
+	if a.alg == nil {
+		return nil, errAuthNotConfigured
+	}
+	var payload JWTPayload
+	if _, err := jwt.Verify([]byte(token), a.alg, &payload); err != nil {
+		return nil, fmt.Errorf("verify token: %w", err)
+	}
+	return payload.Allow, nil
+}
+
+// DefaultPermissions returns the permissions of requests without a token
+func (a *Authenticator) DefaultPermissions() []auth.Permission {
+	return a.defaultPerms
+}
+
+// Handler authenticates requests by their bearer token, or token query parameter, before passing them to next
+// requests with an invalid token are rejected, and requests without the required permission are rejected if it is set;
+// the token's permissions are added to the request context for the per-method checks of the permissioned JSON-RPC API
+func (a *Authenticator) Handler(required auth.Permission, next http.Handler) http.Handler {
+	return &auth.Handler{
+		Verify: a.Verify,
+		Next: func(w http.ResponseWriter, r *http.Request) {
+			if required != "" && !auth.HasPerm(r.Context(), a.defaultPerms, required) {
+				http.Error(w, fmt.Sprintf("missing permission %q", required), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		},
+	}
+}
@@ -0,0 +1,223 @@
+package attestation
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func TestLoadOrCreateAuthSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.secret")
+	secret, err := LoadOrCreateAuthSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the secret to be readable only by the owner, got mode %s", info.Mode())
+	}
+	loaded, err := LoadOrCreateAuthSecret(path)
+	if err != nil || string(loaded) != string(secret) {
+		t.Fatalf("expected the secret to be loaded again (err: %v)", err)
+	}
+	if err := os.WriteFile(path, []byte("abcd"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateAuthSecret(path); err == nil {
+		t.Fatal("expected an error for a short secret")
+	}
+}
+
+func TestPermissionsFor(t *testing.T) {
+	perms, err := PermissionsFor("admin")
+	if err != nil || len(perms) != 2 || perms[0] != PermRead || perms[1] != PermAdmin {
+		t.Fatalf("expected admin to include read, got %v (err: %v)", perms, err)
+	}
+	if _, err := PermissionsFor("write"); err == nil {
+		t.Fatal("expected an error for an unknown permission")
+	}
+}
+
+// newTestAuthService returns a service over the given checksums that accepts tokens, and read and admin tokens for it
+func newTestAuthService(t *testing.T, checksums []types.Checksum, requireToken bool) (*Service, string, string) {
+	t.Helper()
+	service, err := NewService(nil, newTestMockRepo(t, 4, checksums), nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := LoadOrCreateAuthSecret(filepath.Join(t.TempDir(), "auth.secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.auth = NewAuthenticator(secret, requireToken)
+	tokens := make([]string, 2)
+	for i, perm := range []string{"read", "admin"} {
+		perms, err := PermissionsFor(perm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens[i], err = service.auth.CreateToken(perms); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return service, tokens[0], tokens[1]
+}
+
+func TestJSONRPCPermissions(t *testing.T) {
+	checksums := newTestChecksums(4, 4)
+	service, readToken, adminToken := newTestAuthService(t, checksums, false)
+	rpcServer := jsonrpc.NewServer()
+	service.RegisterJSONRPC(rpcServer.Register)
+	ts := httptest.NewServer(service.AuthHandler("", rpcServer))
+	defer ts.Close()
+
+	newClient := func(token string) types.AttestationAPI {
+		t.Helper()
+		header := http.Header{}
+		if token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+		client, closer, err := NewJSONRPCClient(context.Background(), ts.URL+JSONRPCPath, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(closer)
+		return client
+	}
+	deleteReq := types.DeleteChecksumRequest{Start: 10, Stop: 14}
+	for name, token := range map[string]string{"anonymous": "", "read": readToken} {
+		client := newClient(token)
+		if hash, err := client.GetChecksum(context.Background(), types.GetChecksumRequest{Start: 5, Stop: 9}); err != nil || hash != checksums[1].Hash {
+			t.Fatalf("%s: expected checksum %s, got %s (err: %v)", name, checksums[1].Hash, hash, err)
+		}
+		if _, err := client.DeleteChecksum(context.Background(), deleteReq); err == nil || !strings.Contains(err.Error(), "missing permission") {
+			t.Fatalf("%s: expected a missing permission error, got %v", name, err)
+		}
+	}
+	deleted, err := newClient(adminToken).DeleteChecksum(context.Background(), deleteReq)
+	if err != nil || !deleted {
+		t.Fatalf("expected the admin to delete the checksum (err: %v)", err)
+	}
+	if hash, err := service.r.GetChecksum(10, 14, ""); err != nil || hash != "" {
+		t.Fatalf("expected the checksum to be deleted, got %s (err: %v)", hash, err)
+	}
+	if _, err := newClient("not-a-token").GetChecksum(context.Background(), types.GetChecksumRequest{Start: 5, Stop: 9}); err == nil {
+		t.Fatal("expected an invalid token to be rejected")
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	checksums := newTestChecksums(2, 4)
+	service, readToken, _ := newTestAuthService(t, checksums, true)
+	mux := http.NewServeMux()
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, service.api); err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle(rpc.DefaultRPCPath, service.AuthHandler(PermRead, server))
+	mux.Handle(RESTPathPrefix, service.AuthHandler(PermRead, service.RESTHandler()))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// REST
+	res, err := http.Get(ts.URL + "/v1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status %d without a token, got %d", http.StatusUnauthorized, res.StatusCode)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+readToken)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d with a read token, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	// net/rpc
+	anonymous := NewPeer(ts.Listener.Addr().String())
+	defer anonymous.Close()
+	if _, err := anonymous.GetChecksum(types.GetChecksumRequest{Start: 0, Stop: 4}); err == nil {
+		t.Fatal("expected a peer without a token to be rejected")
+	}
+	authenticated := NewPeerWithOptions(ts.Listener.Addr().String(), PeerOptions{Token: readToken})
+	defer authenticated.Close()
+	if hash, err := authenticated.GetChecksum(types.GetChecksumRequest{Start: 0, Stop: 4}); err != nil || hash != checksums[0].Hash {
+		t.Fatalf("expected checksum %s, got %s (err: %v)", checksums[0].Hash, hash, err)
+	}
+}
+
+func TestPeerSendsTokenInHeader(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	requests := make(chan *http.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		requests <- req
+		io.WriteString(conn, "HTTP/1.0 "+rpcConnected+"\n\n")
+	}()
+	peer := NewPeerWithOptions(ln.Addr().String(), PeerOptions{Token: "secret-token"})
+	defer peer.Close()
+	go peer.GetChecksum(types.GetChecksumRequest{Start: 0, Stop: 4})
+	var req *http.Request
+	select {
+	case req = <-requests:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the peer to connect")
+	}
+	if req.Header.Get("Authorization") != "Bearer secret-token" {
+		t.Fatalf("expected the token in the Authorization header, got %q", req.Header.Get("Authorization"))
+	}
+	if strings.Contains(req.RequestURI, "secret-token") {
+		t.Fatalf("expected the token not to be sent in the request URI %s", req.RequestURI)
+	}
+}
+
+func TestAuthenticatorWithoutSecret(t *testing.T) {
+	a := NewAuthenticator(nil, false)
+	if _, err := a.CreateToken([]auth.Permission{PermRead}); err == nil {
+		t.Fatal("expected an error creating a token without a secret")
+	}
+	if _, err := a.Verify(context.Background(), "token"); err == nil {
+		t.Fatal("expected an error verifying a token without a secret")
+	}
+	if perms := a.DefaultPermissions(); len(perms) != 1 || perms[0] != PermRead {
+		t.Fatalf("expected anonymous read permission, got %v", perms)
+	}
+}
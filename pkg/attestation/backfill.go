@@ -0,0 +1,120 @@
+package attestation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+const defaultGapCheckInterval = time.Hour
+
+// Backfiller queues the chunk ranges missing between the checksums published in the checksum repository
+// e.g. because rows were deleted or a previous offline run skipped them, so they can be checksummed again
+type Backfiller struct {
+	repo types.ChecksumRepository
+	// origin is the start epoch of the first chunk, the chunks missing from it on are queued
+	origin   uint
+	interval uint
+
+	mu            sync.Mutex
+	pending       [][2]uint
+	queued        map[[2]uint]struct{}
+	backfilled    uint
+	skipped       uint
+	lastCheckedAt int64
+}
+
+// NewBackfiller creates a new Backfiller for chunks of the given interval, starting at the origin epoch
+func NewBackfiller(repo types.ChecksumRepository, origin, interval uint) *Backfiller {
+	return &Backfiller{repo: repo, origin: origin, interval: interval, queued: make(map[[2]uint]struct{})}
+}
+
+// FindGaps searches the checksum repository for gaps from the origin up to the last published checksum, and queues the
+// chunks that make them up, including those missing before the first published checksum
+// it returns the number of newly queued chunks
+func (b *Backfiller) FindGaps() (int, error) {
+	next, err := b.repo.FindNextChecksum()
+	if err != nil {
+		return 0, err
+	}
+	var gaps [][2]uint
+	if next > b.origin {
+		gaps, err = b.repo.FindGaps(int(b.origin), int(next)-1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastCheckedAt = time.Now().Unix()
+	var queued int
+	for _, gap := range gaps {
+		for start := gap[0]; start+b.interval <= gap[1]; start += b.interval + 1 {
+			rng := [2]uint{start, start + b.interval}
+			if _, ok := b.queued[rng]; ok {
+				continue
+			}
+			b.queued[rng] = struct{}{}
+			b.pending = append(b.pending, rng)
+			queued++
+		}
+	}
+	if queued > 0 {
+		logrus.Infof("queued %d chunks from %d gaps in the checksum repository for backfilling", queued, len(gaps))
+	}
+	return queued, nil
+}
+
+// Next returns the next queued chunk range, false if the queue is empty
+func (b *Backfiller) Next() ([2]uint, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return [2]uint{}, false
+	}
+	rng := b.pending[0]
+	b.pending = b.pending[1:]
+	delete(b.queued, rng)
+	return rng, true
+}
+
+// Requeue puts a chunk range returned by Next back at the front of the queue, e.g. after failing to backfill it
+func (b *Backfiller) Requeue(rng [2]uint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.queued[rng]; ok {
+		return
+	}
+	b.queued[rng] = struct{}{}
+	b.pending = append([][2]uint{rng}, b.pending...)
+}
+
+// Done records the outcome of backfilling a chunk range returned by Next
+func (b *Backfiller) Done(rng [2]uint, backfilled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if backfilled {
+		b.backfilled++
+		logrus.Infof("backfilled checksum for range %d to %d", rng[0], rng[1])
+		return
+	}
+	b.skipped++
+	logrus.Warnf("could not backfill range %d to %d, it is not fully populated in msgindex.db", rng[0], rng[1])
+}
+
+// Status returns the current backfill status
+func (b *Backfiller) Status() types.BackfillStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending := make([][2]uint, len(b.pending))
+	copy(pending, b.pending)
+	return types.BackfillStatus{
+		Pending:       pending,
+		Backfilled:    b.backfilled,
+		Skipped:       b.skipped,
+		LastCheckedAt: b.lastCheckedAt,
+	}
+}
@@ -0,0 +1,81 @@
+package attestation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation/mocks"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func TestBackfiller(t *testing.T) {
+	repo := mocks.NewRepo(2, nil)
+	for _, checksum := range []types.Checksum{
+		{Start: 0, Stop: 2, Hash: "aa"},
+		{Start: 9, Stop: 11, Hash: "bb"},
+		{Start: 15, Stop: 17, Hash: "cc"},
+	} {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backfill := NewBackfiller(repo, 0, 2)
+	queued, err := backfill.FindGaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != 3 {
+		t.Fatalf("expected 3 queued chunks, got %d", queued)
+	}
+	// searching again does not queue the same chunks twice
+	if queued, err := backfill.FindGaps(); err != nil || queued != 0 {
+		t.Fatalf("expected no newly queued chunks, got %d (err: %v)", queued, err)
+	}
+	status := backfill.Status()
+	if fmt.Sprint(status.Pending) != "[[3 5] [6 8] [12 14]]" || status.LastCheckedAt == 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	rng, ok := backfill.Next()
+	if !ok || rng != [2]uint{3, 5} {
+		t.Fatalf("expected next range [3 5], got %v", rng)
+	}
+	backfill.Done(rng, true)
+	rng, _ = backfill.Next()
+	backfill.Done(rng, false)
+	status = backfill.Status()
+	if fmt.Sprint(status.Pending) != "[[12 14]]" || status.Backfilled != 1 || status.Skipped != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestBackfillerQueuesLeadingChunks(t *testing.T) {
+	repo := mocks.NewRepo(2, nil)
+	for _, checksum := range []types.Checksum{
+		{Start: 0, Stop: 2, Hash: "aa"},
+		{Start: 3, Stop: 5, Hash: "bb"},
+		{Start: 6, Stop: 8, Hash: "cc"},
+	} {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first chunks are deleted by an operator
+	for _, rng := range [][2]uint{{0, 2}, {3, 5}} {
+		if deleted, err := repo.DeleteChecksum(rng[0], rng[1], ""); err != nil || !deleted {
+			t.Fatalf("expected range %v to be deleted (err: %v)", rng, err)
+		}
+	}
+	backfill := NewBackfiller(repo, 0, 2)
+	if queued, err := backfill.FindGaps(); err != nil || queued != 2 {
+		t.Fatalf("expected 2 queued chunks, got %d (err: %v)", queued, err)
+	}
+	if pending := backfill.Status().Pending; fmt.Sprint(pending) != "[[0 2] [3 5]]" {
+		t.Fatalf("expected the deleted chunks to be queued, got %v", pending)
+	}
+
+	// an empty repository has nothing to backfill
+	if queued, err := NewBackfiller(mocks.NewRepo(2, nil), 0, 2).FindGaps(); err != nil || queued != 0 {
+		t.Fatalf("expected no queued chunks, got %d (err: %v)", queued, err)
+	}
+}
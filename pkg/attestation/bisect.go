@@ -0,0 +1,198 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// bisectionSide is one side of a bisection, able to checksum arbitrary sub-ranges and list the msgindex.db rows for them
+type bisectionSide interface {
+	ComputeChecksum(rng types.GetChecksumRequest) (string, error)
+	GetMessages(rng types.GetMessagesRequest) ([]types.MessageRow, error)
+}
+
+var _ bisectionSide = (*localSide)(nil)
+
+// localSide adapts the local checksummer to a bisectionSide
+type localSide struct {
+	cs types.Checksummer
+}
+
+func (l localSide) ComputeChecksum(rng types.GetChecksumRequest) (string, error) {
+	checksum, err := l.cs.Checksum(rng.Start, rng.Stop)
+	if err != nil {
+		return "", err
+	}
+	if rng.Algorithm != "" && rng.Algorithm != checksum.Algorithm {
+		return "", fmt.Errorf("local checksummer computes %s checksums, not %s", checksum.Algorithm, rng.Algorithm)
+	}
+	return checksum.Hash, nil
+}
+
+func (l localSide) GetMessages(rng types.GetMessagesRequest) ([]types.MessageRow, error) {
+	return l.cs.Messages(rng.Start, rng.Stop)
+}
+
+// TipSetMismatch is a message that both sides index, but under a different tipset or epoch
+type TipSetMismatch struct {
+	Cid    string
+	Local  types.MessageRow
+	Remote types.MessageRow
+}
+
+// DivergenceReport is the result of bisecting a range whose checksum differs from a peer's
+type DivergenceReport struct {
+	Peer      string
+	Start     uint
+	Stop      uint
+	Algorithm string
+	// Epochs are the single epochs whose checksums differ
+	Epochs []uint
+	// Truncated is set if bisection stopped early after reaching the maximum number of diverging epochs
+	Truncated bool
+	// LocalOnly are the messages we index that the peer does not
+	LocalOnly []types.MessageRow
+	// RemoteOnly are the messages the peer indexes that we do not
+	RemoteOnly       []types.MessageRow
+	TipSetMismatches []TipSetMismatch
+}
+
+// Bisector narrows a diverging range down to the offending epochs and message CIDs by having both sides checksum
+// halves of the range on demand until single epochs remain, and then exchanging the message rows for those epochs
+type Bisector struct {
+	local bisectionSide
+	peer  types.Peer
+	algo  HashAlgorithm
+	// maxEpochs bounds the number of diverging epochs that are resolved, zero is unlimited
+	maxEpochs int
+}
+
+// NewBisector creates a new Bisector between the local checksummer and the peer
+func NewBisector(cs types.Checksummer, peer types.Peer, algo HashAlgorithm, maxEpochs int) *Bisector {
+	if algo == "" {
+		algo = defaultHashAlgorithm
+	}
+	return &Bisector{local: localSide{cs: cs}, peer: peer, algo: algo, maxEpochs: maxEpochs}
+}
+
+// Bisect finds the epochs within the range (inclusive) whose checksums differ from the peer's, and diffs the message
+// rows of those epochs
+func (b *Bisector) Bisect(ctx context.Context, start, stop uint) (DivergenceReport, error) {
+	report := DivergenceReport{Peer: b.peer.ID(), Start: start, Stop: stop, Algorithm: b.algo.String()}
+	if start > stop {
+		return report, fmt.Errorf("start epoch cannot be greater than stop epoch")
+	}
+	if err := b.bisect(ctx, start, stop, &report); err != nil {
+		return report, err
+	}
+	logrus.Infof("bisection of range %d to %d against peer %s found %d diverging epochs",
+		start, stop, b.peer.ID(), len(report.Epochs))
+	for _, epoch := range report.Epochs {
+		if err := b.diffEpoch(epoch, &report); err != nil {
+			return report, err
+		}
+	}
+	reconcileMovedMessages(&report)
+	return report, nil
+}
+
+func (b *Bisector) bisect(ctx context.Context, start, stop uint, report *DivergenceReport) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if b.maxEpochs > 0 && len(report.Epochs) >= b.maxEpochs {
+		report.Truncated = true
+		return nil
+	}
+	rng := types.GetChecksumRequest{Start: start, Stop: stop, Algorithm: b.algo.String()}
+	local, err := b.local.ComputeChecksum(rng)
+	if err != nil {
+		return fmt.Errorf("compute local checksum for range %d to %d: %w", start, stop, err)
+	}
+	remote, err := b.peer.ComputeChecksum(rng)
+	if err != nil {
+		return fmt.Errorf("compute checksum for range %d to %d on peer %s: %w", start, stop, b.peer.ID(), err)
+	}
+	if local == remote {
+		return nil
+	}
+	if start == stop {
+		report.Epochs = append(report.Epochs, start)
+		return nil
+	}
+	mid := start + (stop-start)/2
+	if err := b.bisect(ctx, start, mid, report); err != nil {
+		return err
+	}
+	return b.bisect(ctx, mid+1, stop, report)
+}
+
+// diffEpoch compares the message rows each side indexes for the epoch
+func (b *Bisector) diffEpoch(epoch uint, report *DivergenceReport) error {
+	rng := types.GetMessagesRequest{Start: epoch, Stop: epoch}
+	local, err := b.local.GetMessages(rng)
+	if err != nil {
+		return fmt.Errorf("get local messages for epoch %d: %w", epoch, err)
+	}
+	remote, err := b.peer.GetMessages(rng)
+	if err != nil {
+		return fmt.Errorf("get messages for epoch %d from peer %s: %w", epoch, b.peer.ID(), err)
+	}
+	remoteByCid := make(map[string]types.MessageRow, len(remote))
+	for _, row := range remote {
+		remoteByCid[row.Cid] = row
+	}
+	for _, row := range local {
+		r, ok := remoteByCid[row.Cid]
+		if !ok {
+			report.LocalOnly = append(report.LocalOnly, row)
+			continue
+		}
+		delete(remoteByCid, row.Cid)
+		if r != row {
+			report.TipSetMismatches = append(report.TipSetMismatches, TipSetMismatch{Cid: row.Cid, Local: row, Remote: r})
+		}
+	}
+	remoteOnly := make([]types.MessageRow, 0, len(remoteByCid))
+	for _, row := range remoteByCid {
+		remoteOnly = append(remoteOnly, row)
+	}
+	sortMessageRows(remoteOnly)
+	report.RemoteOnly = append(report.RemoteOnly, remoteOnly...)
+	return nil
+}
+
+// reconcileMovedMessages turns messages that each side indexes at a different diverging epoch into tipset mismatches
+func reconcileMovedMessages(report *DivergenceReport) {
+	remoteByCid := make(map[string]types.MessageRow, len(report.RemoteOnly))
+	for _, row := range report.RemoteOnly {
+		remoteByCid[row.Cid] = row
+	}
+	localOnly := report.LocalOnly[:0]
+	for _, row := range report.LocalOnly {
+		if r, ok := remoteByCid[row.Cid]; ok {
+			report.TipSetMismatches = append(report.TipSetMismatches, TipSetMismatch{Cid: row.Cid, Local: row, Remote: r})
+			delete(remoteByCid, row.Cid)
+			continue
+		}
+		localOnly = append(localOnly, row)
+	}
+	report.LocalOnly = localOnly
+	remoteOnly := report.RemoteOnly[:0]
+	for _, row := range report.RemoteOnly {
+		if _, ok := remoteByCid[row.Cid]; ok {
+			remoteOnly = append(remoteOnly, row)
+		}
+	}
+	report.RemoteOnly = remoteOnly
+	sort.Slice(report.TipSetMismatches, func(i, j int) bool {
+		return report.TipSetMismatches[i].Local.Epoch < report.TipSetMismatches[j].Local.Epoch ||
+			(report.TipSetMismatches[i].Local.Epoch == report.TipSetMismatches[j].Local.Epoch &&
+				report.TipSetMismatches[i].Cid < report.TipSetMismatches[j].Cid)
+	})
+}
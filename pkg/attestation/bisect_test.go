@@ -0,0 +1,111 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation/mocks"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func newTestRowsForEpochs(start, stop int64) []types.MessageRow {
+	var rows []types.MessageRow
+	for epoch := start; epoch <= stop; epoch++ {
+		for i := 0; i < 2; i++ {
+			rows = append(rows, types.MessageRow{
+				Cid:       fmt.Sprintf("msg-%d-%d", epoch, i),
+				TipSetCid: fmt.Sprintf("ts-%d", epoch),
+				Epoch:     epoch,
+			})
+		}
+	}
+	return rows
+}
+
+// newTestChecksumPeer starts an in-process attestation server that computes checksums over the given msgindex.db rows
+func newTestChecksumPeer(t *testing.T, interval uint, rows []types.MessageRow) types.Peer {
+	t.Helper()
+	cs, err := NewChecksummer(newTestMsgIndex(t, rows), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cs.Close() })
+	service, err := NewService(cs, mocks.NewRepo(interval, nil), nil, 0, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, service.api); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	peer := NewPeer(ts.Listener.Addr().String())
+	t.Cleanup(func() { peer.Close() })
+	return peer
+}
+
+func TestBisect(t *testing.T) {
+	local := newTestRowsForEpochs(0, 20)
+	remote := newTestRowsForEpochs(0, 20)
+	// the peer is missing a message at epoch 5
+	remote = append(remote[:10], remote[11:]...)
+	// maps a message at epoch 13 to a different tipset
+	remote[25].TipSetCid = "ts-other"
+	// and has an extra message at epoch 17
+	remote = append(remote, types.MessageRow{Cid: "msg-extra", TipSetCid: "ts-17", Epoch: 17})
+
+	cs, err := NewChecksummer(newTestMsgIndex(t, local), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	peer := newTestChecksumPeer(t, 20, remote)
+
+	report, err := NewBisector(cs, peer, SHA3_256, 0).Bisect(context.Background(), 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(report.Epochs) != "[5 13 17]" {
+		t.Fatalf("expected diverging epochs [5 13 17], got %v", report.Epochs)
+	}
+	if len(report.LocalOnly) != 1 || report.LocalOnly[0].Cid != "msg-5-0" {
+		t.Fatalf("unexpected local only messages %+v", report.LocalOnly)
+	}
+	if len(report.RemoteOnly) != 1 || report.RemoteOnly[0].Cid != "msg-extra" {
+		t.Fatalf("unexpected remote only messages %+v", report.RemoteOnly)
+	}
+	if len(report.TipSetMismatches) != 1 || report.TipSetMismatches[0].Remote.TipSetCid != "ts-other" {
+		t.Fatalf("unexpected tipset mismatches %+v", report.TipSetMismatches)
+	}
+
+	report, err = NewBisector(cs, peer, SHA3_256, 1).Bisect(context.Background(), 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Epochs) != 1 || !report.Truncated {
+		t.Fatalf("expected bisection to stop after one epoch, got %+v", report)
+	}
+}
+
+func TestBisectMovedMessage(t *testing.T) {
+	local := newTestRowsForEpochs(0, 3)
+	remote := newTestRowsForEpochs(0, 3)
+	remote[2].Epoch = 2
+
+	cs, err := NewChecksummer(newTestMsgIndex(t, local), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	report, err := NewBisector(cs, newTestChecksumPeer(t, 20, remote), SHA3_256, 0).Bisect(context.Background(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.LocalOnly) != 0 || len(report.RemoteOnly) != 0 || len(report.TipSetMismatches) != 1 {
+		t.Fatalf("expected the moved message to be reported as a single mismatch, got %+v", report)
+	}
+}
@@ -0,0 +1,164 @@
+package attestation
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+const (
+	// subscriptionBufferSize is how many published checksums a subscriber may fall behind by before it is dropped
+	subscriptionBufferSize = 1024
+	// replayPageSize is how many checksums are read from checksums.db at a time when replaying a subscription
+	replayPageSize = 1000
+)
+
+// ChecksumBroker fans out the checksums published by the service to subscribers
+type ChecksumBroker struct {
+	mu     sync.Mutex
+	subs   map[chan types.Checksum]struct{}
+	closed bool
+}
+
+// NewChecksumBroker creates a new ChecksumBroker
+func NewChecksumBroker() *ChecksumBroker {
+	return &ChecksumBroker{subs: make(map[chan types.Checksum]struct{})}
+}
+
+// Publish sends the checksum to every subscriber without blocking
+// a subscriber that has fallen subscriptionBufferSize checksums behind is dropped and its channel closed,
+// it can resubscribe and resume from the last epoch it received
+func (b *ChecksumBroker) Publish(checksum types.Checksum) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub <- checksum:
+		default:
+			logrus.Warnf("dropping checksum subscriber that fell %d checksums behind", subscriptionBufferSize)
+			delete(b.subs, sub)
+			close(sub)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every checksum published from now on, and a function that cancels the
+// subscription and closes the channel
+func (b *ChecksumBroker) Subscribe() (<-chan types.Checksum, func()) {
+	sub := make(chan types.Checksum, subscriptionBufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub)
+		return sub, func() {}
+	}
+	b.subs[sub] = struct{}{}
+	return sub, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			close(sub)
+		}
+	}
+}
+
+// Close ends every subscription, later subscriptions are closed immediately
+func (b *ChecksumBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub)
+	}
+}
+
+// SubscribeChecksums streams the checksums published through the broker, optionally filtered by algorithm
+// if req.Resume is set, the checksums in the repository starting at or after req.From are replayed first, so a
+// reconnecting subscriber receives what it missed; a checksum published while the replay is running is not delivered
+// again if it was on the last replayed page, which holds the chunks at the head that are being published, but an
+// earlier chunk republished during the replay (e.g. after a reorg or a backfill) may be
+// the returned channel is closed when the context is cancelled, the broker is closed, or the subscriber falls behind
+func SubscribeChecksums(ctx context.Context, repo types.ChecksumRepository, broker *ChecksumBroker, req types.SubscribeChecksumsRequest) (<-chan types.Checksum, error) {
+	// subscribe before replaying, so nothing published during the replay is missed
+	live, cancel := broker.Subscribe()
+	var replay []types.Checksum
+	if req.Resume {
+		// read the first page up front, so a broken repository fails the subscription instead of ending the stream
+		var err error
+		replay, err = repo.ListChecksums(types.ListChecksumsRequest{Algorithm: req.Algorithm, From: req.From,
+			Limit: replayPageSize, AfterStart: req.AfterStart, AfterAlgorithm: req.AfterAlgorithm})
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	out := make(chan types.Checksum)
+	go func() {
+		defer close(out)
+		defer cancel()
+		send := func(checksum types.Checksum) bool {
+			select {
+			case out <- checksum:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// only the last page is kept to dedupe the live checksums against, so the replay is not held in memory
+		var replayed map[types.Checksum]struct{}
+		for len(replay) > 0 {
+			replayed = make(map[types.Checksum]struct{}, len(replay))
+			for _, checksum := range replay {
+				if !send(checksum) {
+					return
+				}
+				replayed[checksum] = struct{}{}
+			}
+			if len(replay) < replayPageSize {
+				break
+			}
+			last := replay[len(replay)-1]
+			var err error
+			replay, err = repo.ListChecksums(types.ListChecksumsRequest{Algorithm: req.Algorithm, From: req.From,
+				Limit: replayPageSize, AfterStart: last.Start, AfterAlgorithm: last.Algorithm})
+			if err != nil {
+				logrus.Errorf("replay checksums for subscriber: %s", err.Error())
+				return
+			}
+		}
+		// only the checksums buffered while replaying can have been replayed already
+		backlog := len(live)
+		for {
+			if backlog == 0 {
+				replayed = nil
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case checksum, ok := <-live:
+				backlog--
+				if !ok {
+					return
+				}
+				if req.Algorithm != "" && checksum.Algorithm != req.Algorithm {
+					continue
+				}
+				if req.Resume && checksum.Start < req.From {
+					continue
+				}
+				if _, ok := replayed[checksum]; ok {
+					continue
+				}
+				if !send(checksum) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
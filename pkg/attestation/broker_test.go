@@ -0,0 +1,135 @@
+package attestation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func receive(t *testing.T, sub <-chan types.Checksum) (types.Checksum, bool) {
+	t.Helper()
+	select {
+	case checksum, ok := <-sub:
+		return checksum, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a checksum")
+	}
+	return types.Checksum{}, false
+}
+
+func TestSubscribeChecksums(t *testing.T) {
+	checksums := newTestChecksums(6, 4)
+	repo := newTestMockRepo(t, 4, checksums[:4])
+	broker := NewChecksumBroker()
+	defer broker.Close()
+	publish := func(checksum types.Checksum) {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+		broker.Publish(checksum)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	live, err := SubscribeChecksums(ctx, repo, broker, types.SubscribeChecksumsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := SubscribeChecksums(ctx, repo, broker, types.SubscribeChecksumsRequest{Resume: true, From: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := SubscribeChecksums(ctx, repo, broker, types.SubscribeChecksumsRequest{Algorithm: SHA256.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// published after the resumed subscriber read its replay from the repo, so they are delivered live after it
+	publish(checksums[4])
+	publish(checksums[5])
+
+	for _, expected := range checksums[2:] {
+		if checksum, _ := receive(t, resumed); checksum.Hash != expected.Hash {
+			t.Fatalf("expected resumed checksum %s, got %s", expected.Hash, checksum.Hash)
+		}
+	}
+	for _, expected := range checksums[4:] {
+		if checksum, _ := receive(t, live); checksum.Hash != expected.Hash {
+			t.Fatalf("expected live checksum %s, got %s", expected.Hash, checksum.Hash)
+		}
+	}
+	sha256Checksum := types.Checksum{Start: 0, Stop: 4, Hash: "ab", Algorithm: SHA256.String(), FormatVersion: 1}
+	publish(sha256Checksum)
+	if checksum, _ := receive(t, filtered); checksum.Hash != "ab" {
+		t.Fatalf("expected sha256 checksum ab, got %s", checksum.Hash)
+	}
+	// the resumed subscriber skips checksums below its from epoch
+	publish(types.Checksum{Start: 30, Stop: 34, Hash: "cd", Algorithm: SHA3_256.String(), FormatVersion: 1})
+	if checksum, _ := receive(t, resumed); checksum.Hash != "cd" {
+		t.Fatalf("expected checksum cd, got %s", checksum.Hash)
+	}
+
+	// cancelling the context ends the subscription
+	cancel()
+	for {
+		if _, ok := receive(t, live); !ok {
+			break
+		}
+	}
+	// closing the broker ends new subscriptions immediately
+	broker.Close()
+	closed, err := SubscribeChecksums(context.Background(), repo, broker, types.SubscribeChecksumsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := receive(t, closed); ok {
+		t.Fatal("expected the subscription to a closed broker to be closed")
+	}
+}
+
+func TestSubscribeChecksumsDedupesReplay(t *testing.T) {
+	checksums := newTestChecksums(replayPageSize+2, 4)
+	repo := newTestMockRepo(t, 4, checksums[:replayPageSize+1])
+	broker := NewChecksumBroker()
+	defer broker.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resumed, err := SubscribeChecksums(ctx, repo, broker, types.SubscribeChecksumsRequest{Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// published before the second page is read, so it is both replayed and delivered live
+	if err := repo.PublishChecksum(checksums[replayPageSize+1]); err != nil {
+		t.Fatal(err)
+	}
+	broker.Publish(checksums[replayPageSize+1])
+	for _, expected := range checksums {
+		if checksum, _ := receive(t, resumed); checksum != expected {
+			t.Fatalf("expected checksum %+v, got %+v", expected, checksum)
+		}
+	}
+	select {
+	case checksum := <-resumed:
+		t.Fatalf("expected every checksum to be delivered once, got %+v again", checksum)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestChecksumBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := NewChecksumBroker()
+	defer broker.Close()
+	sub, cancel := broker.Subscribe()
+	defer cancel()
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		broker.Publish(types.Checksum{Start: uint(i)})
+	}
+	for i := 0; i < subscriptionBufferSize; i++ {
+		if checksum, ok := <-sub; !ok || checksum.Start != uint(i) {
+			t.Fatalf("expected buffered checksum %d, got %d (open: %t)", i, checksum.Start, ok)
+		}
+	}
+	if _, ok := <-sub; ok {
+		t.Fatal("expected the slow subscriber to be dropped")
+	}
+}
@@ -2,34 +2,37 @@ package attestation
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"fmt"
-	"os"
 	"path/filepath"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
 
+	"github.com/vulcanize/lotus-utils/pkg/prom"
 	"github.com/vulcanize/lotus-utils/pkg/types"
 )
 
 var _ types.Checksummer = (*CheckSummer)(nil)
 
 type CheckSummer struct {
-	tempDir   string
 	srcDB     *sql.DB
 	srcDBPath string
+	algo      HashAlgorithm
 }
 
 var (
-	messagesDB               = "msgindex.db"
-	msgIndexMigrateStmt      = "INSERT INTO messages SELECT * FROM src.messages WHERE epoch >= ? AND epoch =< ?"
-	sha3sumDotCommand        = ".sha3sum"
+	messagesDB                 = "msgindex.db"
+	selectMessagesForRangeStmt = "SELECT cid, tipset_cid, epoch FROM messages WHERE epoch >= ? AND epoch <= ? " +
+		"ORDER BY epoch, tipset_cid, cid"
 	findMsgIndexGapsBaseStmt = "SELECT epoch + 1 AS first_missing, (next_nc - 1) AS last_missing " +
-		"FROM (SELECT epoch, LEAD(epoch) OVER (ORDER BY epoch) AS next_nc FROM src.messages %s) h " +
+		"FROM (SELECT epoch, LEAD(epoch) OVER (ORDER BY epoch) AS next_nc FROM messages %s) h " +
 		"WHERE next_nc > epoch + 1"
-	doesEpochExistStmt            = "SELECT EXISTS(SELECT 1 FROM src.messages WHERE epoch = ?)"
-	checkRangeIsPopulatedBaseStmt = fmt.Sprintf("SELECT EXISTS(%s)", findMsgIndexGapsBaseStmt)
+	doesEpochExistStmt            = "SELECT EXISTS(SELECT 1 FROM messages WHERE epoch = ?)"
+	checkRangeIsPopulatedBaseStmt = fmt.Sprintf("SELECT NOT EXISTS(%s)", findMsgIndexGapsBaseStmt)
+	findHighestEpochStmt          = "SELECT MAX(epoch) FROM messages"
 )
 
 // from lotus chain/store/sqlite/msgindex.go
@@ -47,71 +50,87 @@ var msgIndexDBDefs = []string{
 	`INSERT OR IGNORE INTO _meta (version) VALUES (1)`,
 }
 
-// NewChecksummer creates a new checksumming object
-func NewChecksummer(srcDir string) (*CheckSummer, error) {
+// NewChecksummer creates a new checksumming object that digests chunks with the given hash algorithm
+func NewChecksummer(srcDir string, algo HashAlgorithm) (*CheckSummer, error) {
 	if srcDir == "" {
 		return nil, xerrors.Errorf("checksummer srcDir path cannot be empty")
 	}
-	// Create a temporary directory to hold the SQLite database file
-	tempDir, err := os.MkdirTemp("", "temp_db")
-	if err != nil {
+	if algo == "" {
+		algo = defaultHashAlgorithm
+	}
+	if _, err := algo.New(); err != nil {
 		return nil, err
 	}
-
 	srcDBPath := filepath.Join(srcDir, messagesDB)
 	srcDB, err := sql.Open("sqlite3", srcDBPath+"?mode=rwc")
 	if err != nil {
 		return nil, err
 	}
 	return &CheckSummer{
-		srcDBPath: filepath.Join(srcDir, messagesDB),
+		srcDBPath: srcDBPath,
 		srcDB:     srcDB,
-		tempDir:   tempDir,
+		algo:      algo,
 	}, nil
 }
 
 // Checksum checksums a chunk defined by the start and stop epochs (inclusive)
+// the rows are streamed out of the src msgindex.db in canonical order and hashed as described by ChecksumFormatVersion,
+// so the result does not depend on the SQLite page layout of the src database
 // this method assumes there are no gaps, so use the FindGaps first beforehand if we can't rely on another guarantee
-func (cs *CheckSummer) Checksum(start, stop uint) (string, error) {
-	// Create the path for the temporary database file
-	tempDBPath := filepath.Join(cs.tempDir, messagesDB)
-	dstMsgDB, err := sql.Open("sqlite3", tempDBPath+"?mode=rwc")
+func (cs *CheckSummer) Checksum(start, stop uint) (types.Checksum, error) {
+	if start > stop {
+		return types.Checksum{}, xerrors.Errorf("start epoch cannot be greater than stop epoch")
+	}
+	begin := time.Now()
+	h, err := cs.algo.New()
 	if err != nil {
-		return "", xerrors.Errorf("open sqlite3 database: %w", err)
+		return types.Checksum{}, err
 	}
-	defer func() {
-		if err := os.RemoveAll(tempDBPath); err != nil {
-			logrus.Errorf("remove temp db: %s", err)
-		}
-	}()
-	defer func() {
-		if err := dstMsgDB.Close(); err != nil {
-			logrus.Errorf("close temp db: %s", err)
-		}
-	}()
-
-	// Create the temporary dst messages table
-	for _, stmt := range msgIndexDBDefs {
-		_, err = dstMsgDB.Exec(stmt)
-		if err != nil {
-			return "", xerrors.Errorf("create temp msgindex schema (stmt: %s): %w", stmt, err)
-		}
+	rows, err := cs.srcDB.Query(selectMessagesForRangeStmt, start, stop)
+	if err != nil {
+		return types.Checksum{}, xerrors.Errorf("query messages for range %d to %d: %w", start, stop, err)
 	}
+	defer rows.Close()
+	writeChunkHeader(h, start, stop)
+	for rows.Next() {
+		var row types.MessageRow
+		if err := rows.Scan(&row.Cid, &row.TipSetCid, &row.Epoch); err != nil {
+			return types.Checksum{}, xerrors.Errorf("scan messages row: %w", err)
+		}
+		writeMessageRow(h, row)
+	}
+	if err := rows.Err(); err != nil {
+		return types.Checksum{}, xerrors.Errorf("iterate messages for range %d to %d: %w", start, stop, err)
+	}
+	prom.ChunkChecksummed(time.Since(begin))
+	return types.Checksum{
+		Start:         start,
+		Stop:          stop,
+		Hash:          hex.EncodeToString(h.Sum(nil)),
+		Algorithm:     cs.algo.String(),
+		FormatVersion: uint(ChecksumFormatVersion),
+	}, nil
+}
 
-	_, err = dstMsgDB.Exec("ATTACH DATABASE ? AS src", cs.srcDBPath)
-	if err != nil {
-		return "", xerrors.Errorf("attach src database: %w", err)
+// Messages returns the msgindex.db rows for the given range (inclusive) in canonical order
+func (cs *CheckSummer) Messages(start, stop uint) ([]types.MessageRow, error) {
+	if start > stop {
+		return nil, xerrors.Errorf("start epoch cannot be greater than stop epoch")
 	}
-	_, err = dstMsgDB.Exec(msgIndexMigrateStmt, start, stop)
+	rows, err := cs.srcDB.Query(selectMessagesForRangeStmt, start, stop)
 	if err != nil {
-		return "", xerrors.Errorf("migrate into dst.messages: %w", err)
+		return nil, xerrors.Errorf("query messages for range %d to %d: %w", start, stop, err)
 	}
-	_, err = dstMsgDB.Exec("DETACH src")
-	if err != nil {
-		return "", xerrors.Errorf("detach src database: %w", err)
+	defer rows.Close()
+	var messages []types.MessageRow
+	for rows.Next() {
+		var row types.MessageRow
+		if err := rows.Scan(&row.Cid, &row.TipSetCid, &row.Epoch); err != nil {
+			return nil, xerrors.Errorf("scan messages row: %w", err)
+		}
+		messages = append(messages, row)
 	}
-	var hash string
-	return hash, dstMsgDB.QueryRow(sha3sumDotCommand).Scan(&hash)
+	return messages, rows.Err()
 }
 
 // CheckRangeIsPopulated checks if the message index table is populated for the given range
@@ -157,6 +176,18 @@ func (cs *CheckSummer) CheckRangeIsPopulated(start, stop uint) (bool, error) {
 	return exists, nil
 }
 
+// HighestEpoch returns the highest epoch indexed in the message index table, false if the table is empty
+func (cs *CheckSummer) HighestEpoch() (uint, bool, error) {
+	var highest sql.NullInt64
+	if err := cs.srcDB.QueryRow(findHighestEpochStmt).Scan(&highest); err != nil {
+		return 0, false, err
+	}
+	if !highest.Valid || highest.Int64 < 0 {
+		return 0, false, nil
+	}
+	return uint(highest.Int64), true, nil
+}
+
 // FindGaps finds the gaps in the message index table
 func (cs *CheckSummer) FindGaps(start, stop int) ([][2]uint, error) {
 	var where string
@@ -187,7 +218,12 @@ func (cs *CheckSummer) FindGaps(start, stop int) ([][2]uint, error) {
 	return gaps, nil
 }
 
+// Algorithm returns the name of the hash algorithm the checksummer digests chunks with
+func (cs *CheckSummer) Algorithm() string {
+	return cs.algo.String()
+}
+
 // Close implements io.Closer
 func (cs *CheckSummer) Close() error {
-	return os.RemoveAll(cs.tempDir)
+	return cs.srcDB.Close()
 }
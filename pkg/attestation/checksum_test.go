@@ -1 +1,194 @@
 package attestation
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+var (
+	testTipSet1 = "bafy2bzacebcbbwdzoq5nyhkj64p5xaqadd6uoblryfzu6xugiksg3baep4qxw"
+	testTipSet2 = "bafy2bzacecq6ukgzpqkxvhohlcfdk2p4rwcsakdkzw5n5ep7wswxesjufqnii"
+	testRows    = []types.MessageRow{
+		{Cid: "bafy2bzaced4zz3uqzglinlxk42wi2gomqri74a4n2662riavy2vl23yt25lgq", TipSetCid: testTipSet2, Epoch: 2},
+		{Cid: "bafy2bzacebupfkdw6th3xn2y2q2hdvd3plosdc6cx5tcnfxbhvm2vxvwpmnde", TipSetCid: testTipSet1, Epoch: 1},
+		{Cid: "bafy2bzacealch3vsex3agbfrs4arf3hgctzd6dqpw3mlecbj5vb2p2vxcy7hy", TipSetCid: testTipSet1, Epoch: 1},
+	}
+)
+
+// golden vectors for ChecksumFormatVersion 1, see README.md
+var checksumGoldenVectors = []struct {
+	name        string
+	algo        HashAlgorithm
+	start, stop uint
+	rows        []types.MessageRow
+	expected    string
+}{
+	{
+		name:     "empty range",
+		algo:     SHA3_256,
+		start:    0,
+		stop:     2880,
+		expected: "c39e54bf99e250893338528eab012ed26a906e6f6dd12ac941c68f9c014b9ea5",
+	},
+	{
+		name:     "single epoch",
+		algo:     SHA3_256,
+		start:    0,
+		stop:     1,
+		rows:     testRows[1:],
+		expected: "766691abfa1344108ed3743c6aa5c03bcbfe56e20272410abe1c1b2c9c9e2057",
+	},
+	{
+		name:     "multiple epochs",
+		algo:     SHA3_256,
+		start:    0,
+		stop:     2,
+		rows:     testRows,
+		expected: "0ce5dac88fd76e9d6af82b934b0a419729090acbb412610fb29d5cd0d37e3bf5",
+	},
+	{
+		// computed independently of this package, from the byte stream documented in format.go, see README.md
+		name:  "second chunk",
+		algo:  SHA3_256,
+		start: 2881,
+		stop:  5761,
+		rows: []types.MessageRow{
+			{Cid: "bafy2bzaced4zz3uqzglinlxk42wi2gomqri74a4n2662riavy2vl23yt25lgq", TipSetCid: testTipSet2, Epoch: 5000},
+		},
+		expected: "edfce18d938b282172356488162193b354a07467873c986f03a6729e50b0a45d",
+	},
+	{
+		name:     "multiple epochs blake2b-256",
+		algo:     BLAKE2B_256,
+		start:    0,
+		stop:     2,
+		rows:     testRows,
+		expected: "37d2c9894dc1c203fc703d96f9ad95388d38542f637c70bfb5fc4c0266ca303a",
+	},
+	{
+		name:     "multiple epochs sha256",
+		algo:     SHA256,
+		start:    0,
+		stop:     2,
+		rows:     testRows,
+		expected: "197553d0bc3af7ac7f76be77d2e30b589008ec0c478e15ba27b0056c5cd910f3",
+	},
+}
+
+// newTestMsgIndex creates a msgindex.db in a temporary directory populated with the given rows
+func newTestMsgIndex(t *testing.T, rows []types.MessageRow) string {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, messagesDB)+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range msgIndexDBDefs {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, row := range rows {
+		if _, err := db.Exec("INSERT INTO messages (cid, tipset_cid, epoch) VALUES (?, ?, ?)",
+			row.Cid, row.TipSetCid, row.Epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestChecksumRows(t *testing.T) {
+	for _, tc := range checksumGoldenVectors {
+		t.Run(tc.name, func(t *testing.T) {
+			hash, err := ChecksumRows(tc.algo, tc.start, tc.stop, tc.rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != tc.expected {
+				t.Fatalf("expected checksum %s, got %s", tc.expected, hash)
+			}
+		})
+	}
+}
+
+func TestParseHashAlgorithm(t *testing.T) {
+	algo, err := ParseHashAlgorithm("")
+	if err != nil || algo != SHA3_256 {
+		t.Fatalf("expected default algorithm %s, got %s (err: %v)", SHA3_256, algo, err)
+	}
+	algo, err = ParseHashAlgorithm("BLAKE2B-256")
+	if err != nil || algo != BLAKE2B_256 {
+		t.Fatalf("expected algorithm %s, got %s (err: %v)", BLAKE2B_256, algo, err)
+	}
+	if _, err := ParseHashAlgorithm("md5"); err == nil {
+		t.Fatal("expected an error for an unsupported algorithm")
+	}
+}
+
+func TestCheckSummerChecksum(t *testing.T) {
+	for _, tc := range checksumGoldenVectors {
+		t.Run(tc.name, func(t *testing.T) {
+			// include a row outside of the range to make sure it is excluded
+			rows := append([]types.MessageRow{{Cid: "outside", TipSetCid: testTipSet1, Epoch: int64(tc.stop) + 1}}, tc.rows...)
+			cs, err := NewChecksummer(newTestMsgIndex(t, rows), tc.algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cs.Close()
+			checksum, err := cs.Checksum(tc.start, tc.stop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checksum.Hash != tc.expected {
+				t.Fatalf("expected checksum %s, got %s", tc.expected, checksum.Hash)
+			}
+			if checksum.Algorithm != tc.algo.String() || checksum.FormatVersion != uint(ChecksumFormatVersion) {
+				t.Fatalf("unexpected algorithm %s or format version %d", checksum.Algorithm, checksum.FormatVersion)
+			}
+		})
+	}
+}
+
+func TestCheckSummerCheckRangeIsPopulated(t *testing.T) {
+	rows := append(newTestRowsForEpochs(0, 4), newTestRowsForEpochs(7, 10)...)
+	cs, err := NewChecksummer(newTestMsgIndex(t, rows), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	for _, tc := range []struct {
+		start, stop uint
+		expected    bool
+	}{
+		{0, 4, true},
+		{7, 10, true},
+		{0, 10, false},
+		{3, 8, false},
+		{8, 12, false},
+	} {
+		populated, err := cs.CheckRangeIsPopulated(tc.start, tc.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if populated != tc.expected {
+			t.Fatalf("expected range %d to %d populated to be %t", tc.start, tc.stop, tc.expected)
+		}
+	}
+	highest, ok, err := cs.HighestEpoch()
+	if err != nil || !ok || highest != 10 {
+		t.Fatalf("expected highest epoch 10, got %d (ok: %t, err: %v)", highest, ok, err)
+	}
+
+	empty, err := NewChecksummer(newTestMsgIndex(t, nil), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	if _, ok, err := empty.HighestEpoch(); err != nil || ok {
+		t.Fatalf("expected no highest epoch for an empty msgindex.db (ok: %t, err: %v)", ok, err)
+	}
+}
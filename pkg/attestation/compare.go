@@ -0,0 +1,128 @@
+package attestation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// Comparer cross-checks our published checksums against the checksums served by our peers
+type Comparer struct {
+	repo  types.ChecksumRepository
+	peers []types.Peer
+	algo  HashAlgorithm
+}
+
+// ComparisonReport summarizes a single comparison round
+type ComparisonReport struct {
+	// Compared is the number of ranges fetched from peers and compared
+	Compared uint
+	Agreed   uint
+	// Missing is the number of ranges the peers did not have a checksum for
+	Missing     uint
+	Divergences []types.Comparison
+	// PeerErrors holds the error that ended the round for each peer that could not be reached
+	PeerErrors map[string]error
+}
+
+// NewComparer creates a new Comparer for the checksums produced with the given algorithm
+func NewComparer(repo types.ChecksumRepository, peers []types.Peer, algo HashAlgorithm) *Comparer {
+	if algo == "" {
+		algo = defaultHashAlgorithm
+	}
+	return &Comparer{repo: repo, peers: peers, algo: algo}
+}
+
+// Compare fetches each peer's checksum for every range we have published, and records whether it agrees with ours
+// ranges that already agreed with a peer are not fetched from it again unless our checksum has since changed
+func (c *Comparer) Compare(ctx context.Context) (ComparisonReport, error) {
+	report := ComparisonReport{PeerErrors: make(map[string]error)}
+	checksums, err := c.repo.ListChecksums(types.ListChecksumsRequest{Algorithm: c.algo.String()})
+	if err != nil {
+		return report, err
+	}
+	for _, peer := range c.peers {
+		if err := c.compareWithPeer(ctx, peer, checksums, &report); err != nil {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			logrus.Errorf("comparison with peer %s failed: %s", peer.ID(), err.Error())
+			report.PeerErrors[peer.ID()] = err
+		}
+	}
+	return report, nil
+}
+
+func (c *Comparer) compareWithPeer(ctx context.Context, peer types.Peer, checksums []types.Checksum, report *ComparisonReport) error {
+	previous, err := c.repo.ListComparisons(types.ListComparisonsRequest{Peer: peer.ID()})
+	if err != nil {
+		return err
+	}
+	agreed := make(map[[2]uint]string, len(previous))
+	for _, p := range previous {
+		if p.Agree && p.Algorithm == c.algo.String() {
+			agreed[[2]uint{p.Start, p.Stop}] = p.LocalHash
+		}
+	}
+	for _, checksum := range checksums {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if hash, ok := agreed[[2]uint{checksum.Start, checksum.Stop}]; ok && hash == checksum.Hash {
+			continue
+		}
+		// a chunk the peer has not published is missing, rather than computed by the peer while we wait
+		remote, err := peer.GetChecksum(types.GetChecksumRequest{
+			Start:     checksum.Start,
+			Stop:      checksum.Stop,
+			Algorithm: checksum.Algorithm,
+			NoCompute: true,
+		})
+		if err != nil {
+			return err
+		}
+		if remote == "" {
+			prom.PeerMissing(peer.ID())
+			report.Missing++
+			continue
+		}
+		comparison := types.Comparison{
+			Peer:       peer.ID(),
+			Start:      checksum.Start,
+			Stop:       checksum.Stop,
+			Algorithm:  checksum.Algorithm,
+			LocalHash:  checksum.Hash,
+			RemoteHash: remote,
+			Agree:      remote == checksum.Hash,
+			CheckedAt:  time.Now().Unix(),
+		}
+		if err := c.repo.RecordComparison(comparison); err != nil {
+			return err
+		}
+		report.Compared++
+		if comparison.Agree {
+			prom.PeerAgreed(peer.ID())
+			report.Agreed++
+			continue
+		}
+		prom.PeerDisagreed(peer.ID())
+		logrus.Warnf("checksum for range %d to %d diverges from peer %s: local %s, remote %s",
+			checksum.Start, checksum.Stop, peer.ID(), checksum.Hash, remote)
+		report.Divergences = append(report.Divergences, comparison)
+	}
+	return nil
+}
+
+// Close implements io.Closer, closing the connections to every peer
+func (c *Comparer) Close() error {
+	errs := make([]error, len(c.peers))
+	for i, peer := range c.peers {
+		errs[i] = peer.Close()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,138 @@
+package attestation
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// newTestPeer starts an in-process attestation server serving the given checksums over net/rpc
+func newTestPeer(t *testing.T, interval uint, checksums []types.Checksum) types.Peer {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, NewAPI(newTestMockRepo(t, interval, checksums), nil)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	peer := NewPeer(ts.Listener.Addr().String())
+	t.Cleanup(func() { peer.Close() })
+	return peer
+}
+
+func TestCompare(t *testing.T) {
+	local := newTestChecksums(3, 10)
+	diverging := newTestChecksums(2, 10)
+	diverging[1].Hash = strings.Repeat("ab", 32)
+
+	repo := newTestMockRepo(t, 10, local)
+	agreeing := newTestPeer(t, 10, local)
+	disagreeing := newTestPeer(t, 10, diverging)
+	comparer := NewComparer(repo, []types.Peer{agreeing, disagreeing}, SHA3_256)
+
+	report, err := comparer.Compare(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Compared != 5 || report.Agreed != 4 || report.Missing != 1 || len(report.PeerErrors) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.Divergences) != 1 {
+		t.Fatalf("expected a single divergence, got %+v", report.Divergences)
+	}
+	d := report.Divergences[0]
+	if d.Peer != disagreeing.ID() || d.Start != local[1].Start || d.LocalHash != local[1].Hash || d.RemoteHash != diverging[1].Hash {
+		t.Fatalf("unexpected divergence %+v", d)
+	}
+
+	var divergences []types.Comparison
+	if err := NewAPI(repo, nil).GetComparisons(types.ListComparisonsRequest{DisagreementsOnly: true}, &divergences); err != nil {
+		t.Fatal(err)
+	}
+	if len(divergences) != 1 || divergences[0] != d {
+		t.Fatalf("expected the divergence to be surfaced through the API, got %+v", divergences)
+	}
+
+	// ranges that already agreed are not fetched again
+	report, err = comparer.Compare(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Compared != 1 || len(report.Divergences) != 1 || report.Missing != 1 {
+		t.Fatalf("unexpected report for second round %+v", report)
+	}
+}
+
+func TestCompareUnreachablePeer(t *testing.T) {
+	repo := newTestMockRepo(t, 10, newTestChecksums(1, 10))
+	peer := NewPeer("127.0.0.1:1")
+	comparer := NewComparer(repo, []types.Peer{peer}, SHA3_256)
+	report, err := comparer.Compare(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PeerErrors[peer.ID()] == nil {
+		t.Fatalf("expected an error for the unreachable peer, got %+v", report)
+	}
+}
+
+// closeRecordingPeer records whether it was closed, and fails to close if err is set
+type closeRecordingPeer struct {
+	types.Peer
+	err    error
+	closed bool
+}
+
+func (p *closeRecordingPeer) Close() error {
+	p.closed = true
+	return p.err
+}
+
+func TestComparerClosesEveryPeer(t *testing.T) {
+	failing := &closeRecordingPeer{err: errors.New("close failed")}
+	other := &closeRecordingPeer{}
+	comparer := NewComparer(newTestMockRepo(t, 10, nil), []types.Peer{failing, other}, SHA3_256)
+	if err := comparer.Close(); err == nil || !strings.Contains(err.Error(), "close failed") {
+		t.Fatalf("expected the peer close error, got %v", err)
+	}
+	if !failing.closed || !other.closed {
+		t.Fatal("expected every peer to be closed after one failed to close")
+	}
+}
+
+func TestCompareDoesNotComputeOnPeer(t *testing.T) {
+	remote, err := NewChecksummer(newTestMsgIndex(t, newTestRowsForEpochs(0, 99)), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	cs := &blockingChecksummer{Checksummer: remote, release: make(chan struct{})}
+	close(cs.release)
+	service, err := NewService(cs, newTestMockRepo(t, 4, nil), nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, service.api); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	peer := NewPeer(ts.Listener.Addr().String())
+	defer peer.Close()
+
+	// the peer could compute the chunk on demand, but it has not published it so it is missing
+	comparer := NewComparer(newTestMockRepo(t, 4, newTestChecksums(1, 4)), []types.Peer{peer}, SHA3_256)
+	report, err := comparer.Compare(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Missing != 1 || report.Compared != 0 || cs.calls != 0 {
+		t.Fatalf("expected the chunk to be missing without being computed, got %+v after %d computations", report, cs.calls)
+	}
+}
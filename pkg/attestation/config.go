@@ -2,6 +2,10 @@ package attestation
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -11,14 +15,45 @@ const (
 	LOG_FILE  = "LOG_FILE"
 	LOG_LEVEL = "LOG_LEVEL"
 
-	SERVER_PORT     = "SERVER_PORT"
-	SUPPORTS_SERVER = "SUPPORTS_SERVER"
+	SERVER_PORT        = "SERVER_PORT"
+	SERVER_HOST        = "SERVER_HOST"
+	SUPPORTS_SERVER    = "SUPPORTS_SERVER"
+	AUTH_SECRET_PATH   = "AUTH_SECRET_PATH"
+	AUTH_REQUIRE_TOKEN = "AUTH_REQUIRE_TOKEN"
+
+	SERVER_TLS_CERT_PATH      = "SERVER_TLS_CERT_PATH"
+	SERVER_TLS_KEY_PATH       = "SERVER_TLS_KEY_PATH"
+	SERVER_TLS_CLIENT_CA_PATH = "SERVER_TLS_CLIENT_CA_PATH"
+	SERVER_SHUTDOWN_TIMEOUT   = "SERVER_SHUTDOWN_TIMEOUT"
 
 	CHECKSUM_DB_DIRECTORY  = "CHECKSUM_DB_DIRECTORY"
 	MSG_INDEX_DB_DIRECTORY = "MSG_INDEX_DB_DIRECTORY"
 
 	SUPPORTS_CHECKSUMMING = "SUPPORTS_CHECKSUMMING"
 	CHECKSUM_CHUNK_SIZE   = "CHECKSUM_CHUNK_SIZE"
+	CHECKSUM_ALGORITHM    = "CHECKSUM_ALGORITHM"
+	SIGNING_KEY_PATH      = "SIGNING_KEY_PATH"
+	CHECKSUM_OFFLINE      = "CHECKSUM_OFFLINE"
+	CHECK_FOR_GAPS        = "CHECK_FOR_GAPS"
+	GAP_CHECK_INTERVAL    = "GAP_CHECK_INTERVAL"
+
+	CHECKSUM_MAX_RETRIES     = "CHECKSUM_MAX_RETRIES"
+	CHECKSUM_RETRY_MIN_DELAY = "CHECKSUM_RETRY_MIN_DELAY"
+	CHECKSUM_RETRY_MAX_DELAY = "CHECKSUM_RETRY_MAX_DELAY"
+	CHECKSUM_ON_FAILURE      = "CHECKSUM_ON_FAILURE"
+
+	LOTUS_API_URL         = "LOTUS_API_URL"
+	LOTUS_AUTH_TOKEN_PATH = "LOTUS_AUTH_TOKEN_PATH"
+	FINALITY_DEPTH        = "FINALITY_DEPTH"
+
+	SUPPORTS_COMPARING      = "SUPPORTS_COMPARING"
+	COMPARE_PEERS           = "COMPARE_PEERS"
+	COMPARE_INTERVAL        = "COMPARE_INTERVAL"
+	COMPARE_AUTH_TOKEN_PATH = "COMPARE_AUTH_TOKEN_PATH"
+	COMPARE_TLS             = "COMPARE_TLS"
+	COMPARE_TLS_CA_PATH     = "COMPARE_TLS_CA_PATH"
+	COMPARE_TLS_CERT_PATH   = "COMPARE_TLS_CERT_PATH"
+	COMPARE_TLS_KEY_PATH    = "COMPARE_TLS_KEY_PATH"
 )
 
 // TOML bindings
@@ -26,14 +61,50 @@ const (
 	LOG_FILE_TOML  = "log.file"
 	LOG_LEVEL_TOML = "log.level"
 
-	SERVER_PORT_TOML     = "server.port"
-	SUPPORTS_SERVER_TOML = "server.on"
+	SERVER_PORT_TOML        = "server.port"
+	SERVER_HOST_TOML        = "server.host"
+	SUPPORTS_SERVER_TOML    = "server.on"
+	AUTH_SECRET_PATH_TOML   = "server.authSecretPath"
+	AUTH_REQUIRE_TOKEN_TOML = "server.requireToken"
+
+	SERVER_TLS_CERT_PATH_TOML      = "server.tlsCertPath"
+	SERVER_TLS_KEY_PATH_TOML       = "server.tlsKeyPath"
+	SERVER_TLS_CLIENT_CA_PATH_TOML = "server.tlsClientCAPath"
+	SERVER_SHUTDOWN_TIMEOUT_TOML   = "server.shutdownTimeout"
 
 	CHECKSUM_DB_DIRECTORY_TOML  = "database.checksumPath"
 	MSG_INDEX_DB_DIRECTORY_TOML = "database.msgIndexPath"
 
 	SUPPORTS_CHECKSUMMING_TOML = "checksum.on"
 	CHECKSUM_CHUNK_SIZE_TOML   = "checksum.chunkSize"
+	CHECKSUM_ALGORITHM_TOML    = "checksum.algorithm"
+	SIGNING_KEY_PATH_TOML      = "checksum.signingKeyPath"
+	CHECKSUM_OFFLINE_TOML      = "checksum.offline"
+	CHECK_FOR_GAPS_TOML        = "checksum.checkForGaps"
+	GAP_CHECK_INTERVAL_TOML    = "checksum.gapCheckInterval"
+
+	CHECKSUM_MAX_RETRIES_TOML     = "checksum.maxRetries"
+	CHECKSUM_RETRY_MIN_DELAY_TOML = "checksum.retryMinDelay"
+	CHECKSUM_RETRY_MAX_DELAY_TOML = "checksum.retryMaxDelay"
+	CHECKSUM_ON_FAILURE_TOML      = "checksum.onFailure"
+
+	LOTUS_API_URL_TOML         = "lotus.apiURL"
+	LOTUS_AUTH_TOKEN_PATH_TOML = "lotus.authTokenPath"
+	FINALITY_DEPTH_TOML        = "lotus.finalityDepth"
+
+	SUPPORTS_COMPARING_TOML      = "compare.on"
+	COMPARE_PEERS_TOML           = "compare.peers"
+	COMPARE_INTERVAL_TOML        = "compare.interval"
+	COMPARE_AUTH_TOKEN_PATH_TOML = "compare.authTokenPath"
+	COMPARE_TLS_TOML             = "compare.tls"
+	COMPARE_TLS_CA_PATH_TOML     = "compare.tlsCAPath"
+	COMPARE_TLS_CERT_PATH_TOML   = "compare.tlsCertPath"
+	COMPARE_TLS_KEY_PATH_TOML    = "compare.tlsKeyPath"
+)
+
+const (
+	defaultCompareInterval = 10 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
 )
 
 // Config holds the configuration params for the attestation service
@@ -44,14 +115,53 @@ type Config struct {
 	Serve bool
 	// Port to expose API on
 	ServerPort string
+	// Host or IP address to bind the API to, all interfaces if empty
+	ServerHost string
+	// Path to the file with the secret API tokens are signed with, created if it does not exist
+	// if empty, no tokens are accepted and the admin methods cannot be called
+	AuthSecretPath string
+	// Reject API requests without a token, instead of giving them read permission
+	RequireToken bool
+	// Paths to the PEM certificate and key to serve the API over TLS with, plaintext HTTP if empty
+	TLSCertPath string
+	TLSKeyPath  string
+	// Path to the PEM bundle of CAs that sign the client certificates the server requires (mutual TLS), if any
+	TLSClientCAPath string
+	// How long to wait for in-flight API requests to finish when shutting down, before closing their connections
+	ShutdownTimeout time.Duration
 	// Directory with the source msgindex.db sqlite file
 	SrcDBDir string
 	// Directory with/for the checksums.db sqlite file
 	RepoDBDir string
 	// Chunk range size for checksumming
 	ChecksumChunkSize uint
-	// Whether to check for gaps in the checksum repo at initialization if the repo already exists
-	CheckForGaps uint
+	// Hash algorithm used to digest each chunk
+	HashAlgorithm HashAlgorithm
+	// Path to the Lotus wallet KeyInfo file used to sign published checksums, checksums are unsigned if empty
+	SigningKeyPath string
+	// Checksum every complete chunk in msgindex.db once and then exit, instead of waiting for new epochs
+	Offline bool
+	// Websocket URL of the Lotus full-node or gateway API to follow the chain head of, e.g. ws://127.0.0.1:1234/rpc/v1
+	// if empty, msgindex.db is polled for newly populated chunks instead
+	LotusAPIURL string
+	// Path to the file with the auth token for the Lotus API, if required
+	LotusAuthTokenPath string
+	// Number of epochs below the chain head after which an epoch is considered final
+	FinalityDepth uint
+	// support comparing our checksums against peers
+	Compare bool
+	// host:port addresses of the peer attestation servers to compare against
+	Peers []string
+	// How often to poll the peers
+	CompareInterval time.Duration
+	// How to connect to the peers
+	PeerConfig PeerConfig
+	// Whether to check for gaps in the checksum repo at initialization, and periodically after, and backfill them
+	CheckForGaps bool
+	// How often to check for gaps in the checksum repo
+	GapCheckInterval time.Duration
+	// How the checksumming loop retries transient errors, and what it does about the errors it gives up on
+	RetryPolicy RetryPolicy
 }
 
 // NewConfig is used to initialize a watcher config from a .toml file
@@ -60,12 +170,36 @@ func NewConfig() (*Config, error) {
 	c := new(Config)
 
 	viper.BindEnv(SERVER_PORT_TOML, SERVER_PORT)
+	viper.BindEnv(SERVER_HOST_TOML, SERVER_HOST)
 	viper.BindEnv(SUPPORTS_SERVER_TOML, SUPPORTS_SERVER)
+	viper.BindEnv(AUTH_SECRET_PATH_TOML, AUTH_SECRET_PATH)
+	viper.BindEnv(AUTH_REQUIRE_TOKEN_TOML, AUTH_REQUIRE_TOKEN)
+	viper.BindEnv(SERVER_TLS_CERT_PATH_TOML, SERVER_TLS_CERT_PATH)
+	viper.BindEnv(SERVER_TLS_KEY_PATH_TOML, SERVER_TLS_KEY_PATH)
+	viper.BindEnv(SERVER_TLS_CLIENT_CA_PATH_TOML, SERVER_TLS_CLIENT_CA_PATH)
+	viper.BindEnv(SERVER_SHUTDOWN_TIMEOUT_TOML, SERVER_SHUTDOWN_TIMEOUT)
 
 	viper.BindEnv(CHECKSUM_DB_DIRECTORY_TOML, CHECKSUM_DB_DIRECTORY)
 	viper.BindEnv(MSG_INDEX_DB_DIRECTORY_TOML, MSG_INDEX_DB_DIRECTORY)
 	viper.BindEnv(SUPPORTS_CHECKSUMMING_TOML, SUPPORTS_CHECKSUMMING)
 	viper.BindEnv(CHECKSUM_CHUNK_SIZE_TOML, CHECKSUM_CHUNK_SIZE)
+	viper.BindEnv(CHECKSUM_ALGORITHM_TOML, CHECKSUM_ALGORITHM)
+	viper.BindEnv(SIGNING_KEY_PATH_TOML, SIGNING_KEY_PATH)
+	viper.BindEnv(CHECKSUM_OFFLINE_TOML, CHECKSUM_OFFLINE)
+	viper.BindEnv(CHECK_FOR_GAPS_TOML, CHECK_FOR_GAPS)
+	viper.BindEnv(GAP_CHECK_INTERVAL_TOML, GAP_CHECK_INTERVAL)
+	viper.BindEnv(CHECKSUM_MAX_RETRIES_TOML, CHECKSUM_MAX_RETRIES)
+	viper.BindEnv(CHECKSUM_RETRY_MIN_DELAY_TOML, CHECKSUM_RETRY_MIN_DELAY)
+	viper.BindEnv(CHECKSUM_RETRY_MAX_DELAY_TOML, CHECKSUM_RETRY_MAX_DELAY)
+	viper.BindEnv(CHECKSUM_ON_FAILURE_TOML, CHECKSUM_ON_FAILURE)
+
+	viper.BindEnv(LOTUS_API_URL_TOML, LOTUS_API_URL)
+	viper.BindEnv(LOTUS_AUTH_TOKEN_PATH_TOML, LOTUS_AUTH_TOKEN_PATH)
+	viper.BindEnv(FINALITY_DEPTH_TOML, FINALITY_DEPTH)
+
+	viper.BindEnv(SUPPORTS_COMPARING_TOML, SUPPORTS_COMPARING)
+	viper.BindEnv(COMPARE_PEERS_TOML, COMPARE_PEERS)
+	viper.BindEnv(COMPARE_INTERVAL_TOML, COMPARE_INTERVAL)
 
 	checksummingEnabled := viper.GetBool(SUPPORTS_CHECKSUMMING_TOML)
 	if checksummingEnabled {
@@ -89,6 +223,50 @@ func NewConfig() (*Config, error) {
 	}
 	c.ChecksumChunkSize = checksumChunkSize
 
+	hashAlgorithm, err := ParseHashAlgorithm(viper.GetString(CHECKSUM_ALGORITHM_TOML))
+	if err != nil {
+		return nil, err
+	}
+	c.HashAlgorithm = hashAlgorithm
+	c.SigningKeyPath = viper.GetString(SIGNING_KEY_PATH_TOML)
+	c.Offline = viper.GetBool(CHECKSUM_OFFLINE_TOML)
+	if c.Offline && !checksummingEnabled {
+		return nil, errors.New("offline mode requires checksumming to be enabled")
+	}
+	c.CheckForGaps = viper.GetBool(CHECK_FOR_GAPS_TOML)
+	c.GapCheckInterval = viper.GetDuration(GAP_CHECK_INTERVAL_TOML)
+	if c.GapCheckInterval <= 0 {
+		c.GapCheckInterval = defaultGapCheckInterval
+	}
+	if c.RetryPolicy, err = NewRetryPolicy(); err != nil {
+		return nil, err
+	}
+
+	// chain following
+	c.LotusAPIURL = viper.GetString(LOTUS_API_URL_TOML)
+	c.LotusAuthTokenPath = viper.GetString(LOTUS_AUTH_TOKEN_PATH_TOML)
+	c.FinalityDepth = viper.GetUint(FINALITY_DEPTH_TOML)
+	if c.FinalityDepth == 0 {
+		c.FinalityDepth = defaultFinalityDepth
+	}
+
+	// peer comparison
+	compareEnabled := viper.GetBool(SUPPORTS_COMPARING_TOML)
+	if compareEnabled {
+		c.Peers = viper.GetStringSlice(COMPARE_PEERS_TOML)
+		if len(c.Peers) == 0 {
+			return nil, errors.New("if comparing is enabled, at least one peer address must be provided")
+		}
+		c.CompareInterval = viper.GetDuration(COMPARE_INTERVAL_TOML)
+		if c.CompareInterval <= 0 {
+			c.CompareInterval = defaultCompareInterval
+		}
+		if c.PeerConfig, err = NewPeerConfig(); err != nil {
+			return nil, err
+		}
+		c.Compare = compareEnabled
+	}
+
 	// http server
 	serverEnabled := viper.GetBool(SUPPORTS_SERVER_TOML)
 	if serverEnabled {
@@ -96,8 +274,81 @@ func NewConfig() (*Config, error) {
 		if c.ServerPort == "" {
 			c.ServerPort = "8087"
 		}
+		c.ServerHost = viper.GetString(SERVER_HOST_TOML)
+		c.AuthSecretPath = viper.GetString(AUTH_SECRET_PATH_TOML)
+		c.RequireToken = viper.GetBool(AUTH_REQUIRE_TOKEN_TOML)
+		if c.RequireToken && c.AuthSecretPath == "" {
+			return nil, errors.New("requiring API tokens requires an auth secret path")
+		}
+		c.TLSCertPath = viper.GetString(SERVER_TLS_CERT_PATH_TOML)
+		c.TLSKeyPath = viper.GetString(SERVER_TLS_KEY_PATH_TOML)
+		c.TLSClientCAPath = viper.GetString(SERVER_TLS_CLIENT_CA_PATH_TOML)
+		if (c.TLSCertPath == "") != (c.TLSKeyPath == "") {
+			return nil, errors.New("a TLS certificate and key must be provided together")
+		}
+		if c.TLSClientCAPath != "" && c.TLSCertPath == "" {
+			return nil, errors.New("verifying client certificates requires a TLS certificate and key")
+		}
+		c.ShutdownTimeout = viper.GetDuration(SERVER_SHUTDOWN_TIMEOUT_TOML)
+		if c.ShutdownTimeout <= 0 {
+			c.ShutdownTimeout = defaultShutdownTimeout
+		}
 		c.Serve = serverEnabled
 	}
 
 	return c, nil
 }
+
+// PeerConfig holds the settings for connecting to peer attestation servers
+type PeerConfig struct {
+	// Path to the file with the API token to authenticate to the peers with, if they require one
+	AuthTokenPath string
+	// Connect to the peers over TLS, implied if any of the TLS paths are set
+	TLS bool
+	// Path to the PEM bundle of CAs the peers' certificates are verified against, the system roots if empty
+	TLSCAPath string
+	// Paths to the PEM client certificate and key to present to peers that require mutual TLS
+	TLSCertPath string
+	TLSKeyPath  string
+}
+
+// NewPeerConfig reads the settings for connecting to peer attestation servers
+func NewPeerConfig() (PeerConfig, error) {
+	viper.BindEnv(COMPARE_AUTH_TOKEN_PATH_TOML, COMPARE_AUTH_TOKEN_PATH)
+	viper.BindEnv(COMPARE_TLS_TOML, COMPARE_TLS)
+	viper.BindEnv(COMPARE_TLS_CA_PATH_TOML, COMPARE_TLS_CA_PATH)
+	viper.BindEnv(COMPARE_TLS_CERT_PATH_TOML, COMPARE_TLS_CERT_PATH)
+	viper.BindEnv(COMPARE_TLS_KEY_PATH_TOML, COMPARE_TLS_KEY_PATH)
+
+	c := PeerConfig{
+		AuthTokenPath: viper.GetString(COMPARE_AUTH_TOKEN_PATH_TOML),
+		TLSCAPath:     viper.GetString(COMPARE_TLS_CA_PATH_TOML),
+		TLSCertPath:   viper.GetString(COMPARE_TLS_CERT_PATH_TOML),
+		TLSKeyPath:    viper.GetString(COMPARE_TLS_KEY_PATH_TOML),
+	}
+	if (c.TLSCertPath == "") != (c.TLSKeyPath == "") {
+		return PeerConfig{}, errors.New("a TLS client certificate and key must be provided together")
+	}
+	c.TLS = viper.GetBool(COMPARE_TLS_TOML) || c.TLSCAPath != "" || c.TLSCertPath != ""
+	return c, nil
+}
+
+// Options loads the token and TLS configuration for connecting to peers
+func (c PeerConfig) Options() (PeerOptions, error) {
+	var opts PeerOptions
+	if c.AuthTokenPath != "" {
+		token, err := os.ReadFile(c.AuthTokenPath)
+		if err != nil {
+			return PeerOptions{}, fmt.Errorf("read peer auth token file: %w", err)
+		}
+		opts.Token = strings.TrimSpace(string(token))
+	}
+	if c.TLS {
+		tlsConfig, err := NewClientTLSConfig(c.TLSCAPath, c.TLSCertPath, c.TLSKeyPath)
+		if err != nil {
+			return PeerOptions{}, err
+		}
+		opts.TLS = tlsConfig
+	}
+	return opts, nil
+}
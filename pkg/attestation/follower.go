@@ -0,0 +1,153 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/sirupsen/logrus"
+)
+
+// resubscribeDelay is how long to wait before resubscribing after the ChainNotify channel is closed
+var resubscribeDelay = 5 * time.Second
+
+const (
+	defaultFinalityDepth uint = 900
+
+	// head change types, from lotus chain/store/store.go
+	headChangeRevert  = "revert"
+	headChangeApply   = "apply"
+	headChangeCurrent = "current"
+)
+
+// ChainFollower follows the head of a Lotus chain through ChainNotify, to tell which epochs are final
+// and which epochs have been reverted by a reorg
+type ChainFollower struct {
+	node  api.Gateway
+	depth uint
+
+	mu       sync.Mutex
+	head     int64
+	reverted []uint
+	// changed is closed and replaced each time the head changes
+	changed chan struct{}
+}
+
+// NewChainFollower creates a new ChainFollower that considers epochs at least depth epochs below the head final
+func NewChainFollower(node api.Gateway, depth uint) *ChainFollower {
+	if depth == 0 {
+		depth = defaultFinalityDepth
+	}
+	return &ChainFollower{node: node, depth: depth, head: -1, changed: make(chan struct{})}
+}
+
+// Follow fetches the current head and subscribes to head changes in the background until the context is cancelled
+// the subscription is re-established if the node closes it
+func (f *ChainFollower) Follow(ctx context.Context, wg *sync.WaitGroup) error {
+	head, err := f.node.ChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("get chain head: %w", err)
+	}
+	f.setHead(int64(head.Height()))
+	changes, err := f.node.ChainNotify(ctx)
+	if err != nil {
+		return fmt.Errorf("subscribe to chain head changes: %w", err)
+	}
+	wg.Add(1)
+	go func() {
+		defer func() {
+			logrus.Info("attestation service chain follower exited")
+		}()
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case hcs, ok := <-changes:
+				if ok {
+					f.apply(hcs)
+					continue
+				}
+				logrus.Warnf("chain head subscription closed, resubscribing in %s", resubscribeDelay)
+				for !ok {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(resubscribeDelay):
+					}
+					if changes, err = f.node.ChainNotify(ctx); err != nil {
+						logrus.Errorf("subscribe to chain head changes: %s", err.Error())
+						continue
+					}
+					ok = true
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (f *ChainFollower) apply(hcs []*api.HeadChange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, hc := range hcs {
+		if hc == nil || hc.Val == nil {
+			continue
+		}
+		height := int64(hc.Val.Height())
+		switch hc.Type {
+		case headChangeRevert:
+			if height >= 0 {
+				f.reverted = append(f.reverted, uint(height))
+			}
+			// the head moves back to the parent of the reverted tipset until the replacing tipsets are applied
+			f.head = height - 1
+		case headChangeApply, headChangeCurrent:
+			f.head = height
+		}
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *ChainFollower) setHead(head int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.head = head
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// Head returns the current head epoch, false if it is not known yet
+func (f *ChainFollower) Head() (uint, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.head < 0 {
+		return 0, false
+	}
+	return uint(f.head), true
+}
+
+// IsFinal returns whether the epoch is at least the finality depth below the current head
+func (f *ChainFollower) IsFinal(epoch uint) bool {
+	head, ok := f.Head()
+	return ok && head >= f.depth && epoch <= head-f.depth
+}
+
+// Changed returns a channel that is closed the next time the head changes
+func (f *ChainFollower) Changed() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.changed
+}
+
+// Reverted returns, and forgets, the epochs of the tipsets reverted since the last call
+func (f *ChainFollower) Reverted() []uint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reverted := f.reverted
+	f.reverted = nil
+	return reverted
+}
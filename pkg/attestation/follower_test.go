@@ -0,0 +1,63 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation/mocks"
+)
+
+// waitFor polls the condition until it holds, failing the test after a timeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChainFollower(t *testing.T) {
+	defer func(delay time.Duration) { resubscribeDelay = delay }(resubscribeDelay)
+	resubscribeDelay = 10 * time.Millisecond
+	node := mocks.NewGateway(10)
+	follower := NewChainFollower(node, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+	defer cancel()
+	if err := follower.Follow(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	if !follower.IsFinal(5) || follower.IsFinal(6) {
+		t.Fatal("expected epochs up to 5 to be final at head 10")
+	}
+
+	changed := follower.Changed()
+	node.Apply(20)
+	<-changed
+	if !follower.IsFinal(15) {
+		t.Fatal("expected epoch 15 to be final at head 20")
+	}
+
+	changed = follower.Changed()
+	node.Reorg(18)
+	<-changed
+	if reverted := follower.Reverted(); fmt.Sprint(reverted) != "[20 19 18]" {
+		t.Fatalf("expected reverted epochs [20 19 18], got %v", reverted)
+	}
+	if head, _ := follower.Head(); head != 20 {
+		t.Fatalf("expected head 20 after the reorg, got %d", head)
+	}
+
+	// the subscription is re-established after the node closes it
+	node.CloseSubscriptions()
+	time.Sleep(10 * time.Millisecond)
+	node.Apply(21)
+	waitFor(t, "resubscribing", func() bool { head, _ := follower.Head(); return head == 21 })
+}
@@ -0,0 +1,77 @@
+package attestation
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// ChecksumFormatVersion is the version of the canonical msgindex chunk serialization produced by this package
+//
+// Version 1 digests the following byte stream:
+//
+//	header = "lotus-utils/msgindex-checksum" 0x00 uint8(version) uint64be(start) uint64be(stop)
+//	row    = int64be(epoch) uvarint(len(tipset_cid)) tipset_cid uvarint(len(cid)) cid
+//	stream = header row*
+//
+// where the rows are every `messages` row with start <= epoch <= stop, ordered by (epoch, tipset_cid, cid)
+// using bytewise comparison, and the CIDs are the string forms stored in msgindex.db
+// The digest is computed with the configured HashAlgorithm (SHA3-256 by default), and the checksum is the lowercase
+// hex encoding of the digest of the stream
+const ChecksumFormatVersion uint8 = 1
+
+var checksumFormatDomain = []byte("lotus-utils/msgindex-checksum")
+
+// writeChunkHeader writes the canonical chunk header for the given range to the hash
+func writeChunkHeader(h hash.Hash, start, stop uint) {
+	var buf [16]byte
+	h.Write(checksumFormatDomain)
+	h.Write([]byte{0, ChecksumFormatVersion})
+	binary.BigEndian.PutUint64(buf[:8], uint64(start))
+	binary.BigEndian.PutUint64(buf[8:], uint64(stop))
+	h.Write(buf[:])
+}
+
+// writeMessageRow writes the canonical serialization of a single messages row to the hash
+func writeMessageRow(h hash.Hash, row types.MessageRow) {
+	var buf [binary.MaxVarintLen64]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(row.Epoch))
+	h.Write(buf[:8])
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(row.TipSetCid)))])
+	h.Write([]byte(row.TipSetCid))
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(row.Cid)))])
+	h.Write([]byte(row.Cid))
+}
+
+// sortMessageRows sorts the rows into the canonical (epoch, tipset_cid, cid) order
+func sortMessageRows(rows []types.MessageRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Epoch != rows[j].Epoch {
+			return rows[i].Epoch < rows[j].Epoch
+		}
+		if rows[i].TipSetCid != rows[j].TipSetCid {
+			return rows[i].TipSetCid < rows[j].TipSetCid
+		}
+		return rows[i].Cid < rows[j].Cid
+	})
+}
+
+// ChecksumRows computes the canonical checksum for the range over the provided rows using the given algorithm
+// the rows do not need to be pre-sorted, and rows outside of the range are expected to have been filtered out by the caller
+func ChecksumRows(algo HashAlgorithm, start, stop uint, rows []types.MessageRow) (string, error) {
+	h, err := algo.New()
+	if err != nil {
+		return "", err
+	}
+	sorted := make([]types.MessageRow, len(rows))
+	copy(sorted, rows)
+	sortMessageRows(sorted)
+	writeChunkHeader(h, start, stop)
+	for _, row := range sorted {
+		writeMessageRow(h, row)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,74 @@
+package attestation
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// HashAlgorithm identifies the hash function used to digest the canonical chunk serialization
+type HashAlgorithm string
+
+// Supported hash algorithms
+const (
+	SHA3_256    HashAlgorithm = "sha3-256"
+	BLAKE2B_256 HashAlgorithm = "blake2b-256"
+	SHA256      HashAlgorithm = "sha256"
+
+	defaultHashAlgorithm = SHA3_256
+)
+
+var hashAlgorithms = map[HashAlgorithm]func() hash.Hash{
+	SHA3_256: sha3.New256,
+	BLAKE2B_256: func() hash.Hash {
+		// only errors for keys longer than 64 bytes
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+	SHA256: sha256.New,
+}
+
+// RegisterHashAlgorithm makes a new hash algorithm available for checksumming under the given identifier
+func RegisterHashAlgorithm(algo HashAlgorithm, constructor func() hash.Hash) {
+	hashAlgorithms[algo] = constructor
+}
+
+// ParseHashAlgorithm returns the HashAlgorithm for the given identifier, or the default algorithm if it is empty
+func ParseHashAlgorithm(s string) (HashAlgorithm, error) {
+	if s == "" {
+		return defaultHashAlgorithm, nil
+	}
+	algo := HashAlgorithm(strings.ToLower(s))
+	if _, ok := hashAlgorithms[algo]; !ok {
+		return "", fmt.Errorf("unsupported hash algorithm %s, expected one of %v", s, supportedHashAlgorithms())
+	}
+	return algo, nil
+}
+
+// New returns a new hash.Hash for the algorithm
+func (a HashAlgorithm) New() (hash.Hash, error) {
+	constructor, ok := hashAlgorithms[a]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %s", a)
+	}
+	return constructor(), nil
+}
+
+// String implements fmt.Stringer
+func (a HashAlgorithm) String() string {
+	return string(a)
+}
+
+func supportedHashAlgorithms() []string {
+	algos := make([]string, 0, len(hashAlgorithms))
+	for algo := range hashAlgorithms {
+		algos = append(algos, string(algo))
+	}
+	sort.Strings(algos)
+	return algos
+}
@@ -0,0 +1,235 @@
+package attestation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+const (
+	// maxConcurrentChecksumJobs bounds the number of on-demand checksums, and msgindex.db reads of the bisection
+	// methods, computed at once
+	maxConcurrentChecksumJobs = 2
+	// maxPendingChecksumJobs bounds the number of on-demand checksums scheduled and not finished yet, the requests for
+	// further chunks are refused until some finish
+	maxPendingChecksumJobs = 16
+	// maxRetainedChecksumJobs bounds the number of finished jobs kept around to be looked up by ID
+	maxRetainedChecksumJobs = 1000
+	// maxChecksumJobTimeout bounds how long a request may block waiting for its job
+	maxChecksumJobTimeout = 5 * time.Minute
+	// lazyChecksumTimeout is how long GetChecksum blocks on computing a checksum that is not published yet
+	lazyChecksumTimeout = 30 * time.Second
+)
+
+var (
+	errRangeNotPopulated   = errors.New("range is not fully populated in msgindex.db")
+	errRangeNotFinal       = errors.New("range is not final yet")
+	errTooManyChecksumJobs = errors.New("too many pending checksum jobs")
+)
+
+type checksumJob struct {
+	job  types.ChecksumJob
+	done chan struct{}
+}
+
+// checksumJobs computes and publishes checksums on demand
+// requests for a chunk that is already being computed are coalesced into the same job
+type checksumJobs struct {
+	cs      types.Checksummer
+	publish func(types.Checksum) error
+	// isFinal returns whether an epoch is final, only final chunks are checksummed as they are not re-checked after a
+	// reorg
+	isFinal func(uint) bool
+	sem     chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[[2]uint]*checksumJob
+	byID     map[string]*checksumJob
+	finished []string
+	// direct counts the synchronous computations of the bisection methods running or waiting for a slot
+	direct int
+	// stopped is set once the service is stopped, running tracks the jobs and synchronous computations
+	stopped bool
+	running sync.WaitGroup
+}
+
+func newChecksumJobs(cs types.Checksummer, publish func(types.Checksum) error, isFinal func(uint) bool) *checksumJobs {
+	return &checksumJobs{
+		cs:       cs,
+		publish:  publish,
+		isFinal:  isFinal,
+		sem:      make(chan struct{}, maxConcurrentChecksumJobs),
+		inFlight: make(map[[2]uint]*checksumJob),
+		byID:     make(map[string]*checksumJob),
+	}
+}
+
+// schedule returns the job computing the checksum for the chunk, starting one if none is in flight
+// the chunk must be final and fully populated in msgindex.db, and at most maxPendingChecksumJobs may be in flight
+func (j *checksumJobs) schedule(start, stop uint) (*checksumJob, error) {
+	rng := [2]uint{start, stop}
+	j.mu.Lock()
+	if job, ok := j.inFlight[rng]; ok {
+		j.mu.Unlock()
+		return job, nil
+	}
+	j.mu.Unlock()
+	if !j.isFinal(stop) {
+		return nil, fmt.Errorf("range %d to %d: %w", start, stop, errRangeNotFinal)
+	}
+	populated, err := j.cs.CheckRangeIsPopulated(start, stop)
+	if err != nil {
+		return nil, err
+	}
+	if !populated {
+		return nil, fmt.Errorf("range %d to %d: %w", start, stop, errRangeNotPopulated)
+	}
+	id, err := newChecksumJobID()
+	if err != nil {
+		return nil, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// another request may have scheduled the chunk while we checked the msgindex.db
+	if job, ok := j.inFlight[rng]; ok {
+		return job, nil
+	}
+	if j.stopped {
+		return nil, errServiceStopped
+	}
+	if len(j.inFlight)+j.direct >= maxPendingChecksumJobs {
+		return nil, errTooManyChecksumJobs
+	}
+	job := &checksumJob{
+		job: types.ChecksumJob{
+			ID:        id,
+			Start:     start,
+			Stop:      stop,
+			Algorithm: j.cs.Algorithm(),
+			Status:    types.ChecksumJobPending,
+		},
+		done: make(chan struct{}),
+	}
+	j.inFlight[rng] = job
+	j.byID[id] = job
+	logrus.Infof("scheduled checksum job %s for range %d to %d", id, start, stop)
+	j.running.Add(1)
+	go j.run(job)
+	return job, nil
+}
+
+// compute runs a synchronous computation over msgindex.db for the range, for the bisection methods, sharing the
+// concurrency limit of the jobs; the range must be final, and the computations running or waiting for a slot count
+// towards maxPendingChecksumJobs
+func (j *checksumJobs) compute(start, stop uint, fn func() error) error {
+	if !j.isFinal(stop) {
+		return fmt.Errorf("range %d to %d: %w", start, stop, errRangeNotFinal)
+	}
+	j.mu.Lock()
+	if j.stopped {
+		j.mu.Unlock()
+		return errServiceStopped
+	}
+	if len(j.inFlight)+j.direct >= maxPendingChecksumJobs {
+		j.mu.Unlock()
+		return errTooManyChecksumJobs
+	}
+	j.direct++
+	j.running.Add(1)
+	j.mu.Unlock()
+	defer func() {
+		j.mu.Lock()
+		j.direct--
+		j.mu.Unlock()
+		j.running.Done()
+	}()
+	j.sem <- struct{}{}
+	defer func() { <-j.sem }()
+	return fn()
+}
+
+// stop refuses any new job, the jobs already scheduled still run
+func (j *checksumJobs) stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stopped = true
+}
+
+// drain refuses any new job and waits for the scheduled ones to finish
+func (j *checksumJobs) drain() {
+	j.stop()
+	j.running.Wait()
+}
+
+func (j *checksumJobs) run(job *checksumJob) {
+	defer j.running.Done()
+	j.sem <- struct{}{}
+	checksum, err := j.cs.Checksum(job.job.Start, job.job.Stop)
+	if err == nil {
+		err = j.publish(checksum)
+	}
+	<-j.sem
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		logrus.Errorf("checksum job %s for range %d to %d failed: %s", job.job.ID, job.job.Start, job.job.Stop, err.Error())
+		job.job.Status = types.ChecksumJobFailed
+		job.job.Error = err.Error()
+	} else {
+		logrus.Infof("checksum job %s published checksum for range %d to %d", job.job.ID, job.job.Start, job.job.Stop)
+		job.job.Status = types.ChecksumJobDone
+		job.job.Hash = checksum.Hash
+	}
+	close(job.done)
+	delete(j.inFlight, [2]uint{job.job.Start, job.job.Stop})
+	j.finished = append(j.finished, job.job.ID)
+	if len(j.finished) > maxRetainedChecksumJobs {
+		delete(j.byID, j.finished[0])
+		j.finished = j.finished[1:]
+	}
+}
+
+// wait waits up to the timeout for the job to finish, and returns its state
+func (j *checksumJobs) wait(job *checksumJob, timeout time.Duration) types.ChecksumJob {
+	if timeout > maxChecksumJobTimeout {
+		timeout = maxChecksumJobTimeout
+	}
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-job.done:
+		case <-timer.C:
+		}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return job.job
+}
+
+// get returns the state of the job with the given ID
+func (j *checksumJobs) get(id string) (types.ChecksumJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.byID[id]
+	if !ok {
+		return types.ChecksumJob{}, false
+	}
+	return job.job, true
+}
+
+func newChecksumJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
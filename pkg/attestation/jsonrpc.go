@@ -0,0 +1,166 @@
+package attestation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+const (
+	// JSONRPCNamespace is the namespace the AttestationAPI methods are served under, e.g. Attestation.GetChecksum
+	JSONRPCNamespace = "Attestation"
+	// JSONRPCPath is the path the JSON-RPC 2.0 API is served at, over both HTTP and WebSocket
+	JSONRPCPath = "/rpc/v1"
+)
+
+var _ types.AttestationAPI = (*jsonRPCHandler)(nil)
+
+// jsonRPCHandler adapts the API to the go-jsonrpc calling convention
+type jsonRPCHandler struct {
+	api *API
+}
+
+// NewJSONRPCHandler returns the AttestationAPI for serving the API with go-jsonrpc
+func NewJSONRPCHandler(api *API) types.AttestationAPI {
+	return &jsonRPCHandler{api: api}
+}
+
+func (h *jsonRPCHandler) ChecksumExists(ctx context.Context, req types.ChecksumExistsRequest) (bool, error) {
+	var res bool
+	err := h.api.ChecksumExists(req, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetChecksum(ctx context.Context, rng types.GetChecksumRequest) (string, error) {
+	var res string
+	err := h.api.GetChecksum(rng, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetAttestation(ctx context.Context, rng types.GetChecksumRequest) (types.Checksum, error) {
+	var res types.Checksum
+	err := h.api.GetAttestation(rng, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetMerkleRoot(ctx context.Context, req types.MerkleRootRequest) (types.MerkleRoot, error) {
+	var res types.MerkleRoot
+	err := h.api.GetMerkleRoot(req, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetInclusionProof(ctx context.Context, req types.InclusionProofRequest) (types.InclusionProof, error) {
+	var res types.InclusionProof
+	err := h.api.GetInclusionProof(req, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetComparisons(ctx context.Context, req types.ListComparisonsRequest) ([]types.Comparison, error) {
+	var res []types.Comparison
+	err := h.api.GetComparisons(req, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) ComputeChecksum(ctx context.Context, rng types.GetChecksumRequest) (string, error) {
+	var res string
+	err := h.api.ComputeChecksum(rng, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetMessages(ctx context.Context, req types.GetMessagesRequest) ([]types.MessageRow, error) {
+	var res []types.MessageRow
+	err := h.api.GetMessages(req, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetBackfillStatus(ctx context.Context) (types.BackfillStatus, error) {
+	var res types.BackfillStatus
+	err := h.api.GetBackfillStatus(types.BackfillStatusRequest{}, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) RequestChecksum(ctx context.Context, req types.RequestChecksumRequest) (types.ChecksumJob, error) {
+	var res types.ChecksumJob
+	err := h.api.RequestChecksum(req, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetChecksumJob(ctx context.Context, req types.GetChecksumJobRequest) (types.ChecksumJob, error) {
+	var res types.ChecksumJob
+	err := h.api.GetChecksumJob(req, &res)
+	return res, err
+}
+
+func (h *jsonRPCHandler) GetStatus(ctx context.Context) (types.Status, error) {
+	var res types.Status
+	err := h.api.GetStatus(types.StatusRequest{}, &res)
+	return res, err
+}
+
+// SubscribeChecksums streams newly published checksums to a WebSocket client, see SubscribeChecksums
+func (h *jsonRPCHandler) SubscribeChecksums(ctx context.Context, req types.SubscribeChecksumsRequest) (<-chan types.Checksum, error) {
+	if h.api.broker == nil {
+		return nil, errors.New("checksum subscriptions are not enabled on this server")
+	}
+	return SubscribeChecksums(ctx, h.api.backend, h.api.broker, req)
+}
+
+func (h *jsonRPCHandler) RecomputeChecksum(ctx context.Context, req types.RequestChecksumRequest) (types.ChecksumJob, error) {
+	return h.api.recomputeChecksum(req)
+}
+
+func (h *jsonRPCHandler) TriggerBackfill(ctx context.Context) (types.BackfillStatus, error) {
+	return h.api.triggerBackfill()
+}
+
+func (h *jsonRPCHandler) ResumeChecksumming(ctx context.Context) (types.Status, error) {
+	return h.api.resumeChecksumming()
+}
+
+func (h *jsonRPCHandler) DeleteChecksum(ctx context.Context, req types.DeleteChecksumRequest) (bool, error) {
+	return h.api.deleteChecksum(req)
+}
+
+// NewPermissionedJSONRPCHandler returns the AttestationAPI for serving with go-jsonrpc, with each method checking that the
+// caller has the permission in its perm tag on types.AttestationStruct; callers without a token have the default permissions
+// every call is recorded in the API request metrics, including those rejected for missing permissions
+func NewPermissionedJSONRPCHandler(api *API, defaultPerms []auth.Permission) types.AttestationAPI {
+	var permissioned, out types.AttestationStruct
+	auth.PermissionedProxy(AllPermissions, defaultPerms, NewJSONRPCHandler(api), &permissioned.Internal)
+	metricsProxy(&permissioned, &out.Internal)
+	return &out
+}
+
+// metricsProxy sets each function field of out, a types.AttestationStruct's Internal struct, to call the method of the
+// same name on in and record the call in the API request metrics
+func metricsProxy(in interface{}, out interface{}) {
+	rint := reflect.ValueOf(out).Elem()
+	ra := reflect.ValueOf(in)
+	for f := 0; f < rint.NumField(); f++ {
+		field := rint.Type().Field(f)
+		fn := ra.MethodByName(field.Name)
+		rint.Field(f).Set(reflect.MakeFunc(field.Type, func(args []reflect.Value) []reflect.Value {
+			start := time.Now()
+			results := fn.Call(args)
+			err, _ := results[len(results)-1].Interface().(error)
+			prom.ObserveAPIRequest(prom.JSONRPCAPI, field.Name, start, err)
+			return results
+		}))
+	}
+}
+
+// NewJSONRPCClient connects to the JSON-RPC 2.0 API of an attestation server
+// addr is the full URL of the endpoint, e.g. ws://127.0.0.1:8087/rpc/v1 or http://127.0.0.1:8087/rpc/v1
+func NewJSONRPCClient(ctx context.Context, addr string, requestHeader http.Header) (types.AttestationAPI, jsonrpc.ClientCloser, error) {
+	var res types.AttestationStruct
+	closer, err := jsonrpc.NewMergeClient(ctx, addr, JSONRPCNamespace, []interface{}{&res.Internal}, requestHeader)
+	return &res, closer, err
+}
@@ -0,0 +1,113 @@
+package attestation
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func TestAttestationStructCoversAPI(t *testing.T) {
+	internal := reflect.TypeOf(types.AttestationStruct{}.Internal)
+	iface := reflect.TypeOf((*types.AttestationAPI)(nil)).Elem()
+	if internal.NumField() != iface.NumMethod() {
+		t.Fatalf("AttestationStruct has %d functions, AttestationAPI has %d methods", internal.NumField(), iface.NumMethod())
+	}
+	for i := 0; i < iface.NumMethod(); i++ {
+		method := iface.Method(i)
+		field, ok := internal.FieldByName(method.Name)
+		if !ok || field.Type != method.Type {
+			t.Fatalf("AttestationStruct does not proxy %s", method.Name)
+		}
+	}
+	// every net/rpc method is also served over JSON-RPC
+	netRPC := reflect.TypeOf((*types.API)(nil)).Elem()
+	for i := 0; i < netRPC.NumMethod(); i++ {
+		if _, ok := iface.MethodByName(netRPC.Method(i).Name); !ok {
+			t.Fatalf("AttestationAPI is missing %s", netRPC.Method(i).Name)
+		}
+	}
+}
+
+func TestJSONRPC(t *testing.T) {
+	checksums := newTestChecksums(4, 4)
+	service, err := NewService(nil, newTestPublishedRepo(t, 4, checksums), nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcServer := jsonrpc.NewServer()
+	service.RegisterJSONRPC(rpcServer.Register)
+	ts := httptest.NewServer(rpcServer)
+	defer ts.Close()
+
+	for _, scheme := range []string{"http", "ws"} {
+		t.Run(scheme, func(t *testing.T) {
+			addr := scheme + strings.TrimPrefix(ts.URL, "http") + JSONRPCPath
+			client, closer, err := NewJSONRPCClient(context.Background(), addr, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closer()
+
+			hash, err := client.GetChecksum(context.Background(), types.GetChecksumRequest{Start: 5, Stop: 9})
+			if err != nil || hash != checksums[1].Hash {
+				t.Fatalf("expected checksum %s, got %s (err: %v)", checksums[1].Hash, hash, err)
+			}
+			exists, err := client.ChecksumExists(context.Background(), types.ChecksumExistsRequest{Hash: checksums[2].Hash})
+			if err != nil || !exists {
+				t.Fatalf("expected checksum to exist (err: %v)", err)
+			}
+			root, err := client.GetMerkleRoot(context.Background(), types.MerkleRootRequest{})
+			if err != nil || root.Size != 4 {
+				t.Fatalf("expected a merkle root over 4 chunks, got %+v (err: %v)", root, err)
+			}
+			// errors are returned to the client
+			if _, err := client.GetChecksum(context.Background(), types.GetChecksumRequest{Start: 1, Stop: 5}); err == nil {
+				t.Fatal("expected an error for a misaligned range")
+			}
+			if _, err := client.GetBackfillStatus(context.Background()); err == nil {
+				t.Fatal("expected an error for the backfill status of a server that does not backfill")
+			}
+		})
+	}
+}
+
+func TestJSONRPCSubscribeChecksums(t *testing.T) {
+	checksums := newTestChecksums(4, 4)
+	service, err := NewService(nil, newTestMockRepo(t, 4, checksums[:3]), nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcServer := jsonrpc.NewServer()
+	service.RegisterJSONRPC(rpcServer.Register)
+	ts := httptest.NewServer(rpcServer)
+	defer ts.Close()
+
+	client, closer, err := NewJSONRPCClient(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http")+JSONRPCPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := client.SubscribeChecksums(ctx, types.SubscribeChecksumsRequest{Resume: true, From: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range checksums[1:3] {
+		if checksum := <-sub; checksum.Hash != expected.Hash {
+			t.Fatalf("expected replayed checksum %s, got %s", expected.Hash, checksum.Hash)
+		}
+	}
+	if err := service.publish(checksums[3]); err != nil {
+		t.Fatal(err)
+	}
+	if checksum := <-sub; checksum.Hash != checksums[3].Hash {
+		t.Fatalf("expected published checksum %s, got %s", checksums[3].Hash, checksum.Hash)
+	}
+}
@@ -0,0 +1,228 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// The chunk checksums of a repository are accumulated into a Merkle tree following the RFC 6962 construction:
+//
+//	leaf = H(0x00 uint64be(start) uint64be(stop) checksum)
+//	node = H(0x01 left right)
+//
+// where H is the hash algorithm that produced the checksums and the leaves are the consecutive chunks beginning at
+// epoch 0, up to the first gap; leaf i is the chunk starting at epoch i * (interval + 1)
+// Only checksums of the current ChecksumFormatVersion are leaves, a chunk with a legacy checksum is a gap
+// Anchoring the tree at epoch 0 makes the root over the first n leaves the same for every operator with the same first
+// n chunks, so two operators can binary search for the first diverging chunk by comparing roots of increasing prefix
+// sizes, in log(n) round trips
+
+const (
+	merkleLeafPrefix byte = 0x00
+	merkleNodePrefix byte = 0x01
+)
+
+// merkleAccumulator is the Merkle tree over a run of consecutive chunk checksums from epoch 0, extended leaf by leaf
+// it keeps the root of every perfect subtree, so that the root and inclusion proofs of any prefix of the tree take
+// log(n) hashes
+type merkleAccumulator struct {
+	algo HashAlgorithm
+	// levels[h][i] is the root of the perfect subtree over the 2^h leaves starting at leaf i * 2^h
+	levels [][][]byte
+}
+
+func newMerkleAccumulator(algo HashAlgorithm) *merkleAccumulator {
+	return &merkleAccumulator{algo: algo, levels: [][][]byte{nil}}
+}
+
+// size returns the number of leaves in the tree
+func (t *merkleAccumulator) size() uint {
+	return uint(len(t.levels[0]))
+}
+
+// append adds the checksum of the next chunk as a leaf, completing the perfect subtrees it closes
+func (t *merkleAccumulator) append(checksum types.Checksum) error {
+	node, err := merkleLeafHash(t.algo, checksum)
+	if err != nil {
+		return err
+	}
+	t.levels[0] = append(t.levels[0], node)
+	for h, i := 0, len(t.levels[0])-1; i%2 == 1; h, i = h+1, i/2 {
+		if node, err = merkleNodeHash(t.algo, t.levels[h][i-1], node); err != nil {
+			return err
+		}
+		if h+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		t.levels[h+1] = append(t.levels[h+1], node)
+	}
+	return nil
+}
+
+// truncate drops the leaves from n on, along with the subtrees that include them
+func (t *merkleAccumulator) truncate(n uint) {
+	for h := range t.levels {
+		if keep := int(n >> uint(h)); keep < len(t.levels[h]) {
+			t.levels[h] = t.levels[h][:keep]
+		}
+	}
+}
+
+// subtree returns the root over the leaves lo to hi (exclusive), splitting it as RFC 6962 does
+func (t *merkleAccumulator) subtree(lo, hi uint) ([]byte, error) {
+	n := hi - lo
+	if n&(n-1) == 0 && lo%n == 0 {
+		h := 0
+		for 1<<uint(h) < n {
+			h++
+		}
+		return t.levels[h][lo>>uint(h)], nil
+	}
+	k := uint(largestPowerOfTwoBelow(int(n)))
+	left, err := t.subtree(lo, lo+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.subtree(lo+k, hi)
+	if err != nil {
+		return nil, err
+	}
+	return merkleNodeHash(t.algo, left, right)
+}
+
+// root returns the root over the first size leaves, the hash of nothing for an empty tree
+func (t *merkleAccumulator) root(size uint) ([]byte, error) {
+	if size == 0 {
+		h, err := t.algo.New()
+		if err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+	return t.subtree(0, size)
+}
+
+// path returns the inclusion proof for leaf m in the tree over the first size leaves, ordered from the leaf up to the
+// root
+func (t *merkleAccumulator) path(m, size uint) ([][]byte, error) {
+	return t.subtreePath(m, 0, size)
+}
+
+func (t *merkleAccumulator) subtreePath(m, lo, hi uint) ([][]byte, error) {
+	if hi-lo <= 1 {
+		return nil, nil
+	}
+	k := uint(largestPowerOfTwoBelow(int(hi - lo)))
+	if m < lo+k {
+		sub, err := t.subtreePath(m, lo, lo+k)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := t.subtree(lo+k, hi)
+		if err != nil {
+			return nil, err
+		}
+		return append(sub, sibling), nil
+	}
+	sub, err := t.subtreePath(m, lo+k, hi)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := t.subtree(lo, lo+k)
+	if err != nil {
+		return nil, err
+	}
+	return append(sub, sibling), nil
+}
+
+func merkleLeafHash(algo HashAlgorithm, checksum types.Checksum) ([]byte, error) {
+	digest, err := hex.DecodeString(checksum.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("checksum for range %d to %d is not hex encoded: %w", checksum.Start, checksum.Stop, err)
+	}
+	h, err := algo.New()
+	if err != nil {
+		return nil, err
+	}
+	var buf [17]byte
+	buf[0] = merkleLeafPrefix
+	binary.BigEndian.PutUint64(buf[1:9], uint64(checksum.Start))
+	binary.BigEndian.PutUint64(buf[9:], uint64(checksum.Stop))
+	h.Write(buf[:])
+	h.Write(digest)
+	return h.Sum(nil), nil
+}
+
+func merkleNodeHash(algo HashAlgorithm, left, right []byte) ([]byte, error) {
+	h, err := algo.New()
+	if err != nil {
+		return nil, err
+	}
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil), nil
+}
+
+// largestPowerOfTwoBelow returns the largest power of two strictly less than n, for n > 1
+func largestPowerOfTwoBelow(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// VerifyInclusionProof checks that the proof's checksum is included in the tree with the proof's root and size
+func VerifyInclusionProof(proof types.InclusionProof) error {
+	algo, err := ParseHashAlgorithm(proof.Checksum.Algorithm)
+	if err != nil {
+		return err
+	}
+	if proof.Index >= proof.Size {
+		return fmt.Errorf("leaf index %d is out of range for a tree of size %d", proof.Index, proof.Size)
+	}
+	expectedRoot, err := hex.DecodeString(proof.Root)
+	if err != nil {
+		return fmt.Errorf("root is not hex encoded: %w", err)
+	}
+	r, err := merkleLeafHash(algo, proof.Checksum)
+	if err != nil {
+		return err
+	}
+	// RFC 9162 section 2.1.3.2
+	fn, sn := proof.Index, proof.Size-1
+	for _, p := range proof.Path {
+		sibling, err := hex.DecodeString(p)
+		if err != nil {
+			return fmt.Errorf("proof path is not hex encoded: %w", err)
+		}
+		if sn == 0 {
+			return fmt.Errorf("proof path is longer than expected for a tree of size %d", proof.Size)
+		}
+		if fn&1 == 1 || fn == sn {
+			if r, err = merkleNodeHash(algo, sibling, r); err != nil {
+				return err
+			}
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else if r, err = merkleNodeHash(algo, r, sibling); err != nil {
+			return err
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("proof path is shorter than expected for a tree of size %d", proof.Size)
+	}
+	if !bytes.Equal(r, expectedRoot) {
+		return fmt.Errorf("proof does not match root %s", proof.Root)
+	}
+	return nil
+}
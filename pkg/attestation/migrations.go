@@ -0,0 +1,232 @@
+package attestation
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// RepoMigration is a single forward migration of the checksums.db schema
+type RepoMigration struct {
+	// Version is the schema version after the migration is applied
+	Version     uint
+	Description string
+	stmts       []string
+}
+
+// repoMigrations are the ordered checksums.db migrations, the latest version is the version of the last entry
+// new migrations must only ever be appended
+var repoMigrations = []RepoMigration{
+	{
+		Version:     1,
+		Description: "create checksums table",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS checksums (
+			 hash VARCHAR(66) PRIMARY KEY ON CONFLICT REPLACE,
+			 start INTEGER NOT NULL,
+			 stop INTEGER NOT NULL,
+			 UNIQUE (start, stop) ON CONFLICT REPLACE
+			)`,
+			`CREATE INDEX IF NOT EXISTS checksum_hashes ON checksums (hash)`,
+			`CREATE INDEX IF NOT EXISTS checksum_starts ON checksums (start)`,
+			`CREATE INDEX IF NOT EXISTS checksum_stops ON checksums (stop)`,
+		},
+	},
+	{
+		// version 1 checksums were produced by the SQLite `.sha3sum` dot-command, which we record as format version 0
+		Version:     2,
+		Description: "add algo and format_version columns to checksums",
+		stmts: []string{
+			`ALTER TABLE checksums RENAME TO checksums_v1`,
+			`CREATE TABLE checksums (
+			 hash VARCHAR(66) NOT NULL,
+			 start INTEGER NOT NULL,
+			 stop INTEGER NOT NULL,
+			 algo VARCHAR(32) NOT NULL,
+			 format_version INTEGER NOT NULL,
+			 PRIMARY KEY (hash, algo) ON CONFLICT REPLACE,
+			 UNIQUE (start, stop, algo) ON CONFLICT REPLACE
+			)`,
+			`INSERT INTO checksums (hash, start, stop, algo, format_version)
+			 SELECT hash, start, stop, 'sha3-256', 0 FROM checksums_v1`,
+			`DROP TABLE checksums_v1`,
+			`CREATE INDEX IF NOT EXISTS checksum_hashes ON checksums (hash)`,
+			`CREATE INDEX IF NOT EXISTS checksum_starts ON checksums (start)`,
+			`CREATE INDEX IF NOT EXISTS checksum_stops ON checksums (stop)`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add signer and signature columns to checksums",
+		stmts: []string{
+			`ALTER TABLE checksums ADD COLUMN signer VARCHAR(128) NOT NULL DEFAULT ''`,
+			`ALTER TABLE checksums ADD COLUMN signature TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "create comparisons table",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS comparisons (
+			 peer VARCHAR(255) NOT NULL,
+			 start INTEGER NOT NULL,
+			 stop INTEGER NOT NULL,
+			 algo VARCHAR(32) NOT NULL,
+			 local_hash VARCHAR(66) NOT NULL,
+			 remote_hash VARCHAR(66) NOT NULL,
+			 agree BOOLEAN NOT NULL,
+			 checked_at INTEGER NOT NULL,
+			 UNIQUE (peer, start, stop, algo) ON CONFLICT REPLACE
+			)`,
+			`CREATE INDEX IF NOT EXISTS comparison_disagreements ON comparisons (agree, peer)`,
+		},
+	},
+}
+
+var (
+	createRepoMetaStmt = `CREATE TABLE IF NOT EXISTS _meta (
+    	version UINT64 NOT NULL UNIQUE
+	)`
+	insertRepoVersionStmt  = "INSERT OR IGNORE INTO _meta (version) VALUES (?)"
+	getRepoVersionStmt     = "SELECT COALESCE(MAX(version), 0) FROM _meta"
+	hasRepoMetaStmt        = "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = '_meta')"
+	hasChecksumsTableStmt  = "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'checksums')"
+	hasChecksumsColumnStmt = "SELECT EXISTS(SELECT 1 FROM pragma_table_info('checksums') WHERE name = ?)"
+)
+
+// LatestRepoVersion returns the checksums.db schema version supported by this build
+func LatestRepoVersion() uint {
+	return repoMigrations[len(repoMigrations)-1].Version
+}
+
+// repoVersion returns the current schema version of the checksums.db
+func repoVersion(repoDB *sql.DB) (uint, error) {
+	var hasMeta bool
+	if err := repoDB.QueryRow(hasRepoMetaStmt).Scan(&hasMeta); err != nil {
+		return 0, xerrors.Errorf("inspect checksum db schema: %w", err)
+	}
+	if !hasMeta {
+		return unversionedRepoVersion(repoDB)
+	}
+	var version uint
+	if err := repoDB.QueryRow(getRepoVersionStmt).Scan(&version); err != nil {
+		return 0, xerrors.Errorf("get checksum db version: %w", err)
+	}
+	return version, nil
+}
+
+// unversionedRepoVersion infers the schema version of a checksums.db created before the _meta table was introduced
+func unversionedRepoVersion(repoDB *sql.DB) (uint, error) {
+	var hasChecksums, hasAlgo bool
+	if err := repoDB.QueryRow(hasChecksumsTableStmt).Scan(&hasChecksums); err != nil {
+		return 0, xerrors.Errorf("inspect checksum db schema: %w", err)
+	}
+	if !hasChecksums {
+		return 0, nil
+	}
+	if err := repoDB.QueryRow(hasChecksumsColumnStmt, "algo").Scan(&hasAlgo); err != nil {
+		return 0, xerrors.Errorf("inspect checksum db schema: %w", err)
+	}
+	if hasAlgo {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// pendingRepoMigrations returns the current schema version and the migrations that need to be applied to reach the
+// latest version
+func pendingRepoMigrations(repoDB *sql.DB) (uint, []RepoMigration, error) {
+	version, err := repoVersion(repoDB)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > LatestRepoVersion() {
+		return version, nil, xerrors.Errorf("checksum db version %d is newer than the latest version %d supported by this build",
+			version, LatestRepoVersion())
+	}
+	var pending []RepoMigration
+	for _, m := range repoMigrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return version, pending, nil
+}
+
+// migrateRepo applies any pending migrations to the checksums.db, each in its own transaction
+func migrateRepo(repoDB *sql.DB) ([]RepoMigration, error) {
+	version, pending, err := pendingRepoMigrations(repoDB)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := repoDB.Exec(createRepoMetaStmt); err != nil {
+		return nil, xerrors.Errorf("create checksum db _meta table: %w", err)
+	}
+	// stamp the versions an unversioned checksums.db already satisfies
+	for v := uint(1); v <= version; v++ {
+		if _, err := repoDB.Exec(insertRepoVersionStmt, v); err != nil {
+			return nil, xerrors.Errorf("set checksum db version: %w", err)
+		}
+	}
+	for i, m := range pending {
+		logrus.Infof("migrating checksum db to version %d: %s", m.Version, m.Description)
+		if err := applyRepoMigration(repoDB, m); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+func applyRepoMigration(repoDB *sql.DB, m RepoMigration) error {
+	tx, err := repoDB.Begin()
+	if err != nil {
+		return err
+	}
+	rollback := func(err error) error {
+		if err := tx.Rollback(); err != nil {
+			logrus.Errorf("rollback error: %s", err.Error())
+		}
+		return err
+	}
+	for _, stmt := range m.stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return rollback(xerrors.Errorf("migrate checksum db to version %d (stmt: %s): %w", m.Version, stmt, err))
+		}
+	}
+	if _, err := tx.Exec(insertRepoVersionStmt, m.Version); err != nil {
+		return rollback(xerrors.Errorf("set checksum db version %d: %w", m.Version, err))
+	}
+	return tx.Commit()
+}
+
+// RepoMigrationStatus returns the current schema version of the checksums.db in the given directory, along with the
+// migrations that would be applied when it is next opened, without modifying it
+func RepoMigrationStatus(repoDir string) (uint, []RepoMigration, error) {
+	repoDBPath := filepath.Join(repoDir, repoDBName)
+	if _, err := os.Stat(repoDBPath); errors.Is(err, fs.ErrNotExist) {
+		return 0, repoMigrations, nil
+	}
+	repoDB, err := sql.Open("sqlite3", repoDBPath+"?mode=ro")
+	if err != nil {
+		return 0, nil, xerrors.Errorf("open sqlite3 database: %w", err)
+	}
+	defer repoDB.Close()
+	return pendingRepoMigrations(repoDB)
+}
+
+// MigrateRepo upgrades the checksums.db in the given directory to the latest schema version
+// it returns the migrations that were applied
+func MigrateRepo(repoDir string) ([]RepoMigration, error) {
+	repoDB, err := sql.Open("sqlite3", filepath.Join(repoDir, repoDBName)+"?mode=rwc")
+	if err != nil {
+		return nil, xerrors.Errorf("open sqlite3 database: %w", err)
+	}
+	defer repoDB.Close()
+	return migrateRepo(repoDB)
+}
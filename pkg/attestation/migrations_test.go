@@ -0,0 +1,79 @@
+package attestation
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateNewRepo(t *testing.T) {
+	dir := t.TempDir()
+	version, pending, err := RepoMigrationStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 || len(pending) != len(repoMigrations) {
+		t.Fatalf("expected version 0 with all migrations pending, got version %d with %d pending", version, len(pending))
+	}
+	repo, _, err := NewRepo(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+	version, pending, err = RepoMigrationStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestRepoVersion() || len(pending) != 0 {
+		t.Fatalf("expected version %d with no migrations pending, got version %d with %d pending",
+			LatestRepoVersion(), version, len(pending))
+	}
+}
+
+func TestMigrationStatusIsReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, repoDBName)+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(createRepoMetaStmt); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRepoMigration(db, repoMigrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	for i := 0; i < 2; i++ {
+		version, pending, err := RepoMigrationStatus(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 1 || len(pending) != len(repoMigrations)-1 {
+			t.Fatalf("expected version 1 with %d pending, got version %d with %d pending",
+				len(repoMigrations)-1, version, len(pending))
+		}
+	}
+	applied, err := MigrateRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(repoMigrations)-1 {
+		t.Fatalf("expected %d migrations to be applied, got %d", len(repoMigrations)-1, len(applied))
+	}
+}
+
+func TestRefuseNewerRepo(t *testing.T) {
+	dir := t.TempDir()
+	repo, _, err := NewRepo(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.repoDB.Exec(insertRepoVersionStmt, LatestRepoVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+	if _, _, err := NewRepo(dir, 2); err == nil {
+		t.Fatal("expected opening a checksum db from a newer version to fail")
+	}
+}
@@ -7,6 +7,8 @@ var _ types.Checksummer = &CheckSummer{}
 type CheckSummer struct {
 	gaps     [][2]uint
 	checkSum string
+	messages []types.MessageRow
+	highest  *uint
 	err      error
 }
 
@@ -18,20 +20,63 @@ func (c *CheckSummer) SetGaps(gaps [][2]uint) {
 	c.gaps = gaps
 }
 
-func (c *CheckSummer) Checksum(start, stop uint) (string, error) {
-	return c.checkSum, c.err
+func (c *CheckSummer) Checksum(start, stop uint) (types.Checksum, error) {
+	return types.Checksum{
+		Start:         start,
+		Stop:          stop,
+		Hash:          c.checkSum,
+		Algorithm:     "sha3-256",
+		FormatVersion: 1,
+	}, c.err
 }
 
 func (c *CheckSummer) SetChecksum(hash string) {
 	c.checkSum = hash
 }
 
+func (c *CheckSummer) Messages(start, stop uint) ([]types.MessageRow, error) {
+	messages := make([]types.MessageRow, 0)
+	for _, m := range c.messages {
+		if m.Epoch >= int64(start) && m.Epoch <= int64(stop) {
+			messages = append(messages, m)
+		}
+	}
+	return messages, c.err
+}
+
+func (c *CheckSummer) SetMessages(messages []types.MessageRow) {
+	c.messages = messages
+}
+
 func (c *CheckSummer) SetErr(err error) {
 	c.err = err
 }
 
 func (c *CheckSummer) CheckRangeIsPopulated(start, stop uint) (bool, error) {
-	return len(c.gaps) == 0, c.err
+	if c.highest != nil && stop > *c.highest {
+		return false, c.err
+	}
+	for _, gap := range c.gaps {
+		if gap[0] <= stop && gap[1] >= start {
+			return false, c.err
+		}
+	}
+	return true, c.err
+}
+
+func (c *CheckSummer) HighestEpoch() (uint, bool, error) {
+	if c.highest == nil {
+		return 0, false, c.err
+	}
+	return *c.highest, true, c.err
+}
+
+func (c *CheckSummer) SetHighestEpoch(epoch uint) {
+	c.highest = &epoch
+}
+
+func (c *CheckSummer) Algorithm() string {
+	return "sha3-256"
 }
 
 func (c *CheckSummer) Close() error {
@@ -0,0 +1,112 @@
+package mocks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	ltypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+)
+
+var _ api.Gateway = &Gateway{}
+
+// Gateway is a mock api.Gateway that serves ChainHead and ChainNotify
+// the embedded api.Gateway is nil, so calling any other method panics
+type Gateway struct {
+	api.Gateway
+	mu          sync.Mutex
+	head        *ltypes.TipSet
+	subscribers []chan []*api.HeadChange
+}
+
+// NewGateway returns a mock gateway whose chain head is at the given height
+func NewGateway(height abi.ChainEpoch) *Gateway {
+	return &Gateway{head: TipSet(height, 0)}
+}
+
+// TipSet returns a single block tipset at the given height, the nonce distinguishes forks at the same height
+func TipSet(height abi.ChainEpoch, nonce uint64) *ltypes.TipSet {
+	c, err := cid.Decode("bafyreicmaj5hhoy5mgqvamfhgexxyergw7hdeshizghodwkjg6qmpoco7i")
+	if err != nil {
+		panic(err)
+	}
+	miner, err := address.NewIDAddress(1000 + nonce)
+	if err != nil {
+		panic(err)
+	}
+	ts, err := ltypes.NewTipSet([]*ltypes.BlockHeader{{
+		Miner:                 miner,
+		Ticket:                &ltypes.Ticket{VRFProof: []byte(fmt.Sprintf("ticket-%d", nonce))},
+		ParentWeight:          ltypes.NewInt(0),
+		Height:                height,
+		ParentStateRoot:       c,
+		ParentMessageReceipts: c,
+		Messages:              c,
+		ParentBaseFee:         ltypes.NewInt(0),
+	}})
+	if err != nil {
+		panic(err)
+	}
+	return ts
+}
+
+func (g *Gateway) ChainHead(ctx context.Context) (*ltypes.TipSet, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.head, nil
+}
+
+func (g *Gateway) ChainNotify(ctx context.Context) (<-chan []*api.HeadChange, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ch := make(chan []*api.HeadChange, 16)
+	ch <- []*api.HeadChange{{Type: "current", Val: g.head}}
+	g.subscribers = append(g.subscribers, ch)
+	return ch, nil
+}
+
+// Notify sends the head changes to the subscribers, and moves the head to the last applied tipset
+func (g *Gateway) Notify(changes ...*api.HeadChange) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, change := range changes {
+		if change.Type == "apply" {
+			g.head = change.Val
+		}
+	}
+	for _, ch := range g.subscribers {
+		ch <- changes
+	}
+}
+
+// Apply advances the head to the given height
+func (g *Gateway) Apply(height abi.ChainEpoch) {
+	g.Notify(&api.HeadChange{Type: "apply", Val: TipSet(height, 0)})
+}
+
+// Reorg reverts the tipsets from the given height up to the head, and applies replacing tipsets up to the same height
+func (g *Gateway) Reorg(from abi.ChainEpoch) {
+	head, _ := g.ChainHead(context.Background())
+	var changes []*api.HeadChange
+	for height := head.Height(); height >= from; height-- {
+		changes = append(changes, &api.HeadChange{Type: "revert", Val: TipSet(height, 0)})
+	}
+	for height := from; height <= head.Height(); height++ {
+		changes = append(changes, &api.HeadChange{Type: "apply", Val: TipSet(height, 1)})
+	}
+	g.Notify(changes...)
+}
+
+// CloseSubscriptions closes the ChainNotify channels, as a node does when the connection is lost
+func (g *Gateway) CloseSubscriptions() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, ch := range g.subscribers {
+		close(ch)
+	}
+	g.subscribers = nil
+}
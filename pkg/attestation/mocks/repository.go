@@ -2,6 +2,8 @@ package mocks
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/vulcanize/lotus-utils/pkg/types"
 )
@@ -9,9 +11,12 @@ import (
 var _ types.ChecksumRepository = &Repo{}
 
 type Repo struct {
+	mu            sync.Mutex
 	interval      uint
-	checksums     map[string]rng
+	checksums     map[string]types.Checksum
 	orderedRanges []rng
+	comparisons   []types.Comparison
+	closed        bool
 	err           error
 }
 
@@ -22,129 +27,210 @@ type rng struct {
 func NewRepo(interval uint, err error) *Repo {
 	return &Repo{
 		interval:      interval,
-		checksums:     make(map[string]rng),
+		checksums:     make(map[string]types.Checksum),
 		orderedRanges: make([]rng, 0),
 		err:           err,
 	}
 }
 
-func (r *Repo) PublishChecksum(start, stop uint, hash string) error {
+func (r *Repo) PublishChecksum(checksum types.Checksum) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.checksums == nil {
-		r.checksums = make(map[string]rng)
+		r.checksums = make(map[string]types.Checksum)
 	}
 	if r.orderedRanges == nil {
 		r.orderedRanges = make([]rng, 0)
 	}
-	r.checksums[hash] = rng{start, stop}
-	r.orderedRanges = appendSort(r.orderedRanges, rng{start, stop})
+	// replace any checksum for the same range and algorithm, like the UNIQUE constraint of checksums.db
+	for hash, existing := range r.checksums {
+		if existing.Start == checksum.Start && existing.Stop == checksum.Stop && existing.Algorithm == checksum.Algorithm {
+			delete(r.checksums, hash)
+		}
+	}
+	r.checksums[checksum.Hash] = checksum
+	r.orderedRanges = appendSort(r.orderedRanges, rng{checksum.Start, checksum.Stop})
 	return r.err
 }
 
 // assumes the provided slice is already sorted
 func appendSort(slice []rng, addition rng) []rng {
-	max := len(slice)
-	for i, r := range slice {
-		// still seeking
-		if addition.start <= r.stop {
+	i := sort.Search(len(slice), func(i int) bool { return slice[i].start >= addition.start })
+	if i < len(slice) && slice[i] == addition {
+		// the range is already recorded, e.g. under a different algorithm
+		return slice
+	}
+	slice = append(slice, rng{})
+	copy(slice[i+1:], slice[i:])
+	slice[i] = addition
+	return slice
+}
+
+func (r *Repo) ChecksumExists(hash, algo string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	checksum, ok := r.checksums[hash]
+	return ok && (algo == "" || checksum.Algorithm == algo), r.err
+}
+
+func (r *Repo) GetChecksum(start, stop uint, algo string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for hash, checksum := range r.checksums {
+		if checksum.Start == start && checksum.Stop == stop && (algo == "" || checksum.Algorithm == algo) {
+			return hash, r.err
+		}
+	}
+	return "", r.err
+}
+
+func (r *Repo) ListChecksums(req types.ListChecksumsRequest) ([]types.Checksum, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	checksums := make([]types.Checksum, 0, len(r.checksums))
+	for _, checksum := range r.checksums {
+		if checksum.Start < req.From || (req.To != 0 && checksum.Stop > req.To) {
+			continue
+		}
+		if req.Algorithm != "" && checksum.Algorithm != req.Algorithm {
 			continue
 		}
-		nextPosition := i + 1
-		if nextPosition >= max {
-			// there is no next, so we just append
-			return append(slice, addition)
+		if req.AfterAlgorithm != "" && (checksum.Start < req.AfterStart ||
+			(checksum.Start == req.AfterStart && checksum.Algorithm <= req.AfterAlgorithm)) {
+			continue
 		}
-		// there is a next, so check that we are below it
-		next := slice[nextPosition]
-		if addition.stop < next.start {
-			// we belong inbetween these rngs
-			newSlice := make([]rng, max+1)
-			copy(newSlice[:i], slice[:i])
-			newSlice[nextPosition] = addition
-			copy(newSlice[nextPosition+1:], slice[nextPosition:])
-			return newSlice
+		checksums = append(checksums, checksum)
+	}
+	sort.Slice(checksums, func(i, j int) bool {
+		if checksums[i].Start != checksums[j].Start {
+			return checksums[i].Start < checksums[j].Start
 		}
-		// we are not below the next record, continue
+		return checksums[i].Algorithm < checksums[j].Algorithm
+	})
+	if req.Limit > 0 && uint(len(checksums)) > req.Limit {
+		checksums = checksums[:req.Limit]
 	}
-	return nil // unreachable
+	return checksums, r.err
 }
 
-func (r *Repo) ChecksumExists(hash string) (bool, error) {
-	_, ok := r.checksums[hash]
-	return ok, r.err
+func (r *Repo) GetChecksumByHash(hash string) (types.Checksum, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	checksum, ok := r.checksums[hash]
+	return checksum, ok, r.err
 }
 
-func (r *Repo) GetChecksum(start, stop uint) (string, error) {
-	for hash, rng := range r.checksums {
-		if rng.start == start && rng.stop == stop {
-			return hash, r.err
+func (r *Repo) DeleteChecksum(start, stop uint, algo string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted, remaining bool
+	for hash, checksum := range r.checksums {
+		if checksum.Start != start || checksum.Stop != stop {
+			continue
+		}
+		if algo == "" || checksum.Algorithm == algo {
+			delete(r.checksums, hash)
+			deleted = true
+		} else {
+			remaining = true
 		}
 	}
-	return "", r.err
+	if deleted && !remaining {
+		for i, current := range r.orderedRanges {
+			if current == (rng{start, stop}) {
+				r.orderedRanges = append(r.orderedRanges[:i], r.orderedRanges[i+1:]...)
+				break
+			}
+		}
+	}
+	return deleted, r.err
+}
+
+func (r *Repo) RecordComparison(comparison types.Comparison) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.comparisons {
+		if c.Peer == comparison.Peer && c.Start == comparison.Start && c.Stop == comparison.Stop &&
+			c.Algorithm == comparison.Algorithm {
+			r.comparisons[i] = comparison
+			return r.err
+		}
+	}
+	r.comparisons = append(r.comparisons, comparison)
+	return r.err
+}
+
+func (r *Repo) ListComparisons(req types.ListComparisonsRequest) ([]types.Comparison, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	comparisons := make([]types.Comparison, 0, len(r.comparisons))
+	for _, c := range r.comparisons {
+		if (req.Peer == "" || c.Peer == req.Peer) && (!req.DisagreementsOnly || !c.Agree) {
+			comparisons = append(comparisons, c)
+		}
+	}
+	sort.Slice(comparisons, func(i, j int) bool {
+		if comparisons[i].Peer != comparisons[j].Peer {
+			return comparisons[i].Peer < comparisons[j].Peer
+		}
+		return comparisons[i].Start < comparisons[j].Start
+	})
+	if req.Limit > 0 && uint(len(comparisons)) > req.Limit {
+		comparisons = comparisons[:req.Limit]
+	}
+	return comparisons, r.err
 }
 
 func (r *Repo) FindNextChecksum() (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if len(r.checksums) == 0 {
 		return 0, nil
 	}
 	var latest uint = 0
-	for _, rng := range r.checksums {
-		if rng.stop > latest {
-			latest = rng.stop
+	for _, checksum := range r.checksums {
+		if checksum.Stop > latest {
+			latest = checksum.Stop
 		}
 	}
 	return latest + 1, r.err
 }
 
 func (r *Repo) FindGaps(start, stop int) ([][2]uint, error) {
-	if stop < start {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if start >= 0 && stop >= 0 && start > stop {
 		return nil, fmt.Errorf("start (%d) is higher than stop (%d) epoch", start, stop)
 	}
-	var uStart, uStop uint
-	if start < 0 {
-		uStart = 0
-	} else {
-		uStart = uint(start)
-	}
-	if stop < 0 {
-		maxRng := r.orderedRanges[len(r.orderedRanges)-1]
-		stop = int(maxRng.stop)
-	} else {
-		uStop = uint(stop)
-	}
-	// no records, the entire range is a "gap"
-	if len(r.orderedRanges) == 0 {
-		return [][2]uint{{uStart, uStop}}, r.err
-	}
-	gaps := make([][2]uint, 0)
-	max := len(r.orderedRanges)
-	firstRecord := r.orderedRanges[0]
-	if firstRecord.start > uStart {
-		// the missing head is considered a gap
-		gaps = append(gaps, [2]uint{uStart, firstRecord.start - 1})
-	}
-	for i, current := range r.orderedRanges {
-		// haven't entered inspected range yet
-		if current.start < uStart {
-			continue
+	// the ranges overlapping the inspected range
+	inRange := make([]rng, 0, len(r.orderedRanges))
+	for _, current := range r.orderedRanges {
+		if (start < 0 || current.stop >= uint(start)) && (stop < 0 || current.start <= uint(stop)) {
+			inRange = append(inRange, current)
 		}
-		// have exceeded the inspected range, return any collected gaps
-		if uStop <= current.stop {
-			return gaps, r.err
-		}
-		nextPosition := i + 1
-		// there are no more records, return any collected  gaps
-		// the missing tail counts as a gap
-		if nextPosition >= max {
-			gaps = append(gaps, [2]uint{current.stop + 1, uStop})
-			return gaps, r.err
+	}
+	if len(inRange) == 0 {
+		if start >= 0 && stop >= 0 {
+			// no records, the entire range is a "gap"
+			return [][2]uint{{uint(start), uint(stop)}}, r.err
 		}
-		next := r.orderedRanges[nextPosition]
-		// we have a gap between the two records
-		if next.start != current.stop+1 {
-			gaps = append(gaps, [2]uint{current.stop + 1, next.start - 1})
+		return nil, r.err
+	}
+	var gaps [][2]uint
+	if start >= 0 && inRange[0].start > uint(start) {
+		// the missing head is considered a gap
+		gaps = append(gaps, [2]uint{uint(start), inRange[0].start - 1})
+	}
+	for i := 1; i < len(inRange); i++ {
+		if inRange[i].start > inRange[i-1].stop+1 {
+			gaps = append(gaps, [2]uint{inRange[i-1].stop + 1, inRange[i].start - 1})
 		}
 	}
-	return nil, r.err // unreachable
+	if last := inRange[len(inRange)-1]; stop >= 0 && last.stop < uint(stop) {
+		// the missing tail is considered a gap
+		gaps = append(gaps, [2]uint{last.stop + 1, uint(stop)})
+	}
+	return gaps, r.err
 }
 
 func (r *Repo) Interval() uint {
@@ -152,11 +238,22 @@ func (r *Repo) Interval() uint {
 }
 
 func (r *Repo) Close() error {
-	r.checksums = make(map[string]rng)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checksums = make(map[string]types.Checksum)
 	r.orderedRanges = make([]rng, 0)
+	r.closed = true
 	return r.err
 }
 
+func (r *Repo) Closed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
 func (r *Repo) SetError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.err = err
 }
@@ -0,0 +1,207 @@
+package attestation
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+const (
+	// rpcServiceName is the name the API is registered under with net/rpc
+	rpcServiceName = "API"
+	// rpcConnected is the status net/rpc responds to the HTTP CONNECT request with
+	rpcConnected = "200 Connected to Go RPC"
+	// peerDialTimeout bounds how long connecting to a peer may take, including the TLS handshake
+	peerDialTimeout = 30 * time.Second
+	// peerCallTimeout bounds how long a call to a peer may take, including the CONNECT handshake of a new connection,
+	// so that a peer that stops responding does not hold up the caller
+	peerCallTimeout = 2 * time.Minute
+)
+
+var _ types.Peer = (*rpcPeer)(nil)
+
+// rpcPeer is a Peer backed by the net/rpc HTTP endpoint of a remote attestation server
+// the connection is established on first use and re-established after it is lost
+type rpcPeer struct {
+	addr string
+	opts PeerOptions
+	// mu serializes the calls, so that each one can set the deadline of the connection
+	mu sync.Mutex
+
+	// connMu guards the connection, it is not held during calls so that Close can interrupt a call in flight
+	connMu sync.Mutex
+	client *rpc.Client
+	conn   net.Conn
+	closed bool
+}
+
+// PeerOptions configures how a Peer connects to a remote attestation server
+type PeerOptions struct {
+	// Token is the API token to authenticate with, for servers that require one, it is sent as a bearer token
+	Token string
+	// TLS is the client TLS configuration, the connection is not encrypted if nil
+	TLS *tls.Config
+}
+
+// NewPeer returns a Peer for the net/rpc endpoint of the attestation server at the given host:port address
+func NewPeer(addr string) types.Peer {
+	return &rpcPeer{addr: addr}
+}
+
+// NewPeerWithOptions returns a Peer for the net/rpc endpoint of the attestation server at the given host:port address,
+// connecting with the given options
+func NewPeerWithOptions(addr string, opts PeerOptions) types.Peer {
+	return &rpcPeer{addr: addr, opts: opts}
+}
+
+// ID implements types.Peer
+func (p *rpcPeer) ID() string {
+	return p.addr
+}
+
+func (p *rpcPeer) call(method string, args any, reply any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	deadline := time.Now().Add(peerCallTimeout)
+	client, conn, err := p.connection(deadline)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		p.reset(client)
+		return err
+	}
+	err = client.Call(rpcServiceName+"."+method, args, reply)
+	// errors returned by the peer's API leave the connection usable, anything else means it is lost or timed out
+	var serverErr rpc.ServerError
+	if err != nil && !errors.As(err, &serverErr) {
+		p.reset(client)
+		return err
+	}
+	// the client keeps reading the idle connection for the next response
+	conn.SetDeadline(time.Time{})
+	return err
+}
+
+// connection returns the connection to the peer, dialing it with the given deadline if there is none
+func (p *rpcPeer) connection(deadline time.Time) (*rpc.Client, net.Conn, error) {
+	p.connMu.Lock()
+	client, conn, closed := p.client, p.conn, p.closed
+	p.connMu.Unlock()
+	if closed {
+		return nil, nil, rpc.ErrShutdown
+	}
+	if client != nil {
+		return client, conn, nil
+	}
+	client, conn, err := p.dial(deadline)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	// the peer may have been closed while dialing
+	if p.closed {
+		client.Close()
+		return nil, nil, rpc.ErrShutdown
+	}
+	p.client, p.conn = client, conn
+	return client, conn, nil
+}
+
+// reset closes the client, if it is still the peer's connection, so that the next call dials a new one
+func (p *rpcPeer) reset(client *rpc.Client) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	if p.client == client {
+		p.client, p.conn = nil, nil
+	}
+	client.Close()
+}
+
+// dial connects to the net/rpc HTTP endpoint of the peer, like rpc.DialHTTPPath but optionally over TLS
+// the CONNECT handshake must complete by the deadline
+func (p *rpcPeer) dial(deadline time.Time) (*rpc.Client, net.Conn, error) {
+	// the token is sent in a header rather than the query string, which servers and proxies log
+	connect := "CONNECT " + rpc.DefaultRPCPath + " HTTP/1.0\n"
+	if p.opts.Token != "" {
+		if strings.ContainsAny(p.opts.Token, "\r\n") {
+			return nil, nil, fmt.Errorf("connect to peer %s: API token contains a line break", p.addr)
+		}
+		connect += "Authorization: Bearer " + p.opts.Token + "\n"
+	}
+	dialer := &net.Dialer{Timeout: peerDialTimeout, Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if p.opts.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.addr, p.opts.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", p.addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if _, err := io.WriteString(conn, connect+"\n"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("connect to peer %s: %w", p.addr, err)
+	}
+	if res.Status != rpcConnected {
+		conn.Close()
+		return nil, nil, fmt.Errorf("connect to peer %s: unexpected HTTP response %s", p.addr, res.Status)
+	}
+	return rpc.NewClient(conn), conn, nil
+}
+
+// GetChecksum implements types.Peer
+func (p *rpcPeer) GetChecksum(rng types.GetChecksumRequest) (string, error) {
+	var hash string
+	err := p.call("GetChecksum", rng, &hash)
+	return hash, err
+}
+
+// ComputeChecksum implements types.Peer
+func (p *rpcPeer) ComputeChecksum(rng types.GetChecksumRequest) (string, error) {
+	var hash string
+	err := p.call("ComputeChecksum", rng, &hash)
+	return hash, err
+}
+
+// GetMessages implements types.Peer
+func (p *rpcPeer) GetMessages(rng types.GetMessagesRequest) ([]types.MessageRow, error) {
+	var messages []types.MessageRow
+	err := p.call("GetMessages", rng, &messages)
+	return messages, err
+}
+
+// Close implements io.Closer
+// it does not wait for a call in flight, which fails with rpc.ErrShutdown, and later calls fail the same way
+func (p *rpcPeer) Close() error {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	p.closed = true
+	if p.client == nil {
+		return nil
+	}
+	err := p.client.Close()
+	p.client, p.conn = nil, nil
+	return err
+}
@@ -2,14 +2,15 @@ package attestation
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
 
 	"github.com/vulcanize/lotus-utils/pkg/types"
@@ -18,34 +19,47 @@ import (
 var _ types.ChecksumRepository = (*Repo)(nil)
 
 var (
-	repoDBName               = "checksums.db"
-	checkSumExistsStmt       = "SELECT EXISTS(SELECT 1 FROM checksums WHERE hash = ?)"
-	insertCheckSumStmt       = "INSERT INTO checksums (start, stop, hash) VALUES (?, ?, ?)"
-	getChecksumForRangeStmt  = "SELECT hash FROM checksums where start = ? AND stop = ?"
-	findLatestCheckSumStmt   = "SELECT stop FROM checksums ORDER BY stop DESC LIMIT 1"
-	findChecksumGapsBaseStmt = "SELECT start as first_missing, (next_start-1) as last_missing from " +
-		"(SELECT start, stop, LEAD(start) OVER (ORDER BY start) AS next_start) h WHERE next_start > stop + 1"
-	findChecksumGapsBaseStmt2 = "SELECT start + ? AS first_missing, (next_nc - ?) AS last_missing " +
-		"FROM (SELECT start, LEAD(start) OVER (ORDER BY start) AS next_nc FROM checksums %s) h " +
-		"WHERE next_nc > start + ?"
-	defaultChecksumChunkSize uint = 2880
-)
+	repoDBName         = "checksums.db"
+	checkSumExistsStmt = "SELECT EXISTS(SELECT 1 FROM checksums WHERE hash = ? AND (? = '' OR algo = ?))"
+	insertCheckSumStmt = "INSERT INTO checksums (start, stop, hash, algo, format_version, signer, signature) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?)"
+	// if no algorithm is specified, prefer the checksum with the latest format version
+	getChecksumForRangeStmt = "SELECT hash FROM checksums WHERE start = ? AND stop = ? AND (? = '' OR algo = ?) " +
+		"ORDER BY format_version DESC, algo LIMIT 1"
+	listChecksumsStmt = "SELECT start, stop, hash, algo, format_version, signer, signature FROM checksums " +
+		"WHERE start >= ? AND (? = 0 OR stop <= ?) AND (? = '' OR algo = ?) " +
+		"AND (? = '' OR start > ? OR (start = ? AND algo > ?)) ORDER BY start, algo LIMIT ?"
+	getChecksumByHashStmt = "SELECT start, stop, hash, algo, format_version, signer, signature FROM checksums " +
+		"WHERE hash = ? ORDER BY start, algo LIMIT 1"
+	// an empty algo deletes the checksums for the range produced by every algorithm
+	deleteChecksumStmt   = "DELETE FROM checksums WHERE start = ? AND stop = ? AND (? = '' OR algo = ?)"
+	insertComparisonStmt = "INSERT INTO comparisons (peer, start, stop, algo, local_hash, remote_hash, agree, checked_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	listComparisonsStmt = "SELECT peer, start, stop, algo, local_hash, remote_hash, agree, checked_at FROM comparisons " +
+		"WHERE (? = '' OR peer = ?) AND (? = 0 OR agree = 0) ORDER BY peer, start, algo LIMIT ?"
+	findLatestCheckSumStmt = "SELECT stop FROM checksums ORDER BY stop DESC LIMIT 1"
+	// the LEAD is over DISTINCT ranges so that checksums of the same range with different algorithms are not mistaken for gaps
+	findChecksumGapsBaseStmt = "SELECT stop + 1 AS first_missing, next_start - 1 AS last_missing " +
+		"FROM (SELECT start, stop, LEAD(start) OVER (ORDER BY start) AS next_start " +
+		"FROM (SELECT DISTINCT start, stop FROM checksums %s)) h WHERE next_start > stop + 1"
+	findChecksumBoundsBaseStmt      = "SELECT MIN(start), MAX(stop) FROM checksums %s"
+	defaultChecksumChunkSize   uint = 2880
 
-var repoDBDefs = []string{
-	`CREATE TABLE IF NOT EXISTS checksums (
-     hash VARCHAR(66) PRIMARY KEY ON CONFLICT REPLACE,
-     start INTEGER NOT NULL,
-     stop INTEGER NOT NULL,
-	 UNIQUE (start, stop) ON CONFLICT REPLACE
-   )`,
-	`CREATE INDEX IF NOT EXISTS checksum_hashes ON checksums (hash)`,
-	`CREATE INDEX IF NOT EXISTS checksum_starts ON checksums (start)`,
-	`CREATE INDEX IF NOT EXISTS checksum_stops ON checksums (stop)`,
-}
+	// checksums of an older format version, e.g. migrated from a legacy repo, are gaps in the Merkle tree
+	listMerkleLeavesStmt = "SELECT start, stop, hash FROM checksums WHERE algo = ? AND format_version = ? AND start >= ? " +
+		"ORDER BY start, stop"
+	// the (hash, algo) primary key replaces the checksum of any other range with the same hash on insert
+	findChecksumStartByHashStmt = "SELECT start FROM checksums WHERE hash = ? AND algo = ? AND start != ?"
+)
 
 type Repo struct {
 	repoDB   *sql.DB
 	interval uint
+
+	// merkleMu guards the Merkle trees over the consecutive chunks from epoch 0, which are loaded per algorithm on
+	// first use and then kept up to date as checksums are published and deleted
+	merkleMu sync.Mutex
+	merkle   map[HashAlgorithm]*merkleAccumulator
 }
 
 // NewRepo creates a new checksum repository object
@@ -70,37 +84,298 @@ func NewRepo(repoDir string, interval uint) (*Repo, bool, error) {
 	if err != nil {
 		return nil, existed, xerrors.Errorf("open sqlite3 database: %w", err)
 	}
-	for _, stmt := range repoDBDefs {
-		_, err = repoDB.Exec(stmt)
-		if err != nil {
-			return nil, existed, xerrors.Errorf("create checksum db schema (stmt: %s): %w", stmt, err)
+	if _, err := migrateRepo(repoDB); err != nil {
+		repoDB.Close()
+		return nil, existed, err
+	}
+	return &Repo{repoDB: repoDB, interval: interval, merkle: make(map[HashAlgorithm]*merkleAccumulator)}, existed, nil
+}
+
+// PublishChecksum publishes the given checksum, along with its signature if it is signed
+func (r *Repo) PublishChecksum(checksum types.Checksum) error {
+	r.merkleMu.Lock()
+	defer r.merkleMu.Unlock()
+	tree := r.merkle[HashAlgorithm(checksum.Algorithm)]
+	// the first leaf the insert changes, or the size of the tree if it changes none
+	var changed uint
+	if tree != nil {
+		changed = tree.size()
+		if leaf, ok := r.merkleLeafIndex(checksum.Start); ok && leaf < changed {
+			changed = leaf
 		}
+		var replaced uint
+		err := r.repoDB.QueryRow(findChecksumStartByHashStmt, checksum.Hash, checksum.Algorithm, checksum.Start).Scan(&replaced)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if leaf, ok := r.merkleLeafIndex(replaced); err == nil && ok && leaf < changed {
+			changed = leaf
+		}
+	}
+	if _, err := r.repoDB.Exec(insertCheckSumStmt, checksum.Start, checksum.Stop, checksum.Hash, checksum.Algorithm,
+		checksum.FormatVersion, checksum.Signer, checksum.Signature); err != nil {
+		return err
+	}
+	if tree == nil {
+		return nil
 	}
-	return &Repo{repoDB: repoDB, interval: interval}, existed, nil
+	tree.truncate(changed)
+	if err := r.extendMerkle(tree); err != nil {
+		// the tree is rebuilt on next use
+		delete(r.merkle, tree.algo)
+		return xerrors.Errorf("published checksum for range %d to %d but failed to update the Merkle tree: %w",
+			checksum.Start, checksum.Stop, err)
+	}
+	return nil
 }
 
-// PublishChecksum publishes the given checksum hash for the given range
-func (r *Repo) PublishChecksum(start, stop uint, hash string) error {
-	_, err := r.repoDB.Exec(insertCheckSumStmt, start, stop, hash)
-	return err
+// merkleLeafIndex returns the index of the leaf for the chunk starting at the given epoch, false if it is not the start
+// of a chunk
+func (r *Repo) merkleLeafIndex(start uint) (uint, bool) {
+	return start / (r.interval + 1), start%(r.interval+1) == 0
+}
+
+// merkleTree returns the Merkle tree for the given algorithm, loading it on first use
+// the caller must hold merkleMu
+func (r *Repo) merkleTree(algo HashAlgorithm) (*merkleAccumulator, error) {
+	if tree, ok := r.merkle[algo]; ok {
+		return tree, nil
+	}
+	tree := newMerkleAccumulator(algo)
+	if err := r.extendMerkle(tree); err != nil {
+		return nil, err
+	}
+	r.merkle[algo] = tree
+	return tree, nil
+}
+
+// extendMerkle appends the published checksums of the chunks following the tree's last leaf, up to the first gap
+func (r *Repo) extendMerkle(tree *merkleAccumulator) error {
+	next := tree.size() * (r.interval + 1)
+	rows, err := r.repoDB.Query(listMerkleLeavesStmt, tree.algo.String(), ChecksumFormatVersion, next)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		checksum := types.Checksum{Algorithm: tree.algo.String()}
+		if err := rows.Scan(&checksum.Start, &checksum.Stop, &checksum.Hash); err != nil {
+			return err
+		}
+		if checksum.Start != next || checksum.Stop != next+r.interval {
+			break
+		}
+		if err := tree.append(checksum); err != nil {
+			return err
+		}
+		next += r.interval + 1
+	}
+	return rows.Err()
+}
+
+// MerkleRoot returns the root of the Merkle tree over the first size consecutive chunks from epoch 0 produced by the
+// given algorithm, or over all of them if size is zero
+func (r *Repo) MerkleRoot(algorithm string, size uint) (types.MerkleRoot, error) {
+	algo, err := ParseHashAlgorithm(algorithm)
+	if err != nil {
+		return types.MerkleRoot{}, err
+	}
+	r.merkleMu.Lock()
+	defer r.merkleMu.Unlock()
+	tree, size, err := r.merkleTreeOfSize(algo, size)
+	if err != nil {
+		return types.MerkleRoot{}, err
+	}
+	root, err := tree.root(size)
+	if err != nil {
+		return types.MerkleRoot{}, err
+	}
+	return types.MerkleRoot{
+		Algorithm: algo.String(),
+		Root:      hex.EncodeToString(root),
+		Size:      size,
+		Start:     0,
+		Stop:      size*(r.interval+1) - 1,
+	}, nil
+}
+
+// InclusionProof returns a proof that the checksum for the given chunk is included in the Merkle tree over the first
+// size consecutive chunks from epoch 0 produced by the given algorithm, or over all of them if size is zero
+func (r *Repo) InclusionProof(start, stop uint, algorithm string, size uint) (types.InclusionProof, error) {
+	algo, err := ParseHashAlgorithm(algorithm)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	r.merkleMu.Lock()
+	defer r.merkleMu.Unlock()
+	tree, size, err := r.merkleTreeOfSize(algo, size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	index, ok := r.merkleLeafIndex(start)
+	if !ok || stop != start+r.interval || index >= size {
+		return types.InclusionProof{}, fmt.Errorf("no checksum for range %d to %d in the tree of size %d", start, stop, size)
+	}
+	checksums, err := r.ListChecksums(types.ListChecksumsRequest{From: start, To: stop, Algorithm: algo.String(), Limit: 1})
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	if len(checksums) == 0 {
+		return types.InclusionProof{}, fmt.Errorf("no checksum for range %d to %d in the tree of size %d", start, stop, size)
+	}
+	root, err := tree.root(size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	path, err := tree.path(index, size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	proof := types.InclusionProof{
+		Checksum: checksums[0],
+		Index:    index,
+		Size:     size,
+		Root:     hex.EncodeToString(root),
+		Path:     make([]string, len(path)),
+	}
+	for i, p := range path {
+		proof.Path[i] = hex.EncodeToString(p)
+	}
+	return proof, nil
+}
+
+// merkleTreeOfSize returns the tree for the given algorithm and the validated size, the full tree for a size of zero
+// the caller must hold merkleMu
+func (r *Repo) merkleTreeOfSize(algo HashAlgorithm, size uint) (*merkleAccumulator, uint, error) {
+	tree, err := r.merkleTree(algo)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tree.size() == 0 {
+		return nil, 0, fmt.Errorf("no %s checksum for the first chunk, 0 to %d, the Merkle tree is anchored at epoch 0",
+			algo, r.interval)
+	}
+	if size > tree.size() {
+		return nil, 0, fmt.Errorf("requested tree size %d exceeds the %d consecutive chunks available from epoch 0",
+			size, tree.size())
+	}
+	if size == 0 {
+		size = tree.size()
+	}
+	return tree, size, nil
 }
 
 // ChecksumExists checks if the given checksum hash exists in the repository
-func (r *Repo) ChecksumExists(hash string) (bool, error) {
+// an empty algo matches a checksum produced by any algorithm
+func (r *Repo) ChecksumExists(hash, algo string) (bool, error) {
 	var exists bool
-	return false, r.repoDB.QueryRow(checkSumExistsStmt, hash).Scan(&exists)
+	err := r.repoDB.QueryRow(checkSumExistsStmt, hash, algo, algo).Scan(&exists)
+	return exists, err
 }
 
-// GetChecksum gets the checksum for the given range
-func (r *Repo) GetChecksum(start, stop uint) (string, error) {
+// GetChecksum gets the checksum for the given range produced by the given algorithm
+// an empty algo matches a checksum produced by any algorithm
+func (r *Repo) GetChecksum(start, stop uint, algo string) (string, error) {
 	var hash string
-	err := r.repoDB.QueryRow(getChecksumForRangeStmt, start, stop).Scan(&hash)
+	err := r.repoDB.QueryRow(getChecksumForRangeStmt, start, stop, algo, algo).Scan(&hash)
 	if err == sql.ErrNoRows {
 		return "", nil
 	}
 	return hash, err
 }
 
+// ListChecksums lists the checksums selected by the request, ordered by start
+func (r *Repo) ListChecksums(req types.ListChecksumsRequest) ([]types.Checksum, error) {
+	// a negative LIMIT is unbounded in sqlite
+	limit := int64(-1)
+	if req.Limit > 0 {
+		limit = int64(req.Limit)
+	}
+	rows, err := r.repoDB.Query(listChecksumsStmt, req.From, req.To, req.To, req.Algorithm, req.Algorithm,
+		req.AfterAlgorithm, req.AfterStart, req.AfterStart, req.AfterAlgorithm, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var checksums []types.Checksum
+	for rows.Next() {
+		var checksum types.Checksum
+		if err := rows.Scan(&checksum.Start, &checksum.Stop, &checksum.Hash, &checksum.Algorithm,
+			&checksum.FormatVersion, &checksum.Signer, &checksum.Signature); err != nil {
+			return nil, err
+		}
+		checksums = append(checksums, checksum)
+	}
+	return checksums, rows.Err()
+}
+
+// GetChecksumByHash returns the published checksum with the given hash, false if there is none
+func (r *Repo) GetChecksumByHash(hash string) (types.Checksum, bool, error) {
+	var checksum types.Checksum
+	err := r.repoDB.QueryRow(getChecksumByHashStmt, hash).Scan(&checksum.Start, &checksum.Stop, &checksum.Hash,
+		&checksum.Algorithm, &checksum.FormatVersion, &checksum.Signer, &checksum.Signature)
+	if err == sql.ErrNoRows {
+		return types.Checksum{}, false, nil
+	}
+	if err != nil {
+		return types.Checksum{}, false, err
+	}
+	return checksum, true, nil
+}
+
+// DeleteChecksum deletes the checksum for the given range produced by the given algorithm, false if there was none
+// an empty algo deletes the checksums produced by every algorithm
+func (r *Repo) DeleteChecksum(start, stop uint, algo string) (bool, error) {
+	r.merkleMu.Lock()
+	defer r.merkleMu.Unlock()
+	res, err := r.repoDB.Exec(deleteChecksumStmt, start, stop, algo, algo)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil || deleted == 0 {
+		return false, err
+	}
+	if leaf, ok := r.merkleLeafIndex(start); ok {
+		for treeAlgo, tree := range r.merkle {
+			if algo == "" || treeAlgo.String() == algo {
+				tree.truncate(leaf)
+			}
+		}
+	}
+	return true, nil
+}
+
+// RecordComparison records the result of comparing one of our checksums with a peer's, replacing any previous result
+func (r *Repo) RecordComparison(comparison types.Comparison) error {
+	_, err := r.repoDB.Exec(insertComparisonStmt, comparison.Peer, comparison.Start, comparison.Stop,
+		comparison.Algorithm, comparison.LocalHash, comparison.RemoteHash, comparison.Agree, comparison.CheckedAt)
+	return err
+}
+
+// ListComparisons lists the recorded peer comparisons selected by the request
+func (r *Repo) ListComparisons(req types.ListComparisonsRequest) ([]types.Comparison, error) {
+	limit := int64(-1)
+	if req.Limit > 0 {
+		limit = int64(req.Limit)
+	}
+	rows, err := r.repoDB.Query(listComparisonsStmt, req.Peer, req.Peer, req.DisagreementsOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var comparisons []types.Comparison
+	for rows.Next() {
+		var c types.Comparison
+		if err := rows.Scan(&c.Peer, &c.Start, &c.Stop, &c.Algorithm, &c.LocalHash, &c.RemoteHash, &c.Agree,
+			&c.CheckedAt); err != nil {
+			return nil, err
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons, rows.Err()
+}
+
 // FindNextChecksum finds the `start` epoch for the next checksum that needs to be published
 func (r *Repo) FindNextChecksum() (uint, error) {
 	var lastStop uint
@@ -112,25 +387,41 @@ func (r *Repo) FindNextChecksum() (uint, error) {
 }
 
 // FindGaps finds gaps in the checksums table between start and stop (inclusive)
+// a negative start or stop leaves that end of the range open, in which case the epochs before the first or after the
+// last checksum are not considered missing
 func (r *Repo) FindGaps(start, stop int) ([][2]uint, error) {
+	if start >= 0 && stop >= 0 && start > stop {
+		return nil, xerrors.Errorf("start epoch cannot be greater than stop epoch")
+	}
+	// select the chunks overlapping the range
 	var where string
-	if start >= 0 && stop >= 0 && start <= stop {
-		where = fmt.Sprintf("WHERE epoch >= %d AND epoch <= %d", start, stop)
+	if start >= 0 && stop >= 0 {
+		where = fmt.Sprintf("WHERE stop >= %d AND start <= %d", start, stop)
 	} else if start >= 0 {
-		where = fmt.Sprintf("WHERE epoch >= %d", start)
+		where = fmt.Sprintf("WHERE stop >= %d", start)
 	} else if stop >= 0 {
-		where = fmt.Sprintf("WHERE epoch <= %d", stop)
+		where = fmt.Sprintf("WHERE start <= %d", stop)
 	}
-	rows, err := r.repoDB.Query(fmt.Sprintf(findChecksumGapsBaseStmt2, where), r.interval, r.interval, r.interval)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			logrus.Infof("No gaps found for range %d to %d", start, stop)
-			return nil, nil
+	var first, last sql.NullInt64
+	if err := r.repoDB.QueryRow(fmt.Sprintf(findChecksumBoundsBaseStmt, where)).Scan(&first, &last); err != nil {
+		return nil, err
+	}
+	if !first.Valid {
+		if start >= 0 && stop >= 0 {
+			// no records, the entire range is a gap
+			return [][2]uint{{uint(start), uint(stop)}}, nil
 		}
+		return nil, nil
+	}
+	var gaps [][2]uint
+	if start >= 0 && first.Int64 > int64(start) {
+		gaps = append(gaps, [2]uint{uint(start), uint(first.Int64 - 1)})
+	}
+	rows, err := r.repoDB.Query(fmt.Sprintf(findChecksumGapsBaseStmt, where))
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var gaps [][2]uint
 	for rows.Next() {
 		var gapStart, gapStop uint
 		if err := rows.Scan(&gapStart, &gapStop); err != nil {
@@ -138,6 +429,12 @@ func (r *Repo) FindGaps(start, stop int) ([][2]uint, error) {
 		}
 		gaps = append(gaps, [2]uint{gapStart, gapStop})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if stop >= 0 && last.Int64 < int64(stop) {
+		gaps = append(gaps, [2]uint{uint(last.Int64 + 1), uint(stop)})
+	}
 	return gaps, nil
 }
 
@@ -1 +1,276 @@
 package attestation
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func newTestRepo(t *testing.T) *Repo {
+	t.Helper()
+	repo, _, err := NewRepo(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestRepoChecksumsByAlgorithm(t *testing.T) {
+	repo := newTestRepo(t)
+	for _, checksum := range []types.Checksum{
+		{Start: 0, Stop: 2, Hash: "aa", Algorithm: SHA3_256.String(), FormatVersion: 1},
+		{Start: 0, Stop: 2, Hash: "bb", Algorithm: SHA256.String(), FormatVersion: 1},
+	} {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := repo.GetChecksum(0, 2, SHA256.String())
+	if err != nil || hash != "bb" {
+		t.Fatalf("expected sha256 checksum bb, got %s (err: %v)", hash, err)
+	}
+	hash, err = repo.GetChecksum(0, 2, BLAKE2B_256.String())
+	if err != nil || hash != "" {
+		t.Fatalf("expected no blake2b-256 checksum, got %s (err: %v)", hash, err)
+	}
+	exists, err := repo.ChecksumExists("aa", "")
+	if err != nil || !exists {
+		t.Fatalf("expected checksum aa to exist for any algorithm (err: %v)", err)
+	}
+	exists, err = repo.ChecksumExists("aa", SHA256.String())
+	if err != nil || exists {
+		t.Fatalf("expected checksum aa not to exist for sha256 (err: %v)", err)
+	}
+}
+
+func TestRepoMigratesLegacySchema(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, repoDBName)+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE checksums (
+		 hash VARCHAR(66) PRIMARY KEY ON CONFLICT REPLACE,
+		 start INTEGER NOT NULL,
+		 stop INTEGER NOT NULL,
+		 UNIQUE (start, stop) ON CONFLICT REPLACE
+		)`,
+		`INSERT INTO checksums (hash, start, stop) VALUES ('legacy', 0, 2)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	repo, existed, err := NewRepo(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	if !existed {
+		t.Fatal("expected the repo to be reported as pre-existing")
+	}
+	var algo string
+	var version uint
+	if err := repo.repoDB.QueryRow("SELECT algo, format_version FROM checksums WHERE hash = 'legacy'").Scan(&algo, &version); err != nil {
+		t.Fatal(err)
+	}
+	if algo != SHA3_256.String() || version != 0 {
+		t.Fatalf("expected legacy row to be migrated as sha3-256 format version 0, got %s version %d", algo, version)
+	}
+}
+
+func TestRepoListChecksums(t *testing.T) {
+	repo := newTestRepo(t)
+	for _, checksum := range []types.Checksum{
+		{Start: 6, Stop: 8, Hash: "cc", Algorithm: SHA3_256.String(), FormatVersion: 1},
+		{Start: 0, Stop: 2, Hash: "aa", Algorithm: SHA3_256.String(), FormatVersion: 1},
+		{Start: 3, Stop: 5, Hash: "bb", Algorithm: SHA3_256.String(), FormatVersion: 1},
+		{Start: 3, Stop: 5, Hash: "dd", Algorithm: SHA256.String(), FormatVersion: 1},
+	} {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checksums, err := repo.ListChecksums(types.ListChecksumsRequest{Algorithm: SHA3_256.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 3 || checksums[0].Hash != "aa" || checksums[1].Hash != "bb" || checksums[2].Hash != "cc" {
+		t.Fatalf("unexpected checksums %+v", checksums)
+	}
+	checksums, err = repo.ListChecksums(types.ListChecksumsRequest{From: 3, To: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 2 || checksums[0].Hash != "dd" || checksums[1].Hash != "bb" {
+		t.Fatalf("unexpected checksums %+v", checksums)
+	}
+	checksums, err = repo.ListChecksums(types.ListChecksumsRequest{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 1 || checksums[0].Hash != "aa" {
+		t.Fatalf("unexpected checksums %+v", checksums)
+	}
+	// resume after the sha256 checksum for 3 to 5, the sha3-256 checksum for the same range sorts after it
+	checksums, err = repo.ListChecksums(types.ListChecksumsRequest{AfterStart: 3, AfterAlgorithm: SHA256.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 2 || checksums[0].Hash != "bb" || checksums[1].Hash != "cc" {
+		t.Fatalf("unexpected checksums %+v", checksums)
+	}
+	checksum, ok, err := repo.GetChecksumByHash("dd")
+	if err != nil || !ok || checksum.Start != 3 || checksum.Algorithm != SHA256.String() {
+		t.Fatalf("unexpected checksum %+v (found: %t, err: %v)", checksum, ok, err)
+	}
+	if _, ok, err = repo.GetChecksumByHash("ee"); err != nil || ok {
+		t.Fatalf("expected no checksum with hash ee (err: %v)", err)
+	}
+	deleted, err := repo.DeleteChecksum(3, 5, SHA256.String())
+	if err != nil || !deleted {
+		t.Fatalf("expected the sha256 checksum for 3 to 5 to be deleted (err: %v)", err)
+	}
+	if hash, err := repo.GetChecksum(3, 5, ""); err != nil || hash != "bb" {
+		t.Fatalf("expected the sha3-256 checksum bb to remain, got %s (err: %v)", hash, err)
+	}
+	if deleted, err = repo.DeleteChecksum(3, 5, SHA256.String()); err != nil || deleted {
+		t.Fatalf("expected nothing left to delete (err: %v)", err)
+	}
+}
+
+func TestRepoStoresSignatures(t *testing.T) {
+	repo := newTestRepo(t)
+	signer, err := newSigner(keyInfo{Type: KeyTypeEd25519, PrivateKey: make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignChecksum(signer, testChecksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.PublishChecksum(signed); err != nil {
+		t.Fatal(err)
+	}
+	var att types.Checksum
+	if err := NewAPI(repo, nil).GetAttestation(types.GetChecksumRequest{Start: signed.Start, Stop: signed.Stop}, &att); err != nil {
+		t.Fatal(err)
+	}
+	if att != signed {
+		t.Fatalf("expected attestation %+v, got %+v", signed, att)
+	}
+	if err := VerifyAttestation(att, []string{signer.ID()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoFindGaps(t *testing.T) {
+	repo := newTestRepo(t)
+	for _, checksum := range []types.Checksum{
+		{Start: 3, Stop: 5, Hash: "aa", Algorithm: SHA3_256.String(), FormatVersion: 1},
+		{Start: 6, Stop: 8, Hash: "bb", Algorithm: SHA3_256.String(), FormatVersion: 1},
+		{Start: 6, Stop: 8, Hash: "cc", Algorithm: SHA256.String(), FormatVersion: 1},
+		{Start: 15, Stop: 17, Hash: "dd", Algorithm: SHA3_256.String(), FormatVersion: 1},
+	} {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		start, stop int
+		expected    string
+	}{
+		{-1, -1, "[[9 14]]"},
+		{0, -1, "[[0 2] [9 14]]"},
+		{-1, 20, "[[9 14] [18 20]]"},
+		{6, 17, "[[9 14]]"},
+		{18, 23, "[[18 23]]"},
+		{3, 8, "[]"},
+	} {
+		gaps, err := repo.FindGaps(tc.start, tc.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(gaps) != tc.expected {
+			t.Fatalf("expected gaps %s between %d and %d, got %v", tc.expected, tc.start, tc.stop, gaps)
+		}
+	}
+}
+
+func TestRepoMerkleTree(t *testing.T) {
+	checksums := newTestChecksums(4, 2)
+	repo := newTestPublishedRepo(t, 2, checksums[1:3])
+	// the tree is anchored at epoch 0, so it is empty until the first chunk is published
+	if _, err := repo.MerkleRoot("", 0); err == nil {
+		t.Fatal("expected an error for a tree missing the first chunk")
+	}
+	// filling the gap and publishing out of order yields the same root as publishing in order
+	for _, checksum := range []types.Checksum{checksums[3], checksums[0]} {
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := repo.MerkleRoot("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := newTestPublishedRepo(t, 2, checksums).MerkleRoot("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != fresh || root.Size != 4 || root.Start != 0 || root.Stop != 11 {
+		t.Fatalf("expected root %+v, got %+v", fresh, root)
+	}
+	// replacing a chunk's checksum changes the root
+	replaced := checksums[1]
+	replaced.Hash = checksums[0].Hash[:len(checksums[0].Hash)-2] + "ff"
+	if err := repo.PublishChecksum(replaced); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := repo.MerkleRoot("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Size != 4 || updated.Root == root.Root {
+		t.Fatalf("expected the root to change after replacing a checksum, got %+v", updated)
+	}
+	// deleting a chunk truncates the tree at the gap
+	if _, err := repo.DeleteChecksum(replaced.Start, replaced.Stop, ""); err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := repo.MerkleRoot("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := newTestPublishedRepo(t, 2, checksums[:1]).MerkleRoot("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix != expected {
+		t.Fatalf("expected root %+v after deleting the second chunk, got %+v", expected, prefix)
+	}
+}
+
+func TestRepoMerkleTreeSkipsLegacyChecksums(t *testing.T) {
+	checksums := newTestChecksums(3, 2)
+	// a checksum migrated from a legacy repo digests a different byte stream, so it is a gap in the tree
+	checksums[1].FormatVersion = 0
+	root, err := newTestPublishedRepo(t, 2, checksums).MerkleRoot("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := newTestPublishedRepo(t, 2, checksums[:1]).MerkleRoot("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != expected {
+		t.Fatalf("expected root %+v stopping at the legacy checksum, got %+v", expected, root)
+	}
+}
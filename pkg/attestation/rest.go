@@ -0,0 +1,385 @@
+package attestation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+const (
+	// RESTPathPrefix is the path prefix the REST API is served under
+	RESTPathPrefix = "/v1/"
+
+	defaultRESTListLimit uint = 100
+	maxRESTListLimit     uint = 1000
+)
+
+// sseKeepAliveInterval is how often a comment is sent on an idle checksum stream, to keep proxies from closing it
+var sseKeepAliveInterval = 30 * time.Second
+
+// ChecksumJSON is the REST representation of a published checksum
+type ChecksumJSON struct {
+	Start         uint   `json:"start"`
+	Stop          uint   `json:"stop"`
+	Hash          string `json:"hash"`
+	Algorithm     string `json:"algorithm"`
+	FormatVersion uint   `json:"format_version"`
+	Signer        string `json:"signer,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+}
+
+// ChecksumListJSON is a page of checksums, NextCursor is set if there are more
+type ChecksumListJSON struct {
+	Checksums  []ChecksumJSON `json:"checksums"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// StatusJSON is the REST representation of the state of the checksum repository
+type StatusJSON struct {
+	Interval uint `json:"interval"`
+	// NextStart is the start epoch of the next chunk to be checksummed
+	NextStart uint `json:"next_start"`
+	// Gaps is the number of gaps between the published checksums
+	Gaps int `json:"gaps"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+func newChecksumJSON(checksum types.Checksum) ChecksumJSON {
+	return ChecksumJSON{
+		Start:         checksum.Start,
+		Stop:          checksum.Stop,
+		Hash:          checksum.Hash,
+		Algorithm:     checksum.Algorithm,
+		FormatVersion: checksum.FormatVersion,
+		Signer:        checksum.Signer,
+		Signature:     checksum.Signature,
+	}
+}
+
+// httpError is an error with the HTTP status code to respond with
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...any) error {
+	return httpError{code: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...any) error {
+	return httpError{code: http.StatusNotFound, err: fmt.Errorf(format, args...)}
+}
+
+// restHandler serves the checksum repository as plain HTTP and JSON
+type restHandler struct {
+	repo   types.ChecksumRepository
+	broker *ChecksumBroker
+}
+
+// NewRESTHandler returns the handler for the REST API over the checksum repository, to be served under RESTPathPrefix:
+//
+//	GET /v1/checksums?from=&to=&algo=&limit=&cursor=
+//	GET /v1/checksums/{start}-{stop}?algo=
+//	GET /v1/checksums/by-hash/{hash}
+//	GET /v1/checksums/stream?from=&algo=
+//	GET /v1/status
+//
+// the stream of newly published checksums is only served if a broker is provided
+func NewRESTHandler(repo types.ChecksumRepository, broker *ChecksumBroker) http.Handler {
+	return &restHandler{repo: repo, broker: broker}
+}
+
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSON(w, http.StatusMethodNotAllowed, errorJSON{Error: "method not allowed"})
+		return
+	}
+	start := time.Now()
+	endpoint := restEndpoint(r.URL.Path)
+	var res any
+	var err error
+	if endpoint == "stream" && h.broker != nil {
+		// streams are long-lived, so only their outcome is recorded and not their duration
+		err = h.streamChecksums(w, r)
+		prom.CountAPIRequest(prom.RESTAPI, endpoint, err)
+	} else {
+		res, err = h.route(r, endpoint)
+		prom.ObserveAPIRequest(prom.RESTAPI, endpoint, start, err)
+	}
+	if err != nil {
+		var herr httpError
+		if !errors.As(err, &herr) {
+			logrus.Errorf("REST request %s failed: %s", r.URL.Path, err.Error())
+			herr = httpError{code: http.StatusInternalServerError, err: errors.New("internal error")}
+		}
+		writeJSON(w, herr.code, errorJSON{Error: herr.Error()})
+		return
+	}
+	if res != nil {
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// restEndpoint names the endpoint the request path is routed to
+func restEndpoint(path string) string {
+	path = strings.TrimSuffix(strings.TrimPrefix(path, RESTPathPrefix), "/")
+	switch {
+	case path == "checksums":
+		return "checksums"
+	case path == "checksums/stream":
+		return "stream"
+	case strings.HasPrefix(path, "checksums/by-hash/"):
+		return "by-hash"
+	case strings.HasPrefix(path, "checksums/"):
+		return "range"
+	case path == "status":
+		return "status"
+	}
+	return "unknown"
+}
+
+func (h *restHandler) route(r *http.Request, endpoint string) (any, error) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, RESTPathPrefix), "/")
+	switch endpoint {
+	case "checksums":
+		return h.listChecksums(r)
+	case "by-hash":
+		return h.checksumByHash(strings.TrimPrefix(path, "checksums/by-hash/"))
+	case "range", "stream":
+		return h.checksumByRange(r, strings.TrimPrefix(path, "checksums/"))
+	case "status":
+		return h.status()
+	}
+	return nil, notFound("no such endpoint %s", r.URL.Path)
+}
+
+func (h *restHandler) listChecksums(r *http.Request) (any, error) {
+	query := r.URL.Query()
+	req := types.ListChecksumsRequest{Algorithm: query.Get("algo"), Limit: defaultRESTListLimit}
+	var err error
+	if req.From, err = parseUintParam(query, "from"); err != nil {
+		return nil, err
+	}
+	if req.To, err = parseUintParam(query, "to"); err != nil {
+		return nil, err
+	}
+	if query.Get("limit") != "" {
+		if req.Limit, err = parseUintParam(query, "limit"); err != nil {
+			return nil, err
+		}
+		if req.Limit == 0 || req.Limit > maxRESTListLimit {
+			return nil, badRequest("limit must be between 1 and %d", maxRESTListLimit)
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if req.AfterStart, req.AfterAlgorithm, err = decodeChecksumCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	// fetch one more than the page to tell whether there is a next page
+	limit := req.Limit
+	req.Limit++
+	checksums, err := h.repo.ListChecksums(req)
+	if err != nil {
+		return nil, err
+	}
+	res := ChecksumListJSON{Checksums: make([]ChecksumJSON, 0, len(checksums))}
+	if uint(len(checksums)) > limit {
+		checksums = checksums[:limit]
+		last := checksums[len(checksums)-1]
+		res.NextCursor = encodeChecksumCursor(last.Start, last.Algorithm)
+	}
+	for _, checksum := range checksums {
+		res.Checksums = append(res.Checksums, newChecksumJSON(checksum))
+	}
+	return res, nil
+}
+
+func (h *restHandler) checksumByRange(r *http.Request, rng string) (any, error) {
+	startStr, stopStr, ok := strings.Cut(rng, "-")
+	if !ok {
+		return nil, badRequest("checksum range must be formatted as {start}-{stop}")
+	}
+	start, err := strconv.ParseUint(startStr, 10, 64)
+	if err != nil {
+		return nil, badRequest("invalid start epoch %q", startStr)
+	}
+	stop, err := strconv.ParseUint(stopStr, 10, 64)
+	if err != nil {
+		return nil, badRequest("invalid stop epoch %q", stopStr)
+	}
+	if start > stop {
+		return nil, badRequest("start epoch cannot be greater than stop epoch")
+	}
+	checksums, err := h.repo.ListChecksums(types.ListChecksumsRequest{
+		Algorithm: r.URL.Query().Get("algo"),
+		From:      uint(start),
+		To:        uint(stop),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, checksum := range checksums {
+		if checksum.Start == uint(start) && checksum.Stop == uint(stop) {
+			return newChecksumJSON(checksum), nil
+		}
+	}
+	return nil, notFound("no checksum found for range %d to %d", start, stop)
+}
+
+func (h *restHandler) checksumByHash(hash string) (any, error) {
+	if hash == "" {
+		return nil, badRequest("checksum hash must be provided")
+	}
+	checksum, ok, err := h.repo.GetChecksumByHash(strings.ToLower(hash))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, notFound("no checksum found with hash %s", hash)
+	}
+	return newChecksumJSON(checksum), nil
+}
+
+func (h *restHandler) status() (any, error) {
+	next, err := h.repo.FindNextChecksum()
+	if err != nil {
+		return nil, err
+	}
+	gaps, err := h.repo.FindGaps(-1, -1)
+	if err != nil {
+		return nil, err
+	}
+	return StatusJSON{Interval: h.repo.Interval(), NextStart: next, Gaps: len(gaps)}, nil
+}
+
+// streamChecksums streams newly published checksums as Server-Sent Events until the client disconnects
+// each event's ID is the start epoch and algorithm of its checksum, {start}/{algo}, so that the checksums of a chunk
+// produced by different algorithms have distinct IDs; if the from parameter is set, the published checksums starting
+// at or after that epoch are replayed first, and if the client reconnects with a Last-Event-ID, those after that event
+func (h *restHandler) streamChecksums(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("response writer does not support streaming")
+	}
+	query := r.URL.Query()
+	req := types.SubscribeChecksumsRequest{Algorithm: query.Get("algo")}
+	if query.Get("from") != "" {
+		from, err := parseUintParam(query, "from")
+		if err != nil {
+			return err
+		}
+		req.Resume, req.From = true, from
+	} else if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		start, algo, err := decodeEventID(lastID)
+		if err != nil {
+			return err
+		}
+		req.Resume, req.From, req.AfterStart, req.AfterAlgorithm = true, start, start, algo
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	checksums, err := SubscribeChecksums(ctx, h.repo, h.broker, req)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+		case checksum, ok := <-checksums:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(newChecksumJSON(checksum))
+			if err != nil {
+				logrus.Errorf("encode checksum event: %s", err.Error())
+				return nil
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: checksum\ndata: %s\n\n", encodeEventID(checksum), data); err != nil {
+				return nil
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func parseUintParam(query map[string][]string, name string) (uint, error) {
+	values := query[name]
+	if len(values) == 0 || values[0] == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid %s parameter %q", name, values[0])
+	}
+	return uint(v), nil
+}
+
+// the cursor is the opaque encoding of the start epoch and algorithm of the last checksum on the page
+func encodeChecksumCursor(start uint, algo string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", start, algo)))
+}
+
+func encodeEventID(checksum types.Checksum) string {
+	return fmt.Sprintf("%d/%s", checksum.Start, checksum.Algorithm)
+}
+
+func decodeEventID(id string) (uint, string, error) {
+	startStr, algo, ok := strings.Cut(id, "/")
+	start, err := strconv.ParseUint(startStr, 10, 64)
+	if !ok || err != nil || algo == "" {
+		return 0, "", badRequest("invalid Last-Event-ID %q", id)
+	}
+	return uint(start), algo, nil
+}
+
+func decodeChecksumCursor(cursor string) (uint, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", badRequest("invalid cursor")
+	}
+	startStr, algo, ok := strings.Cut(string(raw), ":")
+	start, err := strconv.ParseUint(startStr, 10, 64)
+	if !ok || err != nil || algo == "" {
+		return 0, "", badRequest("invalid cursor")
+	}
+	return uint(start), algo, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("write REST response: %s", err.Error())
+	}
+}
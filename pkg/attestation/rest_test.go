@@ -0,0 +1,212 @@
+package attestation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func getJSON(t *testing.T, url string, code int, v any) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != code {
+		t.Fatalf("GET %s: expected status %d, got %d", url, code, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRESTHandler(t *testing.T) {
+	checksums := newTestChecksums(5, 4)
+	sha256Checksum := types.Checksum{Start: 5, Stop: 9, Hash: "ab", Algorithm: SHA256.String(), FormatVersion: 1}
+	repo := newTestMockRepo(t, 4, append(checksums, sha256Checksum))
+	ts := httptest.NewServer(NewRESTHandler(repo, nil))
+	defer ts.Close()
+
+	t.Run("list", func(t *testing.T) {
+		var hashes []string
+		url := ts.URL + "/v1/checksums?limit=2"
+		for pages := 0; ; pages++ {
+			if pages > 3 {
+				t.Fatal("expected 3 pages")
+			}
+			var page ChecksumListJSON
+			getJSON(t, url, http.StatusOK, &page)
+			for _, checksum := range page.Checksums {
+				hashes = append(hashes, checksum.Hash)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			url = ts.URL + "/v1/checksums?limit=2&cursor=" + page.NextCursor
+		}
+		expected := []string{checksums[0].Hash, "ab", checksums[1].Hash, checksums[2].Hash, checksums[3].Hash, checksums[4].Hash}
+		if len(hashes) != len(expected) {
+			t.Fatalf("expected %d checksums, got %d", len(expected), len(hashes))
+		}
+		for i := range expected {
+			if hashes[i] != expected[i] {
+				t.Fatalf("expected checksum %d to be %s, got %s", i, expected[i], hashes[i])
+			}
+		}
+
+		var page ChecksumListJSON
+		getJSON(t, ts.URL+"/v1/checksums?from=5&to=14&algo=sha3-256", http.StatusOK, &page)
+		if len(page.Checksums) != 2 || page.Checksums[0].Start != 5 || page.Checksums[1].Start != 10 || page.NextCursor != "" {
+			t.Fatalf("unexpected page %+v", page)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		var checksum ChecksumJSON
+		getJSON(t, ts.URL+"/v1/checksums/10-14", http.StatusOK, &checksum)
+		if checksum.Hash != checksums[2].Hash || checksum.Algorithm != SHA3_256.String() {
+			t.Fatalf("unexpected checksum %+v", checksum)
+		}
+		getJSON(t, ts.URL+"/v1/checksums/5-9?algo=sha256", http.StatusOK, &checksum)
+		if checksum.Hash != "ab" {
+			t.Fatalf("unexpected checksum %+v", checksum)
+		}
+	})
+
+	t.Run("by hash", func(t *testing.T) {
+		var checksum ChecksumJSON
+		getJSON(t, ts.URL+"/v1/checksums/by-hash/"+checksums[3].Hash, http.StatusOK, &checksum)
+		if checksum.Start != 15 || checksum.Stop != 19 {
+			t.Fatalf("unexpected checksum %+v", checksum)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		var status StatusJSON
+		getJSON(t, ts.URL+"/v1/status", http.StatusOK, &status)
+		if status.Interval != 4 || status.NextStart != 25 || status.Gaps != 0 {
+			t.Fatalf("unexpected status %+v", status)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for path, code := range map[string]int{
+			"/v1/checksums/25-29":               http.StatusNotFound,
+			"/v1/checksums/by-hash/ffff":        http.StatusNotFound,
+			"/v1/nothing":                       http.StatusNotFound,
+			"/v1/checksums/9-5":                 http.StatusBadRequest,
+			"/v1/checksums/five-nine":           http.StatusBadRequest,
+			"/v1/checksums?limit=0":             http.StatusBadRequest,
+			"/v1/checksums?limit=1001":          http.StatusBadRequest,
+			"/v1/checksums?from=-1":             http.StatusBadRequest,
+			"/v1/checksums?cursor=not-a-cursor": http.StatusBadRequest,
+		} {
+			var res errorJSON
+			getJSON(t, ts.URL+path, code, &res)
+			if res.Error == "" {
+				t.Fatalf("GET %s: expected an error message", path)
+			}
+		}
+		res, err := http.Post(ts.URL+"/v1/checksums", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusMethodNotAllowed {
+			t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, res.StatusCode)
+		}
+	})
+}
+
+func TestRESTStreamChecksums(t *testing.T) {
+	checksums := newTestChecksums(4, 4)
+	repo := newTestMockRepo(t, 4, checksums[:3])
+	// the chunk starting at epoch 0 is also published with sha256, which orders before sha3-256
+	sha256Checksum := types.Checksum{Start: 0, Stop: 4, Hash: "ab", Algorithm: SHA256.String(), FormatVersion: 1}
+	if err := repo.PublishChecksum(sha256Checksum); err != nil {
+		t.Fatal(err)
+	}
+	broker := NewChecksumBroker()
+	defer broker.Close()
+	ts := httptest.NewServer(NewRESTHandler(repo, broker))
+	defer ts.Close()
+
+	// resume after the last event for the chunk starting at epoch 0
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/checksums/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "0/sha3-256")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	scanner := bufio.NewScanner(res.Body)
+	next := func() (string, ChecksumJSON) {
+		t.Helper()
+		var id string
+		var checksum ChecksumJSON
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				return id, checksum
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &checksum); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		t.Fatalf("stream ended: %v", scanner.Err())
+		return "", checksum
+	}
+	for _, expected := range checksums[1:3] {
+		if id, checksum := next(); id != fmt.Sprintf("%d/sha3-256", expected.Start) || checksum.Hash != expected.Hash {
+			t.Fatalf("expected replayed event %d %s, got %s %s", expected.Start, expected.Hash, id, checksum.Hash)
+		}
+	}
+	if err := repo.PublishChecksum(checksums[3]); err != nil {
+		t.Fatal(err)
+	}
+	broker.Publish(checksums[3])
+	if id, checksum := next(); id != "15/sha3-256" || checksum.Hash != checksums[3].Hash {
+		t.Fatalf("expected published event 15/sha3-256 %s, got %s %s", checksums[3].Hash, id, checksum.Hash)
+	}
+
+	// a client that only received the sha256 event for the chunk starting at epoch 0 gets the sha3-256 one
+	req.Header.Set("Last-Event-ID", "0/sha256")
+	resumed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Body.Close()
+	scanner = bufio.NewScanner(resumed.Body)
+	if id, checksum := next(); id != "0/sha3-256" || checksum.Hash != checksums[0].Hash {
+		t.Fatalf("expected replayed event 0/sha3-256 %s, got %s %s", checksums[0].Hash, id, checksum.Hash)
+	}
+
+	var errRes errorJSON
+	getJSON(t, ts.URL+"/v1/checksums/stream?from=x", http.StatusBadRequest, &errRes)
+	req.Header.Set("Last-Event-ID", "0")
+	invalid, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid.Body.Close()
+	if invalid.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %d for an event ID without an algorithm, got %d", http.StatusBadRequest, invalid.StatusCode)
+	}
+}
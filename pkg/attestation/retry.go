@@ -0,0 +1,186 @@
+package attestation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// FailureAction is what the checksumming loop does about an error that is not retried, or whose retries ran out
+type FailureAction string
+
+const (
+	// FailureContinue carries on checksumming, trying the failed step again after the maximum retry delay
+	FailureContinue FailureAction = "continue"
+	// FailurePause pauses checksumming until it is resumed through the ResumeChecksumming API, the API is still served
+	FailurePause FailureAction = "pause"
+	// FailureShutdown exits the checksumming loop and sends the error on its error channel, to shut down the process
+	FailureShutdown FailureAction = "shutdown"
+)
+
+const (
+	defaultMaxRetries    = 5
+	defaultRetryMinDelay = time.Second
+	defaultRetryMaxDelay = time.Minute
+	defaultFailureAction = FailurePause
+)
+
+// ParseFailureAction parses the name of a failure action, the default if empty
+func ParseFailureAction(s string) (FailureAction, error) {
+	if s == "" {
+		return defaultFailureAction, nil
+	}
+	action := FailureAction(strings.ToLower(s))
+	switch action {
+	case FailureContinue, FailurePause, FailureShutdown:
+		return action, nil
+	}
+	return "", fmt.Errorf("unsupported failure action %s, expected one of [%s %s %s]", s, FailureContinue, FailurePause,
+		FailureShutdown)
+}
+
+// RetryPolicy is how the checksumming loop handles errors
+// transient errors are retried with exponential backoff up to MaxRetries times, before OnFailure applies to them;
+// other errors have OnFailure applied immediately
+type RetryPolicy struct {
+	MaxRetries uint
+	// MinDelay is the delay before the first retry, it doubles for every retry after up to MaxDelay
+	MinDelay  time.Duration
+	MaxDelay  time.Duration
+	OnFailure FailureAction
+}
+
+// DefaultRetryPolicy returns the retry policy used if none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: defaultMaxRetries,
+		MinDelay:   defaultRetryMinDelay,
+		MaxDelay:   defaultRetryMaxDelay,
+		OnFailure:  defaultFailureAction,
+	}
+}
+
+// NewRetryPolicy reads the retry policy of the checksumming loop, zero retries disables retrying transient errors
+func NewRetryPolicy() (RetryPolicy, error) {
+	viper.SetDefault(CHECKSUM_MAX_RETRIES_TOML, defaultMaxRetries)
+	onFailure, err := ParseFailureAction(viper.GetString(CHECKSUM_ON_FAILURE_TOML))
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	p := RetryPolicy{
+		MaxRetries: viper.GetUint(CHECKSUM_MAX_RETRIES_TOML),
+		MinDelay:   viper.GetDuration(CHECKSUM_RETRY_MIN_DELAY_TOML),
+		MaxDelay:   viper.GetDuration(CHECKSUM_RETRY_MAX_DELAY_TOML),
+		OnFailure:  onFailure,
+	}
+	if p.MinDelay <= 0 {
+		p.MinDelay = defaultRetryMinDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRetryMaxDelay
+	}
+	if p.MinDelay > p.MaxDelay {
+		return RetryPolicy{}, fmt.Errorf("retry min delay (%s) is higher than max delay (%s)", p.MinDelay, p.MaxDelay)
+	}
+	return p, nil
+}
+
+// Backoff returns the delay before the given retry, counting from 1
+func (p RetryPolicy) Backoff(retry uint) time.Duration {
+	delay := p.MinDelay
+	for i := uint(1); i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// IsTransient returns whether the error is SQLITE_BUSY or SQLITE_LOCKED, returned while another connection holds a
+// lock on the database, e.g. while Lotus is writing to msgindex.db
+func IsTransient(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// loopRetrier applies the service's retry policy to the errors of a checksumming loop
+type loopRetrier struct {
+	s       *Service
+	errChan chan<- error
+	// retries is the number of times the current error has been retried
+	retries uint
+}
+
+// reset records the loop making progress, so that the next error is retried from scratch
+func (r *loopRetrier) reset() {
+	if r.retries > 0 {
+		r.retries = 0
+		r.s.loop.setState(types.ChecksumLoopRunning)
+	}
+}
+
+// handle applies the retry policy to an error of the checksumming loop, waiting out any delay before the failed step
+// is tried again; it returns false if the loop must exit
+func (r *loopRetrier) handle(ctx context.Context, err error) bool {
+	policy := r.s.retry
+	if IsTransient(err) && r.retries < policy.MaxRetries {
+		r.retries++
+		delay := policy.Backoff(r.retries)
+		logrus.Warnf("checksumming loop error, retry %d of %d in %s: %s", r.retries, policy.MaxRetries, delay, err.Error())
+		r.s.loop.retry(err, r.retries)
+		return r.s.sleep(ctx, delay)
+	}
+	r.retries = 0
+	switch policy.OnFailure {
+	case FailureContinue:
+		logrus.Errorf("checksumming loop error, continuing in %s: %s", policy.MaxDelay, err.Error())
+		r.s.loop.giveUp(err, types.ChecksumLoopRetrying)
+		if !r.s.sleep(ctx, policy.MaxDelay) {
+			return false
+		}
+		r.s.loop.setState(types.ChecksumLoopRunning)
+		return true
+	case FailurePause:
+		logrus.Errorf("checksumming loop error, pausing until resumed: %s", err.Error())
+		resumed := r.s.loop.pause(err)
+		select {
+		case <-r.s.quit:
+			return false
+		case <-ctx.Done():
+			return false
+		case <-resumed:
+		}
+		logrus.Info("checksumming loop resumed")
+		return true
+	default:
+		logrus.Errorf("checksumming loop error, shutting down: %s", err.Error())
+		r.s.fail(r.errChan, err)
+		return false
+	}
+}
+
+// sleep waits out the delay, returning false if the service is closed or the context is done first
+func (s *Service) sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-s.quit:
+		return false
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,176 @@
+package attestation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation/mocks"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+var errBusy = fmt.Errorf("checksum range: %w", sqlite3.Error{Code: sqlite3.ErrBusy})
+
+// flakyChecksummer fails its next calls to Checksum with the queued errors, before checksumming msgindex.db
+type flakyChecksummer struct {
+	types.Checksummer
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func (f *flakyChecksummer) Checksum(start, stop uint) (types.Checksum, error) {
+	f.mu.Lock()
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		f.mu.Unlock()
+		return types.Checksum{}, err
+	}
+	f.mu.Unlock()
+	return f.Checksummer.Checksum(start, stop)
+}
+
+// newFlakyService returns a service checksumming epochs 0 to 29 in chunks of 5, whose first checksums fail with errs
+func newFlakyService(t *testing.T, policy RetryPolicy, errs ...error) *Service {
+	t.Helper()
+	cs, err := NewChecksummer(newTestMsgIndex(t, newTestRowsForEpochs(0, 29)), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cs.Close() })
+	service, err := NewService(&flakyChecksummer{Checksummer: cs, errs: errs}, mocks.NewRepo(4, nil), nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.retry = policy
+	return service
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, MinDelay: time.Second, MaxDelay: 5 * time.Second}
+	for retry, expected := range map[uint]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second,
+		4: 5 * time.Second, 10: 5 * time.Second} {
+		if delay := policy.Backoff(retry); delay != expected {
+			t.Errorf("expected a delay of %s before retry %d, got %s", expected, retry, delay)
+		}
+	}
+
+	if action, err := ParseFailureAction(""); err != nil || action != FailurePause {
+		t.Errorf("expected the default failure action %s, got %s (err: %v)", FailurePause, action, err)
+	}
+	if action, err := ParseFailureAction("Shutdown"); err != nil || action != FailureShutdown {
+		t.Errorf("expected failure action %s, got %s (err: %v)", FailureShutdown, action, err)
+	}
+	if _, err := ParseFailureAction("restart"); err == nil {
+		t.Error("expected an error for an unsupported failure action")
+	}
+
+	if !IsTransient(errBusy) {
+		t.Error("expected a wrapped SQLITE_BUSY error to be transient")
+	}
+	if IsTransient(sqlite3.Error{Code: sqlite3.ErrCorrupt}) || IsTransient(errors.New("database is locked")) {
+		t.Error("expected other errors not to be transient")
+	}
+}
+
+func TestChecksumLoopRetriesTransientErrors(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, MinDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, OnFailure: FailureShutdown}
+	service := newFlakyService(t, policy, errBusy, errBusy)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := new(sync.WaitGroup)
+	if err, _ := service.Checksum(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the loop to checksum every chunk", func() bool {
+		return service.Status().LoopState == types.ChecksumLoopWaiting
+	})
+	status := service.Status()
+	if status.LastPublishedStop != 29 || status.Retries != 2 || status.Failures != 0 || status.LastError != errBusy.Error() {
+		t.Fatalf("unexpected status after retrying %+v", status)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestChecksumLoopShutsDownAfterMaxRetries(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, MinDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, OnFailure: FailureShutdown}
+	service := newFlakyService(t, policy, errBusy, errBusy, errBusy)
+	wg := new(sync.WaitGroup)
+	err, errChan := service.Checksum(context.Background(), wg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err := <-errChan; !errors.Is(err, errBusy) {
+		t.Fatalf("expected the loop to exit with %v, got %v", errBusy, err)
+	}
+	status := service.Status()
+	if status.LoopState != types.ChecksumLoopFailed || status.Retries != 2 || status.Failures != 1 || status.Published {
+		t.Fatalf("unexpected status after shutting down %+v", status)
+	}
+}
+
+func TestChecksumLoopPausesUntilResumed(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, MinDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, OnFailure: FailurePause}
+	service := newFlakyService(t, policy, errors.New("disk I/O error"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := new(sync.WaitGroup)
+	if err, _ := service.Checksum(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the loop to pause", func() bool {
+		return service.Status().LoopState == types.ChecksumLoopPaused
+	})
+	// errors that are not transient are not retried
+	if status := service.Status(); status.Retries != 0 || status.Failures != 1 || status.LastError != "disk I/O error" {
+		t.Fatalf("unexpected status while paused %+v", status)
+	}
+	// a paused loop is still live, it is waiting on an operator rather than stalled
+	if err := service.live(); err != nil {
+		t.Fatalf("expected a paused loop to be live, got %v", err)
+	}
+
+	if _, err := service.api.resumeChecksumming(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the loop to checksum every chunk", func() bool {
+		return service.Status().LoopState == types.ChecksumLoopWaiting
+	})
+	if status := service.Status(); status.LastPublishedStop != 29 {
+		t.Fatalf("unexpected status after resuming %+v", status)
+	}
+	if _, err := service.api.resumeChecksumming(); err == nil {
+		t.Fatal("expected an error resuming a loop that is not paused")
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestChecksumLoopContinuesAfterFailure(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 1, MinDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, OnFailure: FailureContinue}
+	service := newFlakyService(t, policy, errBusy, errBusy, errors.New("disk I/O error"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := new(sync.WaitGroup)
+	if err, _ := service.Checksum(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the loop to checksum every chunk", func() bool {
+		return service.Status().LoopState == types.ChecksumLoopWaiting
+	})
+	// the first error is retried once before it is given up on, the second is given up on immediately
+	status := service.Status()
+	if status.LastPublishedStop != 29 || status.Retries != 1 || status.Failures != 2 || status.LastError != "disk I/O error" {
+		t.Fatalf("unexpected status after continuing %+v", status)
+	}
+	cancel()
+	wg.Wait()
+}
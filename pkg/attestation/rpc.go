@@ -0,0 +1,136 @@
+package attestation
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/http"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
+)
+
+// rpcMethods are the methods of the API served over net/rpc, calls to any other method are recorded as unknown
+var rpcMethods = func() map[string]struct{} {
+	methods := make(map[string]struct{})
+	t := reflect.TypeOf(&API{})
+	for i := 0; i < t.NumMethod(); i++ {
+		methods[t.Method(i).Name] = struct{}{}
+	}
+	return methods
+}()
+
+// rpcHandler serves a net/rpc server over HTTP, recording each call in the API request metrics
+type rpcHandler struct {
+	server *rpc.Server
+}
+
+// NewRPCHandler returns the handler for serving the API registered with the net/rpc server at rpc.DefaultRPCPath
+// it speaks the same protocol as rpc.Server.ServeHTTP, so peers connect to it as to any net/rpc server
+func NewRPCHandler(server *rpc.Server) http.Handler {
+	return &rpcHandler{server: server}
+}
+
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		logrus.Errorf("rpc hijacking %s: %s", r.RemoteAddr, err.Error())
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 "+rpcConnected+"\n\n")
+	h.server.ServeCodec(newMetricsServerCodec(conn))
+}
+
+// metricsServerCodec is the gob codec net/rpc serves connections with, timing each call from its request header being
+// read to its response being written
+type metricsServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+
+	mu      sync.Mutex
+	started map[uint64]time.Time
+}
+
+func newMetricsServerCodec(conn io.ReadWriteCloser) *metricsServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &metricsServerCodec{
+		rwc:     conn,
+		dec:     gob.NewDecoder(conn),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+		started: make(map[uint64]time.Time),
+	}
+}
+
+func (c *metricsServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.started[r.Seq] = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *metricsServerCodec) ReadRequestBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+func (c *metricsServerCodec) WriteResponse(r *rpc.Response, body any) error {
+	c.mu.Lock()
+	start, ok := c.started[r.Seq]
+	delete(c.started, r.Seq)
+	c.mu.Unlock()
+	if ok {
+		method := strings.TrimPrefix(r.ServiceMethod, rpcServiceName+".")
+		if _, known := rpcMethods[method]; !known {
+			method = "unknown"
+		}
+		var err error
+		if r.Error != "" {
+			err = errors.New(r.Error)
+		}
+		prom.ObserveAPIRequest(prom.RPCAPI, method, start, err)
+	}
+	if err := c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// the header could not be encoded, close the connection to signal that it is broken
+			logrus.Errorf("rpc: gob error encoding response: %s", err.Error())
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			logrus.Errorf("rpc: gob error encoding body: %s", err.Error())
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *metricsServerCodec) Close() error {
+	if c.closed {
+		// only close the connection once
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,111 @@
+package attestation
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// apiRequests returns the number of requests to the method of the API with the given status recorded in the metrics
+func apiRequests(t *testing.T, api, method, status string) float64 {
+	t.Helper()
+	prom.Init()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "lotus_utils_attestation_api_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["api"] == api && labels["method"] == method && labels["status"] == status {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestRPCHandlerMetrics(t *testing.T) {
+	checksums := newTestChecksums(2, 4)
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, NewAPI(newTestMockRepo(t, 4, checksums), nil)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewRPCHandler(server))
+	defer ts.Close()
+	peer := NewPeer(ts.Listener.Addr().String())
+	defer peer.Close()
+
+	ok, failed := apiRequests(t, prom.RPCAPI, "GetChecksum", "ok"), apiRequests(t, prom.RPCAPI, "GetChecksum", "error")
+	hash, err := peer.GetChecksum(types.GetChecksumRequest{Start: 0, Stop: 4})
+	if err != nil || hash != checksums[0].Hash {
+		t.Fatalf("expected checksum %s, got %s (err: %v)", checksums[0].Hash, hash, err)
+	}
+	if _, err := peer.GetChecksum(types.GetChecksumRequest{Start: 1, Stop: 5}); err == nil {
+		t.Fatal("expected an error for a range that is not a chunk")
+	}
+	if n := apiRequests(t, prom.RPCAPI, "GetChecksum", "ok"); n != ok+1 {
+		t.Fatalf("expected %v successful requests, got %v", ok+1, n)
+	}
+	if n := apiRequests(t, prom.RPCAPI, "GetChecksum", "error"); n != failed+1 {
+		t.Fatalf("expected %v failed requests, got %v", failed+1, n)
+	}
+}
+
+func TestJSONRPCAndRESTMetrics(t *testing.T) {
+	checksums := newTestChecksums(2, 4)
+	service, err := NewService(nil, newTestMockRepo(t, 4, checksums), nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcServer := jsonrpc.NewServer()
+	service.RegisterJSONRPC(rpcServer.Register)
+	ts := httptest.NewServer(rpcServer)
+	defer ts.Close()
+	client, closer, err := NewJSONRPCClient(context.Background(), ts.URL+JSONRPCPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	ok, denied := apiRequests(t, prom.JSONRPCAPI, "GetChecksum", "ok"), apiRequests(t, prom.JSONRPCAPI, "DeleteChecksum", "error")
+	if _, err := client.GetChecksum(context.Background(), types.GetChecksumRequest{Start: 0, Stop: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DeleteChecksum(context.Background(), types.DeleteChecksumRequest{Start: 0, Stop: 4}); err == nil {
+		t.Fatal("expected an anonymous caller to be denied")
+	}
+	if n := apiRequests(t, prom.JSONRPCAPI, "GetChecksum", "ok"); n != ok+1 {
+		t.Fatalf("expected %v successful requests, got %v", ok+1, n)
+	}
+	if n := apiRequests(t, prom.JSONRPCAPI, "DeleteChecksum", "error"); n != denied+1 {
+		t.Fatalf("expected %v denied requests, got %v", denied+1, n)
+	}
+
+	rest := httptest.NewServer(service.RESTHandler())
+	defer rest.Close()
+	ok, missing := apiRequests(t, prom.RESTAPI, "range", "ok"), apiRequests(t, prom.RESTAPI, "range", "error")
+	var checksum ChecksumJSON
+	getJSON(t, rest.URL+"/v1/checksums/0-4", 200, &checksum)
+	var errRes errorJSON
+	getJSON(t, rest.URL+"/v1/checksums/10-14", 404, &errRes)
+	if n := apiRequests(t, prom.RESTAPI, "range", "ok"); n != ok+1 {
+		t.Fatalf("expected %v successful requests, got %v", ok+1, n)
+	}
+	if n := apiRequests(t, prom.RESTAPI, "range", "error"); n != missing+1 {
+		t.Fatalf("expected %v failed requests, got %v", missing+1, n)
+	}
+}
@@ -2,36 +2,93 @@ package attestation
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/client"
 	"github.com/sirupsen/logrus"
 
+	"github.com/vulcanize/lotus-utils/pkg/prom"
 	"github.com/vulcanize/lotus-utils/pkg/types"
 )
 
 var _ types.AttestationService = (*Service)(nil)
 
+var errServiceStopped = errors.New("attestation service is stopped")
+
 // Service is the attestation service top-level object
 type Service struct {
 	cs                types.Checksummer
 	r                 types.ChecksumRepository
 	api               *API
+	signer            types.Signer
+	comparer          *Comparer
+	compareInterval   time.Duration
+	backfill          *Backfiller
+	gapCheckInterval  time.Duration
+	follower          *ChainFollower
+	broker            *ChecksumBroker
+	auth              *Authenticator
+	loop              *loopStatus
+	retry             RetryPolicy
+	serving           atomic.Bool
+	startedAt         time.Time
+	closeNode         jsonrpc.ClientCloser
 	start             uint
 	checksumChunkSize uint
 	quit              chan struct{}
+	stopOnce          sync.Once
+	closeOnce         sync.Once
+	closeErr          error
+	// comparerErr is the error of closing the peer connections when the service was stopped
+	comparerErr error
+	// loops tracks the goroutines started by Checksum, Compare and Serve, so that Close can wait for them to exit
+	loops sync.WaitGroup
 }
 
 // NewServiceFromConfig creates a new attestation service from a config object
-func NewServiceFromConfig(c *Config) (*Service, error) {
+func NewServiceFromConfig(c *Config) (_ *Service, err error) {
 	var cs types.Checksummer
-	var err error
+	var repo *Repo
+	var comparer *Comparer
+	// close what was opened if a later step fails
+	defer func() {
+		if err == nil {
+			return
+		}
+		if comparer != nil {
+			comparer.Close()
+		}
+		if repo != nil {
+			repo.Close()
+		}
+		if cs != nil {
+			cs.Close()
+		}
+	}()
 	if c.Checksum {
-		cs, err = NewChecksummer(c.SrcDBDir)
+		checksummer, err := NewChecksummer(c.SrcDBDir, c.HashAlgorithm)
 		if err != nil {
 			return nil, err
 		}
+		cs = checksummer
+	}
+	var signer types.Signer
+	if c.SigningKeyPath != "" {
+		signer, err = LoadSigner(c.SigningKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("signing checksums as %s", signer.ID())
 	}
 	repo, existed, err := NewRepo(c.RepoDBDir, c.ChecksumChunkSize)
 	if err != nil {
@@ -44,41 +101,170 @@ func NewServiceFromConfig(c *Config) (*Service, error) {
 			return nil, err
 		}
 	}
-	return &Service{cs: cs, r: repo, start: start, api: NewAPI(repo), quit: make(chan struct{}), checksumChunkSize: c.ChecksumChunkSize}, nil
+	if c.Compare {
+		opts, err := c.PeerConfig.Options()
+		if err != nil {
+			return nil, err
+		}
+		peers := make([]types.Peer, len(c.Peers))
+		for i, addr := range c.Peers {
+			peers[i] = NewPeerWithOptions(addr, opts)
+		}
+		comparer = NewComparer(repo, peers, c.HashAlgorithm)
+	}
+	authenticator := NewAuthenticator(nil, c.RequireToken)
+	if c.AuthSecretPath != "" {
+		secret, err := LoadOrCreateAuthSecret(c.AuthSecretPath)
+		if err != nil {
+			return nil, err
+		}
+		authenticator = NewAuthenticator(secret, c.RequireToken)
+	}
+	s := &Service{cs: cs, r: repo, signer: signer, comparer: comparer, compareInterval: c.CompareInterval, start: start,
+		api: NewAPI(repo, cs), auth: authenticator, retry: c.RetryPolicy, quit: make(chan struct{}),
+		checksumChunkSize: c.ChecksumChunkSize}
+	s.enableChecksumJobs()
+	s.enableSubscriptions()
+	s.enableStatus()
+	if c.CheckForGaps {
+		s.enableBackfill(c.GapCheckInterval)
+	}
+	if c.LotusAPIURL != "" {
+		node, closer, err := newLotusClient(c.LotusAPIURL, c.LotusAuthTokenPath)
+		if err != nil {
+			return nil, err
+		}
+		s.follow(node, c.FinalityDepth)
+		s.closeNode = closer
+		logrus.Infof("following the chain head of %s with a finality depth of %d", c.LotusAPIURL, s.follower.depth)
+	}
+	return s, nil
+}
+
+// newLotusClient connects to the websocket Lotus full-node or gateway API at the given URL
+func newLotusClient(url, authTokenPath string) (api.Gateway, jsonrpc.ClientCloser, error) {
+	header := http.Header{}
+	if authTokenPath != "" {
+		authToken, err := os.ReadFile(authTokenPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read lotus auth token file: %w", err)
+		}
+		header.Add("Authorization", "Bearer "+strings.TrimSpace(string(authToken)))
+	}
+	node, closer, err := client.NewGatewayRPCV1(context.Background(), url, header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to lotus api: %w", err)
+	}
+	return node, closer, nil
 }
 
 // NewService creates a new attestation service
-// it accepts pre-initialized checksummer and checksum repository objects
+// it accepts pre-initialized checksummer, checksum repository and (optional) signer objects
 // useful for testing with mocks that satisfy these interfaces
-func NewService(cs types.Checksummer, repo types.ChecksumRepository, start, chunkSize uint) (*Service, error) {
+// the service does not accept API tokens, callers have read permission, and its checksumming loop uses the default
+// retry policy
+func NewService(cs types.Checksummer, repo types.ChecksumRepository, signer types.Signer, start, chunkSize uint) (*Service, error) {
 	if repo == nil {
 		return nil, fmt.Errorf("cannot create attestation service without a checksum repository")
 	}
 	if chunkSize == 0 {
 		chunkSize = defaultChecksumChunkSize
 	}
-	return &Service{cs: cs, r: repo, start: start, api: NewAPI(repo), quit: make(chan struct{}), checksumChunkSize: chunkSize}, nil
+	s := &Service{cs: cs, r: repo, signer: signer, start: start, api: NewAPI(repo, cs), auth: NewAuthenticator(nil, false),
+		retry: DefaultRetryPolicy(), quit: make(chan struct{}), checksumChunkSize: chunkSize}
+	s.enableChecksumJobs()
+	s.enableSubscriptions()
+	s.enableStatus()
+	return s, nil
+}
+
+// enableChecksumJobs has the API compute and publish missing checksums on demand, if the service has a checksummer
+func (s *Service) enableChecksumJobs() {
+	if s.cs != nil {
+		s.api.jobs = newChecksumJobs(s.cs, s.publish, s.isFinal)
+	}
+}
+
+// enableSubscriptions fans out every checksum the service publishes to the API's subscribers
+func (s *Service) enableSubscriptions() {
+	s.broker = NewChecksumBroker()
+	s.api.broker = s.broker
+}
+
+// enableStatus has the service track the state of its checksumming loop, and serves it through the API
+func (s *Service) enableStatus() {
+	s.loop = newLoopStatus()
+	s.startedAt = time.Now()
+	s.api.status = s.Status
+	s.api.resume = s.Resume
+}
+
+// enableBackfill has the checksumming loops search the checksum repository for gaps at startup and every interval,
+// and checksum the missing chunks before making forward progress
+func (s *Service) enableBackfill(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultGapCheckInterval
+	}
+	// chunks are anchored at epoch 0, where a service without published checksums starts, s.start moves on as they are
+	// published
+	s.backfill = NewBackfiller(s.r, 0, s.checksumChunkSize)
+	s.gapCheckInterval = interval
+	s.api.backfill = s.backfill
+}
+
+// isFinal returns whether the epoch is final, every epoch is if the service does not follow the chain head
+func (s *Service) isFinal(epoch uint) bool {
+	return s.follower == nil || s.follower.IsFinal(epoch)
+}
+
+// follow has the checksumming loop follow the chain head of the Lotus node
+func (s *Service) follow(node api.Gateway, finalityDepth uint) {
+	s.follower = NewChainFollower(node, finalityDepth)
 }
 
 // Checksum starts the attestation service checksumming and publishing loop
+// if the service follows a Lotus node, chunks are only checksummed once they are final, head changes are waited on
+// instead of polling msgindex.db, and published chunks touched by a reorg are checksummed again
 func (s *Service) Checksum(ctx context.Context, wg *sync.WaitGroup) (error, <-chan error) {
-	// TODO: have a mode for ongoing checksumming while a lotus node continues to process new blocks
-	// TODO: and another mode that operates on offline database and the exits once it runs out of chunks to process
 	if s.r == nil {
 		return fmt.Errorf("cannot checksum without a checksum repository"), nil
 	}
 	if s.cs == nil {
 		return fmt.Errorf("cannot checksum without a checksummer"), nil
 	}
-	// TODO: if c.CheckForGaps == true, check for gaps in the checksum repo and backfill them before starting
+	if s.stopped() {
+		return errServiceStopped, nil
+	}
+	// the chain follower is stopped with the loop, which waits for it to exit
+	followCtx, stopFollowing := s.withQuit(ctx)
+	following := new(sync.WaitGroup)
+	if s.follower != nil {
+		if err := s.follower.Follow(followCtx, following); err != nil {
+			stopFollowing()
+			return err, nil
+		}
+	}
 	wg.Add(1)
+	s.loops.Add(1)
 	start := s.start
-	errChan := make(chan error)
+	// the loop sends at most one error before exiting, buffer it so the loop does not block if nobody is receiving
+	errChan := make(chan error, 1)
+	s.loop.start(start, s.checksumChunkSize)
+	var lastGapCheck, lastGapCount time.Time
+	// reorged holds the start epochs of the published chunks touched by a reorg, waiting to be checksummed again
+	reorged := make(map[uint]uint)
+	// errors are handled by the retry policy, the failed step is tried again unless the loop has to exit
+	retrier := &loopRetrier{s: s, errChan: errChan}
 	go func() {
+		defer wg.Done()
+		defer s.loops.Done()
 		defer func() {
+			stopFollowing()
+			following.Wait()
+			s.loop.stop()
 			logrus.Info("attestation service checksumming loop exited")
 		}()
-		defer wg.Done()
+		// the loop only checks for quit between steps, so a chunk in flight is checksummed and published before it exits
 		for {
 			select {
 			case <-s.quit:
@@ -86,30 +272,93 @@ func (s *Service) Checksum(ctx context.Context, wg *sync.WaitGroup) (error, <-ch
 			case <-ctx.Done():
 				return
 			default:
-				// if the next range is not populated in the src msgindex db, do not continue
+				if prom.Enabled() && time.Since(lastGapCount) >= s.gapCountInterval() {
+					s.countGaps()
+					lastGapCount = time.Now()
+				}
+				// backfill any gaps in the checksum repo before making forward progress
+				if s.backfill != nil {
+					if time.Since(lastGapCheck) >= s.gapCheckInterval {
+						if _, err := s.backfill.FindGaps(); err != nil {
+							if !retrier.handle(ctx, err) {
+								return
+							}
+							continue
+						}
+						lastGapCheck = time.Now()
+					}
+					if rng, ok := s.backfill.Next(); ok {
+						if _, err := s.backfillChunk(rng); err != nil {
+							s.backfill.Requeue(rng)
+							if !retrier.handle(ctx, err) {
+								return
+							}
+							continue
+						}
+						retrier.reset()
+						continue
+					}
+				}
+				// wake on the next head change if following a lotus node, otherwise poll
+				var headChanged <-chan struct{}
+				var poll <-chan time.Time
+				if s.follower != nil {
+					headChanged = s.follower.Changed()
+					if err := s.checksumReorged(reorged, start); err != nil {
+						if !retrier.handle(ctx, err) {
+							return
+						}
+						continue
+					}
+				} else {
+					poll = time.After(30 * time.Second)
+				}
+				// if the next range is not final, or not populated in the src msgindex db, do not continue
 				stop := start + s.checksumChunkSize
-				populated, err := s.cs.CheckRangeIsPopulated(start, stop)
-				if err != nil {
-					errChan <- err
-					return
+				ready := s.follower == nil || s.follower.IsFinal(stop)
+				if ready {
+					populated, err := s.cs.CheckRangeIsPopulated(start, stop)
+					if err != nil {
+						if !retrier.handle(ctx, err) {
+							return
+						}
+						continue
+					}
+					ready = populated
 				}
-				if !populated {
+				if !ready {
 					// the range is incomplete, we need to wait to continue (or fall over, or trigger backfilling the index)
-					// TODO: more sophisticated logic
-					time.Sleep(30 * time.Second)
+					retrier.reset()
+					s.loop.setState(types.ChecksumLoopWaiting)
+					select {
+					case <-s.quit:
+						return
+					case <-ctx.Done():
+						return
+					case <-headChanged:
+					case <-poll:
+					}
+					s.loop.setState(types.ChecksumLoopRunning)
 					continue
 				}
 				// it is populated, so calculate the checksum
 				checksum, err := s.cs.Checksum(start, stop)
 				if err != nil {
-					errChan <- err
-					return
+					if !retrier.handle(ctx, err) {
+						return
+					}
+					continue
 				}
 				// and publish it in the repository
-				if err = s.r.PublishChecksum(start, stop, checksum); err != nil {
-					errChan <- err
-					return
+				if err = s.publish(checksum); err != nil {
+					if !retrier.handle(ctx, err) {
+						return
+					}
+					continue
 				}
+				retrier.reset()
+				s.loop.published(start, stop)
+				prom.SetLastChecksummedEpoch(stop)
 				// assign the next chunk start epoch and continue
 				start = stop + 1
 			}
@@ -118,23 +367,275 @@ func (s *Service) Checksum(ctx context.Context, wg *sync.WaitGroup) (error, <-ch
 	return nil, errChan
 }
 
+// checksumReorged checksums the published chunks touched by a reorg again, once the head has moved past the reverted
+// epochs so that msgindex.db has had the chance to index the replacing tipsets
+// chunks are collected into reorged, keyed by start epoch with the highest reverted epoch in the chunk as the value
+// only chunks below next, the start of the next chunk to checksum, have been published
+func (s *Service) checksumReorged(reorged map[uint]uint, next uint) error {
+	for _, epoch := range s.follower.Reverted() {
+		chunkStart := epoch - epoch%(s.checksumChunkSize+1)
+		if chunkStart >= next {
+			continue
+		}
+		if highest, ok := reorged[chunkStart]; !ok || epoch > highest {
+			reorged[chunkStart] = epoch
+		}
+	}
+	head, _ := s.follower.Head()
+	for chunkStart, epoch := range reorged {
+		if head <= epoch {
+			continue
+		}
+		// the chunk is kept until it is checksummed again, so that it is retried if that fails
+		if err := s.rechecksum(chunkStart, chunkStart+s.checksumChunkSize); err != nil {
+			return err
+		}
+		delete(reorged, chunkStart)
+	}
+	return nil
+}
+
+// rechecksum checksums a published chunk again, and replaces the published checksum if it has changed
+func (s *Service) rechecksum(start, stop uint) error {
+	checksum, err := s.cs.Checksum(start, stop)
+	if err != nil {
+		return err
+	}
+	published, err := s.r.GetChecksum(start, stop, checksum.Algorithm)
+	if err != nil {
+		return err
+	}
+	if published == checksum.Hash {
+		logrus.Infof("checksum for range %d to %d is unchanged by the reorg", start, stop)
+		return nil
+	}
+	logrus.Warnf("reorg changed the checksum for range %d to %d from %s to %s", start, stop, published, checksum.Hash)
+	return s.publish(checksum)
+}
+
+// ChecksumReport summarizes a one-shot checksumming run
+type ChecksumReport struct {
+	// Start and Stop are the range of epochs that was processed, Stop is the highest epoch indexed in msgindex.db
+	Start uint
+	Stop  uint
+	// Written is the number of chunks checksummed and published
+	Written uint
+	// Skipped is the number of complete chunks that were not checksummed because their range has gaps
+	Skipped uint
+	// Gaps are the ranges of epochs missing from msgindex.db between Start and Stop
+	Gaps [][2]uint
+	// Backfilled and BackfillSkipped are the number of chunks missing from the checksum repository that were, or could
+	// not be, backfilled before checksumming from Start
+	Backfilled      uint
+	BackfillSkipped uint
+}
+
+// ChecksumUntilExhausted checksums and publishes every complete chunk from the service's start epoch up to the highest
+// epoch indexed in msgindex.db, and then returns instead of waiting for more epochs to be indexed
+// chunks whose range has gaps are skipped and counted, so that the run can be used against an offline snapshot
+func (s *Service) ChecksumUntilExhausted(ctx context.Context) (ChecksumReport, error) {
+	report := ChecksumReport{Start: s.start}
+	if s.r == nil {
+		return report, fmt.Errorf("cannot checksum without a checksum repository")
+	}
+	if s.cs == nil {
+		return report, fmt.Errorf("cannot checksum without a checksummer")
+	}
+	if s.backfill != nil {
+		if _, err := s.backfill.FindGaps(); err != nil {
+			return report, err
+		}
+		for rng, ok := s.backfill.Next(); ok; rng, ok = s.backfill.Next() {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			backfilled, err := s.backfillChunk(rng)
+			if err != nil {
+				return report, err
+			}
+			if backfilled {
+				report.Backfilled++
+			} else {
+				report.BackfillSkipped++
+			}
+		}
+	}
+	highest, ok, err := s.cs.HighestEpoch()
+	if err != nil {
+		return report, err
+	}
+	if !ok || highest < s.start {
+		logrus.Infof("msgindex.db has no epochs at or above %d to checksum", s.start)
+		report.Stop = s.start
+		return report, nil
+	}
+	report.Stop = highest
+	start := s.start
+	for ; start+s.checksumChunkSize <= highest; start += s.checksumChunkSize + 1 {
+		select {
+		case <-s.quit:
+			return report, fmt.Errorf("attestation service closed")
+		case <-ctx.Done():
+			return report, ctx.Err()
+		default:
+		}
+		stop := start + s.checksumChunkSize
+		populated, err := s.cs.CheckRangeIsPopulated(start, stop)
+		if err != nil {
+			return report, err
+		}
+		if !populated {
+			logrus.Warnf("skipping range %d to %d, it is not fully populated in msgindex.db", start, stop)
+			report.Skipped++
+			continue
+		}
+		checksum, err := s.cs.Checksum(start, stop)
+		if err != nil {
+			return report, err
+		}
+		if err := s.publish(checksum); err != nil {
+			return report, err
+		}
+		prom.SetLastChecksummedEpoch(stop)
+		report.Written++
+	}
+	s.start = start
+	if prom.Enabled() {
+		s.countGaps()
+	}
+	report.Gaps, err = s.cs.FindGaps(int(report.Start), int(report.Stop))
+	return report, err
+}
+
+// gapCountInterval returns how often the checksumming loop counts the gaps in msgindex.db and checksums.db
+func (s *Service) gapCountInterval() time.Duration {
+	if s.gapCheckInterval > 0 {
+		return s.gapCheckInterval
+	}
+	return defaultGapCheckInterval
+}
+
+// countGaps records the number of gaps in msgindex.db and checksums.db in the metrics
+// a failure to count is logged rather than returned, so that it does not stop the checksumming loop
+func (s *Service) countGaps() {
+	if gaps, err := s.cs.FindGaps(-1, -1); err != nil {
+		logrus.Warnf("unable to count the gaps in msgindex.db: %s", err.Error())
+	} else {
+		prom.SetGaps(prom.MsgIndexDB, len(gaps))
+	}
+	if gaps, err := s.r.FindGaps(-1, -1); err != nil {
+		logrus.Warnf("unable to count the gaps in checksums.db: %s", err.Error())
+	} else {
+		prom.SetGaps(prom.ChecksumsDB, len(gaps))
+	}
+}
+
+// backfillChunk checksums and publishes a chunk range queued by the backfiller, if it is populated in msgindex.db
+// it returns whether the chunk was backfilled
+func (s *Service) backfillChunk(rng [2]uint) (bool, error) {
+	populated, err := s.cs.CheckRangeIsPopulated(rng[0], rng[1])
+	if err != nil {
+		return false, err
+	}
+	if !populated {
+		s.backfill.Done(rng, false)
+		return false, nil
+	}
+	checksum, err := s.cs.Checksum(rng[0], rng[1])
+	if err != nil {
+		return false, err
+	}
+	if err := s.publish(checksum); err != nil {
+		return false, err
+	}
+	s.backfill.Done(rng, true)
+	return true, nil
+}
+
+// publish signs the checksum if the service has a signer, publishes it in the repository and sends it to subscribers
+func (s *Service) publish(checksum types.Checksum) error {
+	if s.signer != nil {
+		signed, err := SignChecksum(s.signer, checksum)
+		if err != nil {
+			return err
+		}
+		checksum = signed
+	}
+	if err := s.r.PublishChecksum(checksum); err != nil {
+		return err
+	}
+	s.broker.Publish(checksum)
+	return nil
+}
+
+// Compare starts the background loop that periodically cross-checks our checksums against our peers' checksums
+// divergences are logged and recorded in the repository, where they are surfaced through the API
+func (s *Service) Compare(ctx context.Context, wg *sync.WaitGroup) error {
+	if s.comparer == nil {
+		return fmt.Errorf("cannot compare without any configured peers")
+	}
+	if s.stopped() {
+		return errServiceStopped
+	}
+	interval := s.compareInterval
+	if interval <= 0 {
+		interval = defaultCompareInterval
+	}
+	wg.Add(1)
+	s.loops.Add(1)
+	go func() {
+		defer func() {
+			logrus.Info("attestation service compare loop exited")
+		}()
+		defer wg.Done()
+		defer s.loops.Done()
+		// a comparison in flight is cancelled when the service is stopped
+		ctx, cancel := s.withQuit(ctx)
+		defer cancel()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			report, err := s.comparer.Compare(ctx)
+			if err != nil {
+				logrus.Errorf("peer comparison failed: %s", err.Error())
+			} else {
+				logrus.Infof("peer comparison compared %d ranges: %d agreed, %d diverged, %d missing from peers",
+					report.Compared, report.Agreed, len(report.Divergences), report.Missing)
+			}
+			select {
+			case <-s.quit:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
 // Serve starts an empty loop that waits for a quit signal
 // used to isolate the RPC server loop from the checksum processing loop
 // e.g. can start this with only a checksum repository to serve the RPC API, with no active background checksummer process
 // or can use a cancel ctx passed into `Start` to stop the checksumming processes without stopping this loop
-// TODO: wire the API into here such that
-// 1. users can request a (missing) checksum be calculated
-// 2. if a request to GetChecksum is made for a range that is not yet checksummed, the checksumming process can be triggered (if the range is found to be complete in local msgindex.db)
+// if the service has a checksummer, the API computes missing checksums on demand through RequestChecksum and GetChecksum
 func (s *Service) Serve(ctx context.Context, wg *sync.WaitGroup) error {
 	if s.r == nil {
 		return fmt.Errorf("cannot serve without a checksum repository")
 	}
+	if s.stopped() {
+		return errServiceStopped
+	}
 	wg.Add(1)
+	s.loops.Add(1)
+	s.serving.Store(true)
 	go func() {
+		defer wg.Done()
+		defer s.loops.Done()
 		defer func() {
+			s.serving.Store(false)
 			logrus.Info("attestation service serve loop exited")
 		}()
-		defer wg.Done()
 		for {
 			select {
 			case <-s.quit:
@@ -152,11 +653,91 @@ func (s *Service) Register(reg func(any) error) error {
 	return reg(s.api)
 }
 
+// RegisterJSONRPC registers the JSON-RPC 2.0 API with the provided registration function (e.g. jsonrpc.RPCServer.Register)
+// each method checks the caller's permissions, the server must be wrapped with AuthHandler to authenticate callers
+func (s *Service) RegisterJSONRPC(reg func(namespace string, handler any)) {
+	reg(JSONRPCNamespace, NewPermissionedJSONRPCHandler(s.api, s.auth.DefaultPermissions()))
+}
+
+// RESTHandler returns the handler for the read-only REST API over the published checksums, served under RESTPathPrefix
+// it includes the Server-Sent Events stream of newly published checksums
+func (s *Service) RESTHandler() http.Handler {
+	return NewRESTHandler(s.r, s.broker)
+}
+
+// AuthHandler authenticates the requests to next by their API token, rejecting those without the required permission
+// an empty required permission lets every request with a valid or no token through, for per-method permission checks
+func (s *Service) AuthHandler(required auth.Permission, next http.Handler) http.Handler {
+	return s.auth.Handler(required, next)
+}
+
+// Stop signals the loops started by Checksum, Compare and Serve to exit and ends the checksum subscriptions, without
+// waiting for them; from then on the service reports that it is shutting down, and refuses new loops and on-demand
+// checksum jobs
+// the API is still served with the databases open, so that a server can be shut down gracefully before Close
+// the peer connections are closed, failing a comparison call in flight to a peer that stopped responding
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		s.broker.Close()
+		if s.api.jobs != nil {
+			s.api.jobs.stop()
+		}
+		if s.comparer != nil {
+			s.comparerErr = s.comparer.Close()
+		}
+	})
+}
+
+// stopped returns whether the service has been stopped
+func (s *Service) stopped() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// withQuit returns a copy of the context that is also cancelled when the service is stopped
+func (s *Service) withQuit(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 // Close implements io.Closer
-// it shuts down any active Checksum or Serve loops
+// it stops the service and waits for its loops and on-demand checksum jobs to exit, so that a chunk in flight is
+// published, before closing the databases and the connections to the Lotus node and peers
+// closing the service again returns the same error
 func (s *Service) Close() error {
-	if err := s.cs.Close(); err != nil {
-		return err
+	s.closeOnce.Do(func() {
+		s.Stop()
+		s.loops.Wait()
+		if s.api.jobs != nil {
+			s.api.jobs.drain()
+		}
+		s.closeErr = s.close()
+	})
+	return s.closeErr
+}
+
+func (s *Service) close() error {
+	var errs []error
+	if s.cs != nil {
+		errs = append(errs, s.cs.Close())
+	}
+	// the comparer was closed by Stop
+	errs = append(errs, s.comparerErr)
+	if s.closeNode != nil {
+		s.closeNode()
 	}
-	return s.r.Close()
+	errs = append(errs, s.r.Close())
+	return errors.Join(errs...)
 }
@@ -1 +1,399 @@
 package attestation
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation/mocks"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+func TestChecksumUntilExhausted(t *testing.T) {
+	// chunks of 5 epochs, 14 to 16 are missing and 30 to 31 only make up an incomplete chunk
+	rows := append(newTestRowsForEpochs(0, 13), newTestRowsForEpochs(17, 31)...)
+	cs, err := NewChecksummer(newTestMsgIndex(t, rows), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	repo := mocks.NewRepo(4, nil)
+	service, err := NewService(cs, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := service.ChecksumUntilExhausted(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Start != 0 || report.Stop != 31 || report.Written != 4 || report.Skipped != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if fmt.Sprint(report.Gaps) != "[[14 16]]" {
+		t.Fatalf("expected gap [14 16], got %v", report.Gaps)
+	}
+	checksums, err := repo.ListChecksums(types.ListChecksumsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranges []string
+	for _, checksum := range checksums {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", checksum.Start, checksum.Stop))
+	}
+	if fmt.Sprint(ranges) != "[0-4 5-9 20-24 25-29]" {
+		t.Fatalf("unexpected published ranges %v", ranges)
+	}
+
+	// a second run has nothing left to do until the source is extended
+	report, err = service.ChecksumUntilExhausted(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Start != 30 || report.Written != 0 || report.Skipped != 0 {
+		t.Fatalf("unexpected report for second run %+v", report)
+	}
+}
+
+func TestChecksumBackfillsGaps(t *testing.T) {
+	// a chunk missing between published chunks, and the first chunk, e.g. deleted by an operator
+	for _, missing := range [][2]uint{{5, 9}, {0, 4}} {
+		t.Run(fmt.Sprintf("%d-%d", missing[0], missing[1]), func(t *testing.T) {
+			testChecksumBackfillsGap(t, missing)
+		})
+	}
+}
+
+func testChecksumBackfillsGap(t *testing.T, missing [2]uint) {
+	rows := newTestRowsForEpochs(0, 19)
+	cs, err := NewChecksummer(newTestMsgIndex(t, rows), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	repo := mocks.NewRepo(4, nil)
+	for _, rng := range [][2]uint{{0, 4}, {5, 9}, {10, 14}} {
+		if rng == missing {
+			continue
+		}
+		checksum, err := cs.Checksum(rng[0], rng[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.PublishChecksum(checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service, err := NewService(cs, repo, nil, 15, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.enableBackfill(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := new(sync.WaitGroup)
+	if err, _ := service.Checksum(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	var status types.BackfillStatus
+	for {
+		if err := service.api.GetBackfillStatus(types.BackfillStatusRequest{}, &status); err != nil {
+			t.Fatal(err)
+		}
+		next, err := repo.FindNextChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Backfilled == 1 && next == 20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the backfill, status %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+	exists, err := repo.GetChecksum(missing[0], missing[1], SHA3_256.String())
+	if err != nil || exists == "" {
+		t.Fatalf("expected range %d to %d to be backfilled (err: %v)", missing[0], missing[1], err)
+	}
+	if len(status.Pending) != 0 {
+		t.Fatalf("expected no pending backfill, got %v", status.Pending)
+	}
+}
+
+func TestChecksumFollowsChainHead(t *testing.T) {
+	dir := newTestMsgIndex(t, newTestRowsForEpochs(0, 29))
+	cs, err := NewChecksummer(dir, SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	repo := mocks.NewRepo(4, nil)
+	service, err := NewService(cs, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := mocks.NewGateway(10)
+	service.follow(node, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+	defer cancel()
+	if err, _ := service.Checksum(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	nextChecksum := func(expected uint) func() bool {
+		return func() bool {
+			next, err := repo.FindNextChecksum()
+			return err == nil && next == expected
+		}
+	}
+	// only the chunks at least 5 epochs below the head are final
+	waitFor(t, "the first final chunk", nextChecksum(5))
+	time.Sleep(50 * time.Millisecond)
+	if next, _ := repo.FindNextChecksum(); next != 5 {
+		t.Fatalf("expected only the first chunk to be checksummed at head 10, next chunk starts at %d", next)
+	}
+	node.Apply(20)
+	waitFor(t, "the chunks final at head 20", nextChecksum(15))
+
+	// a reorg that changes the messages of a published chunk has it checksummed again
+	db, err := sql.Open("sqlite3", filepath.Join(dir, messagesDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("UPDATE messages SET tipset_cid = 'ts-reorged' WHERE epoch = 7"); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := cs.Checksum(5, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged, err := repo.GetChecksum(10, 14, SHA3_256.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Reorg(7)
+	waitFor(t, "the reorged chunk to be checksummed again", func() bool {
+		hash, err := repo.GetChecksum(5, 9, SHA3_256.String())
+		return err == nil && hash == expected.Hash
+	})
+	if hash, _ := repo.GetChecksum(10, 14, SHA3_256.String()); hash != unchanged {
+		t.Fatal("expected the checksum of the chunk unchanged by the reorg to remain")
+	}
+}
+
+// gatedChecksummer blocks checksumming until released, and records whether it was closed
+type gatedChecksummer struct {
+	*mocks.CheckSummer
+	checksumming chan struct{}
+	release      chan struct{}
+	closed       atomic.Bool
+}
+
+func (g *gatedChecksummer) Checksum(start, stop uint) (types.Checksum, error) {
+	select {
+	case g.checksumming <- struct{}{}:
+	default:
+	}
+	<-g.release
+	return g.CheckSummer.Checksum(start, stop)
+}
+
+func (g *gatedChecksummer) Close() error {
+	g.closed.Store(true)
+	return nil
+}
+
+func TestCloseWaitsForChunkInFlight(t *testing.T) {
+	mockCS := new(mocks.CheckSummer)
+	mockCS.SetChecksum("hash")
+	mockCS.SetHighestEpoch(4)
+	cs := &gatedChecksummer{CheckSummer: mockCS, checksumming: make(chan struct{}, 1), release: make(chan struct{})}
+	repo := mocks.NewRepo(4, nil)
+	service, err := NewService(cs, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.follow(mocks.NewGateway(100), 5)
+
+	// the context is never cancelled, closing the service has to stop its loops and the chain follower
+	wg := new(sync.WaitGroup)
+	if err, _ := service.Checksum(context.Background(), wg); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Serve(context.Background(), wg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cs.checksumming:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the first chunk to be checksummed")
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- service.Close()
+	}()
+	waitFor(t, "the service to stop", service.stopped)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-closed:
+		t.Fatal("expected Close to wait for the chunk in flight")
+	default:
+	}
+	if cs.closed.Load() || repo.Closed() {
+		t.Fatal("expected the databases to stay open while the chunk is in flight")
+	}
+	if err := service.ready(); err == nil {
+		t.Fatal("expected the service not to be ready while shutting down")
+	}
+	if _, err := service.api.jobs.schedule(0, 4); err != errServiceStopped {
+		t.Fatalf("expected on-demand checksums to be refused while shutting down, got %v", err)
+	}
+
+	close(cs.release)
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the service to close")
+	}
+	wg.Wait()
+	if !cs.closed.Load() || !repo.Closed() {
+		t.Fatal("expected the databases to be closed")
+	}
+	if status := service.Status(); status.LoopState != types.ChecksumLoopStopped || status.LastPublishedStop != 4 {
+		t.Fatalf("expected the chunk in flight to be published before stopping, got status %+v", status)
+	}
+}
+
+// failingCloseChecksummer fails to close
+type failingCloseChecksummer struct {
+	*mocks.CheckSummer
+}
+
+func (f failingCloseChecksummer) Close() error {
+	return errors.New("checksummer close failed")
+}
+
+func TestCloseClosesEverythingOnError(t *testing.T) {
+	repo := mocks.NewRepo(4, nil)
+	service, err := NewService(failingCloseChecksummer{new(mocks.CheckSummer)}, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Close(); err == nil || !strings.Contains(err.Error(), "checksummer close failed") {
+		t.Fatalf("expected the checksummer close error, got %v", err)
+	}
+	if !repo.Closed() {
+		t.Fatal("expected the checksum repository to be closed after the checksummer failed to close")
+	}
+}
+
+func TestCloseWithoutChecksummer(t *testing.T) {
+	repo := mocks.NewRepo(4, nil)
+	service, err := NewService(nil, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := new(sync.WaitGroup)
+	if err := service.Serve(context.Background(), wg); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if !repo.Closed() {
+		t.Fatal("expected the checksum repository to be closed")
+	}
+	if err := service.Serve(context.Background(), wg); err != errServiceStopped {
+		t.Fatalf("expected a closed service to refuse to serve, got %v", err)
+	}
+	// closing again is a no-op
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestHungPeer listens for a peer connection that accepts the net/rpc CONNECT handshake and then never responds,
+// the received channel is closed once the first call has been read
+func newTestHungPeer(t *testing.T) (addr string, received <-chan struct{}) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if _, err := http.ReadRequest(r); err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, "HTTP/1.0 "+rpcConnected+"\n\n"); err != nil {
+			return
+		}
+		if _, err := r.ReadByte(); err != nil {
+			return
+		}
+		close(ch)
+		// hold the connection open without responding until the client closes it
+		io.Copy(io.Discard, r)
+	}()
+	return l.Addr().String(), ch
+}
+
+func TestCloseInterruptsHungPeer(t *testing.T) {
+	repo := newTestMockRepo(t, 4, newTestChecksums(1, 4))
+	service, err := NewService(nil, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, received := newTestHungPeer(t)
+	service.comparer = NewComparer(repo, []types.Peer{NewPeer(addr)}, SHA3_256)
+	wg := new(sync.WaitGroup)
+	if err := service.Compare(context.Background(), wg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the peer to receive the call")
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- service.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected Close to return while a peer call is in flight")
+	}
+	wg.Wait()
+}
@@ -0,0 +1,258 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+	crypto "github.com/filecoin-project/go-crypto"
+	"github.com/supranational/blst/bindings/go"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// Supported signing key types, named as in a Lotus wallet KeyInfo
+const (
+	KeyTypeSecp256k1 = "secp256k1"
+	KeyTypeBLS       = "bls"
+	KeyTypeEd25519   = "ed25519"
+
+	ed25519SignerPrefix = "ed25519:"
+)
+
+var attestationDomain = []byte("lotus-utils/attestation")
+
+// blsDST is the domain separation tag of Filecoin BLS signatures, as used by Lotus
+var blsDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+
+const (
+	blsPrivateKeyBytes = 32
+	blsSignatureBytes  = 96
+)
+
+// keyInfo is the Lotus wallet KeyInfo format, as written by `lotus wallet export`
+type keyInfo struct {
+	Type       string
+	PrivateKey []byte
+}
+
+var (
+	_ types.Signer = (*ed25519Signer)(nil)
+	_ types.Signer = (*secp256k1Signer)(nil)
+	_ types.Signer = (*blsSigner)(nil)
+)
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// ID returns the "ed25519:" prefixed hex encoded public key
+func (s *ed25519Signer) ID() string {
+	return ed25519SignerPrefix + hex.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Sign implements types.Signer
+func (s *ed25519Signer) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, msg), nil
+}
+
+type secp256k1Signer struct {
+	key  []byte
+	addr address.Address
+}
+
+// ID returns the Filecoin f1/t1 address of the key
+func (s *secp256k1Signer) ID() string {
+	return s.addr.String()
+}
+
+// Sign signs the blake2b-256 digest of the message, as Lotus does for secp256k1 keys
+func (s *secp256k1Signer) Sign(msg []byte) ([]byte, error) {
+	digest := blake2b.Sum256(msg)
+	return crypto.Sign(s.key, digest[:])
+}
+
+type blsSigner struct {
+	key  *blst.SecretKey
+	addr address.Address
+}
+
+// ID returns the Filecoin f3/t3 address of the key
+func (s *blsSigner) ID() string {
+	return s.addr.String()
+}
+
+// Sign signs the message itself, as Lotus does for BLS keys
+func (s *blsSigner) Sign(msg []byte) ([]byte, error) {
+	return new(blst.P2Affine).Sign(s.key, msg, blsDST).Compress(), nil
+}
+
+// newBLSSigner returns the signer of a Filecoin BLS private key, which Lotus serializes little-endian
+func newBLSSigner(priv []byte) (*blsSigner, error) {
+	if len(priv) != blsPrivateKeyBytes {
+		return nil, fmt.Errorf("invalid bls private key length %d", len(priv))
+	}
+	key := new(blst.SecretKey).FromLEndian(priv)
+	if key == nil {
+		return nil, fmt.Errorf("invalid bls private key")
+	}
+	addr, err := address.NewBLSAddress(new(blst.P1Affine).From(key).Compress())
+	if err != nil {
+		return nil, err
+	}
+	return &blsSigner{key: key, addr: addr}, nil
+}
+
+// LoadSigner loads a signing key from a Lotus wallet KeyInfo file, either as JSON or hex encoded JSON as written by
+// `lotus wallet export`
+// secp256k1, bls and ed25519 keys are supported, an ed25519 PrivateKey may be either the 32 byte seed or the 64 byte key
+func LoadSigner(keyPath string) (types.Signer, error) {
+	raw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signing key file: %w", err)
+	}
+	raw = bytes.TrimSpace(raw)
+	if decoded, err := hex.DecodeString(string(raw)); err == nil {
+		raw = decoded
+	}
+	var ki keyInfo
+	if err := json.Unmarshal(raw, &ki); err != nil {
+		return nil, fmt.Errorf("unable to decode signing key file: %w", err)
+	}
+	return newSigner(ki)
+}
+
+func newSigner(ki keyInfo) (types.Signer, error) {
+	switch strings.ToLower(ki.Type) {
+	case KeyTypeEd25519:
+		switch len(ki.PrivateKey) {
+		case ed25519.SeedSize:
+			return &ed25519Signer{key: ed25519.NewKeyFromSeed(ki.PrivateKey)}, nil
+		case ed25519.PrivateKeySize:
+			return &ed25519Signer{key: ed25519.PrivateKey(ki.PrivateKey)}, nil
+		default:
+			return nil, fmt.Errorf("invalid ed25519 private key length %d", len(ki.PrivateKey))
+		}
+	case KeyTypeSecp256k1:
+		addr, err := address.NewSecp256k1Address(crypto.PublicKey(ki.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		return &secp256k1Signer{key: ki.PrivateKey, addr: addr}, nil
+	case KeyTypeBLS:
+		return newBLSSigner(ki.PrivateKey)
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %s", ki.Type)
+	}
+}
+
+// attestationMessage returns the canonical bytes that are signed for a checksum
+//
+//	msg = "lotus-utils/attestation" 0x00 uint8(1) uint64be(start) uint64be(stop) uint64be(format_version)
+//	      uvarint(len(algo)) algo uvarint(len(hash)) hash
+func attestationMessage(checksum types.Checksum) []byte {
+	var buf bytes.Buffer
+	var num [binary.MaxVarintLen64]byte
+	buf.Write(attestationDomain)
+	buf.Write([]byte{0, 1})
+	for _, n := range []uint{checksum.Start, checksum.Stop, checksum.FormatVersion} {
+		binary.BigEndian.PutUint64(num[:8], uint64(n))
+		buf.Write(num[:8])
+	}
+	for _, s := range []string{checksum.Algorithm, checksum.Hash} {
+		buf.Write(num[:binary.PutUvarint(num[:], uint64(len(s)))])
+		buf.WriteString(s)
+	}
+	return buf.Bytes()
+}
+
+// SignChecksum returns the checksum with the signer's ID and signature over its (start, stop, hash, algo) tuple attached
+func SignChecksum(signer types.Signer, checksum types.Checksum) (types.Checksum, error) {
+	sig, err := signer.Sign(attestationMessage(checksum))
+	if err != nil {
+		return types.Checksum{}, fmt.Errorf("unable to sign checksum for range %d to %d: %w", checksum.Start, checksum.Stop, err)
+	}
+	checksum.Signer = signer.ID()
+	checksum.Signature = hex.EncodeToString(sig)
+	return checksum, nil
+}
+
+// VerifyAttestation checks that the checksum was signed by one of the trusted signers
+// trusted signers are identified by Filecoin f1 addresses for secp256k1 keys, f3 addresses for bls keys and
+// "ed25519:<hex public key>" for ed25519 keys
+func VerifyAttestation(checksum types.Checksum, trusted []string) error {
+	if checksum.Signer == "" || checksum.Signature == "" {
+		return fmt.Errorf("checksum for range %d to %d is not signed", checksum.Start, checksum.Stop)
+	}
+	if !isTrustedSigner(checksum.Signer, trusted) {
+		return fmt.Errorf("checksum for range %d to %d is signed by untrusted signer %s", checksum.Start, checksum.Stop,
+			checksum.Signer)
+	}
+	sig, err := hex.DecodeString(checksum.Signature)
+	if err != nil {
+		return fmt.Errorf("signature is not hex encoded: %w", err)
+	}
+	msg := attestationMessage(checksum)
+	if strings.HasPrefix(checksum.Signer, ed25519SignerPrefix) {
+		pub, err := hex.DecodeString(strings.TrimPrefix(checksum.Signer, ed25519SignerPrefix))
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ed25519 signer %s", checksum.Signer)
+		}
+		if !ed25519.Verify(pub, msg, sig) {
+			return fmt.Errorf("invalid signature from %s", checksum.Signer)
+		}
+		return nil
+	}
+	addr, err := address.NewFromString(checksum.Signer)
+	if err != nil {
+		return fmt.Errorf("invalid signer %s: %w", checksum.Signer, err)
+	}
+	switch addr.Protocol() {
+	case address.SECP256K1:
+	case address.BLS:
+		if len(sig) != blsSignatureBytes || !new(blst.P2Affine).VerifyCompressed(sig, true, addr.Payload(), true, msg, blsDST) {
+			return fmt.Errorf("invalid signature from %s", checksum.Signer)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signer address protocol for %s", checksum.Signer)
+	}
+	digest := blake2b.Sum256(msg)
+	pub, err := crypto.EcRecover(digest[:], sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature from %s: %w", checksum.Signer, err)
+	}
+	recovered, err := address.NewSecp256k1Address(pub)
+	if err != nil {
+		return err
+	}
+	if recovered != addr {
+		return fmt.Errorf("invalid signature from %s", checksum.Signer)
+	}
+	return nil
+}
+
+// isTrustedSigner compares signer IDs, treating f and t network prefixes of the same Filecoin address as equal
+func isTrustedSigner(signer string, trusted []string) bool {
+	for _, t := range trusted {
+		if strings.EqualFold(t, signer) {
+			return true
+		}
+		if strings.HasPrefix(t, ed25519SignerPrefix) || strings.HasPrefix(signer, ed25519SignerPrefix) {
+			continue
+		}
+		a, errA := address.NewFromString(t)
+		b, errB := address.NewFromString(signer)
+		if errA == nil && errB == nil && a == b {
+			return true
+		}
+	}
+	return false
+}
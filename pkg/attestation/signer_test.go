@@ -0,0 +1,139 @@
+package attestation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	crypto "github.com/filecoin-project/go-crypto"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+var testChecksum = types.Checksum{
+	Start:         0,
+	Stop:          2880,
+	Hash:          "c39e54bf99e250893338528eab012ed26a906e6f6dd12ac941c68f9c014b9ea5",
+	Algorithm:     SHA3_256.String(),
+	FormatVersion: uint(ChecksumFormatVersion),
+}
+
+// writeTestKey writes the key info to a file in the hex encoded format written by `lotus wallet export`
+func writeTestKey(t *testing.T, ki keyInfo) string {
+	t.Helper()
+	raw, err := json.Marshal(ki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(raw)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSignAndVerify(t *testing.T) {
+	secpKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ki := range []keyInfo{
+		{Type: KeyTypeEd25519, PrivateKey: make([]byte, 32)},
+		{Type: KeyTypeSecp256k1, PrivateKey: secpKey},
+		{Type: KeyTypeBLS, PrivateKey: newTestBLSKey(t)},
+	} {
+		t.Run(ki.Type, func(t *testing.T) {
+			signer, err := LoadSigner(writeTestKey(t, ki))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := SignChecksum(signer, testChecksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyAttestation(signed, []string{signer.ID()}); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyAttestation(signed, nil); err == nil {
+				t.Fatal("expected verification to fail without trusted signers")
+			}
+			tampered := signed
+			tampered.Hash = strings.Repeat("0", len(signed.Hash))
+			if err := VerifyAttestation(tampered, []string{signer.ID()}); err == nil {
+				t.Fatal("expected verification of a tampered checksum to fail")
+			}
+			if err := VerifyAttestation(testChecksum, []string{signer.ID()}); err == nil {
+				t.Fatal("expected verification of an unsigned checksum to fail")
+			}
+		})
+	}
+}
+
+func TestVerifyAcrossNetworkPrefixes(t *testing.T) {
+	secpKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newSigner(keyInfo{Type: KeyTypeSecp256k1, PrivateKey: secpKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignChecksum(signer, testChecksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := signer.ID()
+	var trusted string
+	if id[0] == address.MainnetPrefix[0] {
+		trusted = address.TestnetPrefix + id[1:]
+	} else {
+		trusted = address.MainnetPrefix + id[1:]
+	}
+	if err := VerifyAttestation(signed, []string{trusted}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestBLSKey returns a random bls private key, serialized little-endian as Lotus does
+func newTestBLSKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, blsPrivateKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	// keep the scalar below the order of the curve
+	key[blsPrivateKeyBytes-1] &= 0x3f
+	return key
+}
+
+func TestBLSKeyEncoding(t *testing.T) {
+	// the public key of the scalar 1 is the generator of G1
+	one := make([]byte, blsPrivateKeyBytes)
+	one[0] = 1
+	signer, err := newSigner(keyInfo{Type: KeyTypeBLS, PrivateKey: one})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := address.NewFromString(signer.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := "97f1d3a73197d7942695638c4fa9ac0fc3688c4f9774b905a14e3a3f171bac586c55e83ff97a1aeffb3af00adb22c6bb"
+	if addr.Protocol() != address.BLS || hex.EncodeToString(addr.Payload()) != generator {
+		t.Fatalf("expected the bls address of the G1 generator, got %s", signer.ID())
+	}
+}
+
+func TestUnsupportedKeys(t *testing.T) {
+	if _, err := newSigner(keyInfo{Type: KeyTypeBLS, PrivateKey: make([]byte, 16)}); err == nil {
+		t.Fatal("expected an error for a truncated bls key")
+	}
+	if _, err := newSigner(keyInfo{Type: KeyTypeEd25519, PrivateKey: make([]byte, 16)}); err == nil {
+		t.Fatal("expected an error for a truncated ed25519 key")
+	}
+}
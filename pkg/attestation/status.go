@@ -0,0 +1,246 @@
+package attestation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+const (
+	// HealthzPath is the path of the liveness endpoint served by HealthHandler
+	HealthzPath = "/healthz"
+	// ReadyzPath is the path of the readiness endpoint served by HealthHandler
+	ReadyzPath = "/readyz"
+)
+
+// HealthJSON is the response of the liveness and readiness endpoints
+type HealthJSON struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// loopStatus tracks the state of the checksumming loop for the Status API and the health endpoints
+type loopStatus struct {
+	mu            sync.Mutex
+	state         string
+	lastErr       error
+	lastErrAt     time.Time
+	hasPublished  bool
+	lastPublished [2]uint
+	// next is the start epoch of the next chunk the loop will checksum
+	next uint
+	// retries counts the transient errors retried, failures the errors the retry policy gave up on
+	retries  uint
+	failures uint
+	// resumed is closed to resume the loop while it is paused
+	resumed chan struct{}
+}
+
+func newLoopStatus() *loopStatus {
+	return &loopStatus{state: types.ChecksumLoopDisabled}
+}
+
+// start records the loop starting from the chunk at next, the chunk before it is the last one that was published
+func (l *loopStatus) start(next, chunkSize uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = types.ChecksumLoopRunning
+	prom.SetChecksumLoopState(l.state)
+	l.next = next
+	if next >= chunkSize+1 {
+		l.hasPublished = true
+		l.lastPublished = [2]uint{next - chunkSize - 1, next - 1}
+	}
+}
+
+func (l *loopStatus) setState(state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+	prom.SetChecksumLoopState(state)
+}
+
+// published records the loop publishing the chunk from start to stop
+func (l *loopStatus) published(start, stop uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hasPublished = true
+	l.lastPublished = [2]uint{start, stop}
+	l.next = stop + 1
+}
+
+// retry records the loop retrying after a transient error, for the given time in a row
+func (l *loopStatus) retry(err error, retries uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = types.ChecksumLoopRetrying
+	l.retries++
+	l.lastErr = err
+	l.lastErrAt = time.Now()
+	prom.SetChecksumLoopState(l.state)
+	prom.ChecksumLoopRetried()
+}
+
+// giveUp records the retry policy giving up on an error, leaving the loop in the given state
+func (l *loopStatus) giveUp(err error, state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.giveUpLocked(err, state)
+}
+
+func (l *loopStatus) giveUpLocked(err error, state string) {
+	l.state = state
+	l.failures++
+	l.lastErr = err
+	l.lastErrAt = time.Now()
+	prom.SetChecksumLoopState(state)
+	prom.ChecksumLoopFailed()
+}
+
+// pause records the loop pausing after an error, and returns the channel closed when it is resumed
+func (l *loopStatus) pause(err error) <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.giveUpLocked(err, types.ChecksumLoopPaused)
+	l.resumed = make(chan struct{})
+	return l.resumed
+}
+
+// resume resumes the loop if it is paused, returning whether it was
+func (l *loopStatus) resume() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state != types.ChecksumLoopPaused {
+		return false
+	}
+	close(l.resumed)
+	l.resumed = nil
+	l.state = types.ChecksumLoopRunning
+	prom.SetChecksumLoopState(l.state)
+	return true
+}
+
+// fail records the error that stopped the loop
+func (l *loopStatus) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.giveUpLocked(err, types.ChecksumLoopFailed)
+}
+
+// stop records the loop exiting, unless it failed
+func (l *loopStatus) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state != types.ChecksumLoopFailed {
+		l.state = types.ChecksumLoopStopped
+		prom.SetChecksumLoopState(l.state)
+	}
+}
+
+// status returns the recorded state of the loop, and the start epoch of the next chunk it will checksum
+func (l *loopStatus) status() (types.Status, uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := types.Status{
+		LoopState:          l.state,
+		Published:          l.hasPublished,
+		LastPublishedStart: l.lastPublished[0],
+		LastPublishedStop:  l.lastPublished[1],
+		Retries:            l.retries,
+		Failures:           l.failures,
+	}
+	if l.lastErr != nil {
+		status.LastError = l.lastErr.Error()
+		status.LastErrorAt = l.lastErrAt.Unix()
+	}
+	return status, l.next
+}
+
+// fail records the error that stopped the checksumming loop and sends it on errChan
+func (s *Service) fail(errChan chan<- error, err error) {
+	s.loop.fail(err)
+	errChan <- err
+}
+
+// Resume resumes the checksumming loop after the retry policy paused it
+func (s *Service) Resume() error {
+	if !s.loop.resume() {
+		status, _ := s.loop.status()
+		return fmt.Errorf("checksumming loop is %s, not %s", status.LoopState, types.ChecksumLoopPaused)
+	}
+	return nil
+}
+
+// Status returns the state of the service and its checksumming loop
+// the lag is only reported while the service has a checksumming loop, and msgindex.db is indexed past its next chunk
+// if msgindex.db cannot be read, e.g. because it is what failed the loop, the highest epoch and lag are left out
+func (s *Service) Status() types.Status {
+	status, next := s.loop.status()
+	status.StartedAt = s.startedAt.Unix()
+	status.Uptime = int64(time.Since(s.startedAt).Seconds())
+	if s.cs == nil {
+		return status
+	}
+	highest, ok, err := s.cs.HighestEpoch()
+	if err != nil {
+		logrus.Warnf("unable to read the highest epoch in msgindex.db for the status: %s", err.Error())
+		return status
+	}
+	if ok {
+		status.HighestEpoch = highest
+		if status.LoopState != types.ChecksumLoopDisabled && highest >= next {
+			status.Lag = highest - next + 1
+		}
+	}
+	return status
+}
+
+// HealthHandler returns the handler for the liveness and readiness endpoints, served at HealthzPath and ReadyzPath
+// the service is live until its checksumming loop fails, so an orchestrator restarts it instead of leaving it stalled,
+// and ready while it serves the API and is not shutting down
+func (s *Service) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.URL.Path {
+		case HealthzPath:
+			err = s.live()
+		case ReadyzPath:
+			err = s.ready()
+		default:
+			writeJSON(w, http.StatusNotFound, errorJSON{Error: "no such endpoint " + r.URL.Path})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, HealthJSON{Status: "unavailable", Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, HealthJSON{Status: "ok"})
+	})
+}
+
+// live returns an error if the checksumming loop has failed
+func (s *Service) live() error {
+	status, _ := s.loop.status()
+	if status.LoopState == types.ChecksumLoopFailed {
+		return fmt.Errorf("checksumming loop failed: %s", status.LastError)
+	}
+	return nil
+}
+
+// ready returns an error if the service is not serving the API, or is shutting down
+func (s *Service) ready() error {
+	if s.stopped() {
+		return errors.New("shutting down")
+	}
+	if !s.serving.Load() {
+		return errors.New("not serving the API")
+	}
+	return nil
+}
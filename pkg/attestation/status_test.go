@@ -0,0 +1,136 @@
+package attestation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/vulcanize/lotus-utils/pkg/attestation/mocks"
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+// getHealth returns the status code of the health endpoint at the path
+func getHealth(t *testing.T, url, path string) int {
+	t.Helper()
+	res, err := http.Get(url + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var health HealthJSON
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if (res.StatusCode == http.StatusOK) != (health.Error == "") {
+		t.Fatalf("unexpected health %+v with status %d", health, res.StatusCode)
+	}
+	return res.StatusCode
+}
+
+// getStatus returns the service status served by the API
+func getStatus(t *testing.T, service *Service) types.Status {
+	t.Helper()
+	var status types.Status
+	if err := service.api.GetStatus(types.StatusRequest{}, &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestStatusAndHealth(t *testing.T) {
+	cs, err := NewChecksummer(newTestMsgIndex(t, newTestRowsForEpochs(0, 29)), SHA3_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	repo := mocks.NewRepo(4, nil)
+	service, err := NewService(cs, repo, nil, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.follow(mocks.NewGateway(10), 5)
+	ts := httptest.NewServer(service.HealthHandler())
+	defer ts.Close()
+
+	if status := getStatus(t, service); status.LoopState != types.ChecksumLoopDisabled || status.HighestEpoch != 29 || status.Lag != 0 {
+		t.Fatalf("unexpected status before checksumming %+v", status)
+	}
+	if code := getHealth(t, ts.URL, HealthzPath); code != http.StatusOK {
+		t.Fatalf("expected the service to be live, got status %d", code)
+	}
+	if code := getHealth(t, ts.URL, ReadyzPath); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the service not to be ready before serving, got status %d", code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := new(sync.WaitGroup)
+	if err := service.Serve(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	if err, _ := service.Checksum(ctx, wg); err != nil {
+		t.Fatal(err)
+	}
+	// only the first chunk is final at head 10, after which the loop waits for the head to move
+	waitFor(t, "the loop to wait for the next final chunk", func() bool {
+		return getStatus(t, service).LoopState == types.ChecksumLoopWaiting
+	})
+	status := getStatus(t, service)
+	if !status.Published || status.LastPublishedStart != 0 || status.LastPublishedStop != 4 || status.Lag != 25 {
+		t.Fatalf("unexpected status while waiting %+v", status)
+	}
+	if code := getHealth(t, ts.URL, ReadyzPath); code != http.StatusOK {
+		t.Fatalf("expected the service to be ready while serving, got status %d", code)
+	}
+
+	cancel()
+	wg.Wait()
+	if status := getStatus(t, service); status.LoopState != types.ChecksumLoopStopped || status.LastError != "" {
+		t.Fatalf("unexpected status after stopping %+v", status)
+	}
+	if code := getHealth(t, ts.URL, ReadyzPath); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the service not to be ready after serving, got status %d", code)
+	}
+}
+
+func TestStatusReportsLoopFailure(t *testing.T) {
+	cs := new(mocks.CheckSummer)
+	cs.SetErr(errors.New("database is locked"))
+	service, err := NewService(cs, mocks.NewRepo(4, nil), nil, 10, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.retry.OnFailure = FailureShutdown
+	ts := httptest.NewServer(service.HealthHandler())
+	defer ts.Close()
+
+	wg := new(sync.WaitGroup)
+	err, errChan := service.Checksum(context.Background(), wg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nobody needs to receive the error for the loop to exit
+	wg.Wait()
+	if err := <-errChan; err == nil || err.Error() != "database is locked" {
+		t.Fatalf("expected the loop error, got %v", err)
+	}
+	// the highest epoch is left out, as msgindex.db cannot be read
+	status := service.Status()
+	if status.HighestEpoch != 0 || status.Lag != 0 {
+		t.Fatalf("unexpected highest epoch in status %+v", status)
+	}
+	if status.LoopState != types.ChecksumLoopFailed || status.LastError != "database is locked" || status.LastErrorAt == 0 {
+		t.Fatalf("unexpected status after failing %+v", status)
+	}
+	// the chunk before the start epoch is the last one published
+	if !status.Published || status.LastPublishedStart != 5 || status.LastPublishedStop != 9 {
+		t.Fatalf("unexpected last published range %+v", status)
+	}
+	if code := getHealth(t, ts.URL, HealthzPath); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the service not to be live after the loop failed, got status %d", code)
+	}
+}
@@ -0,0 +1,173 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vulcanize/lotus-utils/pkg/types"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string
+}
+
+// newTestCA generates a self-signed CA and writes its certificate to a PEM file in the directory
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+".pem")
+	writeTestPEM(t, path, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+// issue generates a certificate signed by the CA for 127.0.0.1, and writes it and its key to PEM files in the directory
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writeTestPEM(t, certPath, "CERTIFICATE", der)
+	writeTestPEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath
+}
+
+func writeTestPEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+	otherClientCert, otherClientKey := otherCA.issue(t, dir, "other-client", x509.ExtKeyUsageClientAuth)
+
+	checksums := newTestChecksums(2, 4)
+	newTLSServer := func(clientCAPath string) string {
+		t.Helper()
+		server := rpc.NewServer()
+		if err := server.RegisterName(rpcServiceName, NewAPI(newTestMockRepo(t, 4, checksums), nil)); err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig, err := NewServerTLSConfig(serverCert, serverKey, clientCAPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewUnstartedServer(server)
+		ts.TLS = tlsConfig
+		ts.StartTLS()
+		t.Cleanup(ts.Close)
+		return ts.Listener.Addr().String()
+	}
+	getChecksum := func(addr string, config PeerConfig) error {
+		t.Helper()
+		opts, err := config.Options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer := NewPeerWithOptions(addr, opts)
+		defer peer.Close()
+		hash, err := peer.GetChecksum(types.GetChecksumRequest{Start: 0, Stop: 4})
+		if err == nil && hash != checksums[0].Hash {
+			t.Fatalf("expected checksum %s, got %s", checksums[0].Hash, hash)
+		}
+		return err
+	}
+
+	tlsAddr := newTLSServer("")
+	if err := getChecksum(tlsAddr, PeerConfig{TLS: true, TLSCAPath: ca.path}); err != nil {
+		t.Fatalf("expected the peer to verify the server against the CA: %v", err)
+	}
+	if err := getChecksum(tlsAddr, PeerConfig{TLS: true, TLSCAPath: otherCA.path}); err == nil {
+		t.Fatal("expected the server certificate to be rejected when verified against another CA")
+	}
+	if err := getChecksum(tlsAddr, PeerConfig{}); err == nil {
+		t.Fatal("expected a plaintext peer to fail against a TLS server")
+	}
+
+	mutualAddr := newTLSServer(ca.path)
+	if err := getChecksum(mutualAddr, PeerConfig{TLS: true, TLSCAPath: ca.path, TLSCertPath: clientCert, TLSKeyPath: clientKey}); err != nil {
+		t.Fatalf("expected the client certificate to be accepted: %v", err)
+	}
+	if err := getChecksum(mutualAddr, PeerConfig{TLS: true, TLSCAPath: ca.path}); err == nil {
+		t.Fatal("expected a peer without a client certificate to be rejected")
+	}
+	if err := getChecksum(mutualAddr, PeerConfig{TLS: true, TLSCAPath: ca.path, TLSCertPath: otherClientCert, TLSKeyPath: otherClientKey}); err == nil {
+		t.Fatal("expected a client certificate signed by another CA to be rejected")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	cert, key := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	if _, err := NewServerTLSConfig(cert, "", ""); err == nil {
+		t.Fatal("expected an error for a certificate without a key")
+	}
+	if _, err := NewServerTLSConfig(cert, key, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Fatal("expected an error for a missing client CA bundle")
+	}
+	if _, err := NewServerTLSConfig(cert, key, key); err == nil {
+		t.Fatal("expected an error for a client CA bundle without certificates")
+	}
+	if _, err := NewClientTLSConfig("", cert, ""); err == nil {
+		t.Fatal("expected an error for a client certificate without a key")
+	}
+}
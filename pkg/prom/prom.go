@@ -0,0 +1,273 @@
+package prom
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	namespace = "lotus_utils"
+
+	subsystemAttestation = "attestation"
+	subsystemRepair      = "repair"
+)
+
+// databases whose gaps are counted
+const (
+	MsgIndexDB  = "msgindex"
+	ChecksumsDB = "checksums"
+)
+
+// APIs whose requests are counted
+const (
+	RPCAPI     = "rpc"
+	JSONRPCAPI = "jsonrpc"
+	RESTAPI    = "rest"
+)
+
+var (
+	metrics  bool
+	initOnce sync.Once
+
+	chunksChecksummed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "chunks_checksummed_total",
+		Help:      "Number of chunks of msgindex.db checksummed",
+	})
+	checksumDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "checksum_duration_seconds",
+		Help:      "Time taken to checksum a chunk of msgindex.db",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	lastChecksummedEpoch = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "last_checksummed_epoch",
+		Help:      "Stop epoch of the last chunk checksummed and published by the checksumming loop",
+	})
+	gaps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "gaps",
+		Help:      "Number of gaps in msgindex.db and checksums.db",
+	}, []string{"db"})
+	checksumLoopState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "checksum_loop_state",
+		Help:      "State of the checksumming loop, set to 1 for the state it is in",
+	}, []string{"state"})
+	checksumLoopRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "checksum_loop_retries_total",
+		Help:      "Number of transient checksumming loop errors retried",
+	})
+	checksumLoopFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "checksum_loop_failures_total",
+		Help:      "Number of checksumming loop errors the retry policy gave up on",
+	})
+	peerComparisons = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "peer_comparisons_total",
+		Help:      "Number of checksums compared with peers, by whether the peer agreed, disagreed or had no checksum",
+	}, []string{"peer", "result"})
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "api_requests_total",
+		Help:      "Number of attestation API requests, by API, method and status",
+	}, []string{"api", "method", "status"})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystemAttestation,
+		Name:      "api_request_duration_seconds",
+		Help:      "Time taken to serve attestation API requests, by API and method",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api", "method"})
+
+	cidsRequested = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRepair,
+		Name:      "cids_requested_total",
+		Help:      "Number of missing CIDs requested for repair",
+	})
+	cidsPresent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRepair,
+		Name:      "cids_present_total",
+		Help:      "Number of requested CIDs already present in the local blockstore",
+	})
+	cidsFetched = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRepair,
+		Name:      "cids_fetched_total",
+		Help:      "Number of missing blocks fetched from the gateway",
+	})
+	cidsRetried = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRepair,
+		Name:      "cids_retried_total",
+		Help:      "Number of failed block fetches from the gateway that were retried",
+	})
+	cidsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRepair,
+		Name:      "cids_failed_total",
+		Help:      "Number of missing blocks that could not be fetched from the gateway",
+	})
+	bytesWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRepair,
+		Name:      "bytes_written_total",
+		Help:      "Number of bytes of block data written to the local blockstore",
+	})
+)
+
+// Init enables metrics and registers the collectors with the default prometheus registry
+func Init() {
+	initOnce.Do(func() {
+		prometheus.MustRegister(
+			chunksChecksummed,
+			checksumDuration,
+			lastChecksummedEpoch,
+			gaps,
+			checksumLoopState,
+			checksumLoopRetries,
+			checksumLoopFailures,
+			peerComparisons,
+			apiRequests,
+			apiRequestDuration,
+			cidsRequested,
+			cidsPresent,
+			cidsFetched,
+			cidsRetried,
+			cidsFailed,
+			bytesWritten,
+		)
+		metrics = true
+	})
+}
+
+// Enabled returns whether metrics have been enabled with Init
+// used to skip collecting metrics that are expensive to compute
+func Enabled() bool {
+	return metrics
+}
+
+// Serve serves the metrics of the default prometheus registry over HTTP at /metrics on the given address
+func Serve(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logrus.Infof("serving prometheus metrics at http://%s/metrics", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("prometheus metrics server failed: %s", err.Error())
+		}
+	}()
+	return srv
+}
+
+// ChunkChecksummed records a chunk checksummed in the given time
+func ChunkChecksummed(duration time.Duration) {
+	chunksChecksummed.Inc()
+	checksumDuration.Observe(duration.Seconds())
+}
+
+// SetLastChecksummedEpoch records the stop epoch of the last chunk published by the checksumming loop
+func SetLastChecksummedEpoch(epoch uint) {
+	lastChecksummedEpoch.Set(float64(epoch))
+}
+
+// SetGaps records the number of gaps found in the given database, MsgIndexDB or ChecksumsDB
+func SetGaps(db string, count int) {
+	gaps.WithLabelValues(db).Set(float64(count))
+}
+
+// SetChecksumLoopState records the state the checksumming loop is in
+func SetChecksumLoopState(state string) {
+	checksumLoopState.Reset()
+	checksumLoopState.WithLabelValues(state).Set(1)
+}
+
+// ChecksumLoopRetried records the checksumming loop retrying after a transient error
+func ChecksumLoopRetried() {
+	checksumLoopRetries.Inc()
+}
+
+// ChecksumLoopFailed records the retry policy giving up on a checksumming loop error
+func ChecksumLoopFailed() {
+	checksumLoopFailures.Inc()
+}
+
+// PeerAgreed records a checksum the peer agreed with
+func PeerAgreed(peer string) {
+	peerComparisons.WithLabelValues(peer, "agree").Inc()
+}
+
+// PeerDisagreed records a checksum the peer disagreed with
+func PeerDisagreed(peer string) {
+	peerComparisons.WithLabelValues(peer, "disagree").Inc()
+}
+
+// PeerMissing records a checksum the peer did not have
+func PeerMissing(peer string) {
+	peerComparisons.WithLabelValues(peer, "missing").Inc()
+}
+
+// CountAPIRequest records a request to the method of the given API, RPCAPI, JSONRPCAPI or RESTAPI
+func CountAPIRequest(api, method string, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	apiRequests.WithLabelValues(api, method, status).Inc()
+}
+
+// ObserveAPIRequest records a request to the method of the given API that started at the given time
+func ObserveAPIRequest(api, method string, start time.Time, err error) {
+	CountAPIRequest(api, method, err)
+	apiRequestDuration.WithLabelValues(api, method).Observe(time.Since(start).Seconds())
+}
+
+// CIDsRequested records the number of missing CIDs requested for repair
+func CIDsRequested(count int) {
+	cidsRequested.Add(float64(count))
+}
+
+// CIDsPresent records the number of requested CIDs already present in the local blockstore
+func CIDsPresent(count int) {
+	cidsPresent.Add(float64(count))
+}
+
+// CIDFetched records a missing block fetched from the gateway
+func CIDFetched() {
+	cidsFetched.Inc()
+}
+
+// CIDRetried records a failed block fetch from the gateway that is retried
+func CIDRetried() {
+	cidsRetried.Inc()
+}
+
+// CIDFailed records a missing block that could not be fetched from the gateway
+func CIDFailed() {
+	cidsFailed.Inc()
+}
+
+// BytesWritten records the number of bytes of block data written to the local blockstore
+func BytesWritten(count int) {
+	bytesWritten.Add(float64(count))
+}
@@ -0,0 +1,173 @@
+package repair
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
+)
+
+const (
+	defaultFetchConcurrency = 8
+	defaultFetchMaxRetries  = 3
+	defaultFetchMinDelay    = time.Second
+	defaultFetchMaxDelay    = 30 * time.Second
+)
+
+// FetchOptions is how blocks are fetched from the gateway
+type FetchOptions struct {
+	// Concurrency is the number of blocks fetched at once
+	Concurrency uint
+	// RateLimit is the number of requests per second made to the gateway, retries included, 0 for no limit
+	RateLimit float64
+	// MaxRetries is the number of times a failed fetch of a block is retried before it is given up on
+	MaxRetries uint
+	// MinDelay is the delay before the first retry of a fetch, it doubles for every retry after up to MaxDelay
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultFetchOptions returns the fetch options used if none are configured
+func DefaultFetchOptions() FetchOptions {
+	return FetchOptions{
+		Concurrency: defaultFetchConcurrency,
+		MaxRetries:  defaultFetchMaxRetries,
+		MinDelay:    defaultFetchMinDelay,
+		MaxDelay:    defaultFetchMaxDelay,
+	}
+}
+
+// Validate returns an error if the options cannot be used
+func (o FetchOptions) Validate() error {
+	if o.RateLimit < 0 {
+		return fmt.Errorf("fetch rate limit (%g) is negative", o.RateLimit)
+	}
+	if o.MinDelay > o.MaxDelay {
+		return fmt.Errorf("fetch retry min delay (%s) is higher than max delay (%s)", o.MinDelay, o.MaxDelay)
+	}
+	return nil
+}
+
+// Backoff returns the delay before the given retry, counting from 1
+func (o FetchOptions) Backoff(retry uint) time.Duration {
+	delay := o.MinDelay
+	for i := uint(1); i < retry && delay < o.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.MaxDelay {
+		delay = o.MaxDelay
+	}
+	return delay
+}
+
+// newLimiter returns the limiter of requests to the gateway, nil if they are not limited
+func (o FetchOptions) newLimiter() *rate.Limiter {
+	if o.RateLimit <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(o.RateLimit), 1)
+}
+
+// FetchFailure is a block that could not be fetched, with the error of its last attempt
+type FetchFailure struct {
+	CID cid.Cid
+	Err error
+}
+
+// FetchErrors are the blocks a repair could not fetch, the blocks it did fetch are written regardless
+type FetchErrors []FetchFailure
+
+func (e FetchErrors) Error() string {
+	if len(e) == 1 {
+		return fmt.Sprintf("failed to fetch %s: %v", e[0].CID, e[0].Err)
+	}
+	return fmt.Sprintf("failed to fetch %d blocks, the first %s: %v", len(e), e[0].CID, e[0].Err)
+}
+
+// retrieveMissingBlocks fetches the blocks from the gateway with a pool of workers, returning the blocks that were
+// fetched and the failures of those that were not, both in the order of the CIDs
+func (rs *Service) retrieveMissingBlocks(ctx context.Context, missingCIDs []cid.Cid) ([]block.Block, FetchErrors) {
+	fetched := make([]block.Block, len(missingCIDs))
+	errs := make([]error, len(missingCIDs))
+	workers := int(rs.fetch.Concurrency)
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(missingCIDs) {
+		workers = len(missingCIDs)
+	}
+	jobs := make(chan int)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fetched[i], errs[i] = rs.fetchBlock(ctx, missingCIDs[i])
+			}
+		}()
+	}
+	for i := range missingCIDs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	blocks := make([]block.Block, 0, len(missingCIDs))
+	var failures FetchErrors
+	for i, c := range missingCIDs {
+		if errs[i] != nil {
+			failures = append(failures, FetchFailure{CID: c, Err: errs[i]})
+			continue
+		}
+		blocks = append(blocks, fetched[i])
+	}
+	return blocks, failures
+}
+
+// fetchBlock fetches a block from the gateway, retrying failures with exponential backoff
+func (rs *Service) fetchBlock(ctx context.Context, c cid.Cid) (block.Block, error) {
+	for retry := uint(0); ; retry++ {
+		blk, err := rs.readBlock(ctx, c)
+		if err == nil {
+			prom.CIDFetched()
+			return blk, nil
+		}
+		if ctx.Err() != nil || retry == rs.fetch.MaxRetries {
+			prom.CIDFailed()
+			return nil, err
+		}
+		delay := rs.fetch.Backoff(retry + 1)
+		logrus.Debugf("failed to fetch %s, retry %d of %d in %s: %v", c, retry+1, rs.fetch.MaxRetries, delay, err)
+		prom.CIDRetried()
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			prom.CIDFailed()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// readBlock makes a single request for the block to the gateway, once the rate limit allows it
+func (rs *Service) readBlock(ctx context.Context, c cid.Cid) (block.Block, error) {
+	if rs.limiter != nil {
+		if err := rs.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	b, err := rs.srcAPI.ChainReadObj(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return block.NewBlockWithCid(b, c)
+}
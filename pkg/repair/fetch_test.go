@@ -0,0 +1,118 @@
+package repair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// newTestBlocks returns n dag-cbor blocks with distinct data
+func newTestBlocks(t *testing.T, n int) ([]block.Block, []cid.Cid) {
+	t.Helper()
+	blocks := make([]block.Block, n)
+	cids := make([]cid.Cid, n)
+	for i := range blocks {
+		blocks[i] = newTestBlock(t, fmt.Sprintf("block %d", i))
+		cids[i] = blocks[i].Cid()
+	}
+	return blocks, cids
+}
+
+func TestFetchOptions(t *testing.T) {
+	options := FetchOptions{MinDelay: time.Second, MaxDelay: 5 * time.Second}
+	for retry, expected := range map[uint]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second,
+		4: 5 * time.Second, 10: 5 * time.Second} {
+		if delay := options.Backoff(retry); delay != expected {
+			t.Errorf("expected a delay of %s before retry %d, got %s", expected, retry, delay)
+		}
+	}
+	if err := DefaultFetchOptions().Validate(); err != nil {
+		t.Errorf("expected the default options to be valid, got %v", err)
+	}
+	if err := (FetchOptions{MinDelay: time.Minute, MaxDelay: time.Second}).Validate(); err == nil {
+		t.Error("expected an error for a min delay higher than the max delay")
+	}
+	if err := (FetchOptions{RateLimit: -1}).Validate(); err == nil {
+		t.Error("expected an error for a negative rate limit")
+	}
+}
+
+func TestRetrieveMissingBlocksConcurrently(t *testing.T) {
+	blocks, cids := newTestBlocks(t, 12)
+	gw := newFakeGateway(blocks...)
+	gw.delay = 20 * time.Millisecond
+	service := NewRepairService(gw, blockstore.NewMemorySync(), Limits{}, FetchOptions{Concurrency: 4})
+
+	fetched, failures := service.retrieveMissingBlocks(context.Background(), cids)
+	if len(failures) != 0 {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+	for i, blk := range fetched {
+		if !blk.Cid().Equals(cids[i]) {
+			t.Fatalf("expected the blocks in the order of the CIDs, got %s at %d", blk.Cid(), i)
+		}
+	}
+	if gw.maxInFlight != 4 {
+		t.Fatalf("expected 4 fetches at once, got %d", gw.maxInFlight)
+	}
+}
+
+func TestRetrieveMissingBlocksRateLimit(t *testing.T) {
+	blocks, cids := newTestBlocks(t, 6)
+	gw := newFakeGateway(blocks...)
+	// half of the fetches fail once, so that the retries are limited too
+	for _, c := range cids[:3] {
+		gw.fails[c] = 1
+	}
+	service := NewRepairService(gw, blockstore.NewMemorySync(), Limits{},
+		FetchOptions{Concurrency: 6, RateLimit: 50, MaxRetries: 1, MinDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	start := time.Now()
+	if _, failures := service.retrieveMissingBlocks(context.Background(), cids); len(failures) != 0 {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+	// 9 requests at 50 per second, the first of which is not delayed
+	if elapsed := time.Since(start); elapsed < 160*time.Millisecond {
+		t.Fatalf("expected the requests to be rate limited, took %s", elapsed)
+	}
+}
+
+func TestRepairPartialSuccess(t *testing.T) {
+	ctx := context.Background()
+	blocks, cids := newTestBlocks(t, 4)
+	// the first block fails once and is retried, the second fails every attempt, the last is not on the gateway
+	gw := newFakeGateway(blocks[:3]...)
+	gw.fails[cids[0]] = 1
+	gw.fails[cids[1]] = 10
+	bs := blockstore.NewMemorySync()
+	service := NewRepairService(gw, bs, Limits{},
+		FetchOptions{Concurrency: 2, MaxRetries: 2, MinDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+
+	err := service.Repair(ctx, NewPlan(cids))
+	var failures FetchErrors
+	if !errors.As(err, &failures) {
+		t.Fatalf("expected the fetch errors, got %v", err)
+	}
+	if len(failures) != 2 || !failures[0].CID.Equals(cids[1]) || !failures[1].CID.Equals(cids[3]) {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+	if failures[0].Err.Error() != "gateway timeout" || failures[1].Err.Error() != "blockstore: block not found" {
+		t.Fatalf("expected the errors of the last attempts, got %v", failures)
+	}
+	// the blocks that were fetched are written
+	for i, expected := range []bool{true, false, true, false} {
+		if has, err := bs.Has(ctx, cids[i]); err != nil || has != expected {
+			t.Fatalf("expected block %d to be written: %t, got %t (err: %v)", i, expected, has, err)
+		}
+	}
+	// 2 attempts for the first block, 3 for each of the failures and 1 for the third block
+	if reads := gw.read(); len(reads) != 9 {
+		t.Fatalf("expected 9 fetches, got %d", len(reads))
+	}
+}
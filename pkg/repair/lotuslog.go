@@ -0,0 +1,182 @@
+package repair
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// notFoundErrors are the errors a Lotus error chain ends in when an object is missing from the blockstore
+// reading a missing object through the CBOR store fails with a bare EOF
+var notFoundErrors = []string{
+	"EOF",
+	"could not find",
+	"block not found",
+}
+
+// kindKeywords classify the object that failed to load by the error frames naming or enclosing it, checked from the
+// innermost frame outwards; within a frame the first matching keyword wins
+var kindKeywords = []struct {
+	keyword string
+	kind    ObjectKind
+}{
+	{"receipt", KindReceiptAMT},
+	{"state tree", KindStateNode},
+	{"state root", KindStateNode},
+	{"hamt", KindStateNode},
+	{"actor", KindStateNode},
+	{"msgmeta", KindMessage},
+	{"message", KindMessage},
+	{"get block", KindBlockHeader},
+	{"load block", KindBlockHeader},
+	{"block header", KindBlockHeader},
+	{"loading tipset", KindBlockHeader},
+	{"loadtipset", KindBlockHeader},
+}
+
+// ParseLotusErrors parses the xerrors chains of Lotus error logs into a repair plan
+// every chain that ends in a not-found error, e.g. "- EOF" or "ipld: could not find", names the object that failed
+// to load in its innermost frame with a CID; the other CIDs in the chain, e.g. the block marked as bad, the block it
+// is linked to and the members of its tipset, are context and are not fetched
+// a frame listing several CIDs, e.g. a tipset key, does not identify the missing object and is left as context
+// only CIDs that pass the filter are considered
+func ParseLotusErrors(src io.ReadCloser, filter Filter) (Plan, error) {
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineLength)
+	defer src.Close()
+	var plan Plan
+	var entry logEntry
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || trimmed == ")":
+		case line[0] != ' ' && line[0] != '\t':
+			// an unindented line starts a new log entry
+			plan.Merge(entry.plan(filter))
+			entry = logEntry{header: []string{trimmed}}
+		case strings.HasPrefix(trimmed, "* "):
+			entry.chains = append(entry.chains, []string{strings.TrimPrefix(trimmed, "* ")})
+		case strings.HasPrefix(trimmed, "- "):
+			entry.addFrame(strings.TrimPrefix(trimmed, "- "))
+		case isStackLine(trimmed):
+		default:
+			entry.appendToFrame(trimmed)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Plan{}, err
+	}
+	plan.Merge(entry.plan(filter))
+	return plan, nil
+}
+
+// logEntry is a Lotus log entry, the frames of its message and of the errors it lists as "N errors occurred"
+type logEntry struct {
+	header []string
+	chains [][]string
+}
+
+func (e *logEntry) addFrame(frame string) {
+	if len(e.chains) == 0 {
+		e.header = append(e.header, frame)
+		return
+	}
+	e.chains[len(e.chains)-1] = append(e.chains[len(e.chains)-1], frame)
+}
+
+func (e *logEntry) appendToFrame(text string) {
+	frames := &e.header
+	if len(e.chains) > 0 {
+		frames = &e.chains[len(e.chains)-1]
+	}
+	if len(*frames) == 0 {
+		*frames = append(*frames, text)
+		return
+	}
+	(*frames)[len(*frames)-1] += " " + text
+}
+
+// plan returns the plan for the entry, each listed error is a chain below the entry's message
+func (e *logEntry) plan(filter Filter) Plan {
+	var plan Plan
+	if len(e.chains) == 0 {
+		plan.Merge(planChain(e.header, filter))
+		return plan
+	}
+	for _, chain := range e.chains {
+		frames := make([]string, 0, len(e.header)+len(chain))
+		frames = append(frames, e.header...)
+		plan.Merge(planChain(append(frames, chain...), filter))
+	}
+	return plan
+}
+
+// planChain finds the object that failed to load in an error chain, the frames are split at ": " so that a chain
+// logged on a single line, e.g. "load state tree: failed to load hamt node: ipld: could not find <cid>", is parsed the
+// same as one logged over several lines
+func planChain(frames []string, filter Filter) Plan {
+	var segments []string
+	for _, frame := range frames {
+		for _, segment := range strings.Split(frame, ": ") {
+			if segment = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(segment), ":")); segment != "" {
+				segments = append(segments, segment)
+			}
+		}
+	}
+	var plan Plan
+	cids := make([][]cid.Cid, len(segments))
+	for i, segment := range segments {
+		cids[i] = ExtractCIDs(segment, filter)
+	}
+	missing := -1
+	if len(segments) > 0 && isNotFound(segments[len(segments)-1]) {
+		for i := len(segments) - 1; i >= 0; i-- {
+			if len(cids[i]) > 0 {
+				if len(cids[i]) == 1 {
+					missing = i
+				}
+				break
+			}
+		}
+	}
+	for i := range segments {
+		for _, c := range cids[i] {
+			if i == missing {
+				plan.add(MissingObject{CID: c, Kind: classify(segments[:i+1]), Context: segments[:i]})
+				continue
+			}
+			plan.addContext(c)
+		}
+	}
+	return plan
+}
+
+// classify returns the kind of the object named in the last of the segments, by the innermost segment that tells
+func classify(segments []string) ObjectKind {
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := strings.ToLower(segments[i])
+		for _, k := range kindKeywords {
+			if strings.Contains(segment, k.keyword) {
+				return k.kind
+			}
+		}
+	}
+	return KindUnknown
+}
+
+func isNotFound(segment string) bool {
+	for _, e := range notFoundErrors {
+		if strings.Contains(segment, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStackLine returns whether the line is a frame of the stack traces xerrors prints, a function or a file path
+func isStackLine(trimmed string) bool {
+	return !strings.Contains(trimmed, " ") && strings.Contains(trimmed, "/")
+}
@@ -0,0 +1,119 @@
+package repair
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+)
+
+func TestParseLotusErrorsBadBlock(t *testing.T) {
+	// the example error at the root of the repository is the log of a bad block
+	file, err := os.Open("../../.example_error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := ParseLotusErrors(file, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the message is the only object that failed to load, in all three errors of the bad block
+	if len(plan.Missing) != 1 {
+		t.Fatalf("expected a single missing object, got %+v", plan.Missing)
+	}
+	missing := plan.Missing[0]
+	if missing.CID.String() != "bafy2bzacealch3vsex3agbfrs4arf3hgctzd6dqpw3mlecbj5vb2p2vxcy7hy" || missing.Kind != KindMessage {
+		t.Fatalf("unexpected missing object %s of kind %s", missing.CID, missing.Kind)
+	}
+	if len(missing.Context) == 0 || missing.Context[len(missing.Context)-1] != "failed to get message" {
+		t.Fatalf("unexpected context %q", missing.Context)
+	}
+	// the bad block, the block it is linked to, its cause and the tipset members are context
+	for _, expected := range []string{
+		"bafy2bzaceblpxuvibzza6uzy3fckfds73dz5w5i36ywfcxrfoeija7i7crmjq",
+		"bafy2bzaced4zz3uqzglinlxk42wi2gomqri74a4n2662riavy2vl23yt25lgq",
+		"bafy2bzacebupfkdw6th3xn2y2q2hdvd3plosdc6cx5tcnfxbhvm2vxvwpmnde",
+		"bafy2bzacebcbbwdzoq5nyhkj64p5xaqadd6uoblryfzu6xugiksg3baep4qxw",
+		"bafy2bzacecq6ukgzpqkxvhohlcfdk2p4rwcsakdkzw5n5ep7wswxesjufqnii",
+		"bafy2bzaced4urx6ntytopio6xrs3o3xjpzdf2bvsciew53xuau5jossqoz4hq",
+	} {
+		if !containsCID(plan.Context, expected) {
+			t.Errorf("expected %s to be context, got %v", expected, plan.Context)
+		}
+	}
+	if len(plan.Context) != 6 {
+		t.Fatalf("expected 6 context CIDs, got %v", plan.Context)
+	}
+}
+
+func TestParseLotusErrors(t *testing.T) {
+	header := newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "header")
+	state := newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "state")
+	receipts := newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "receipts")
+	other := newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "other")
+	tipset := []cid.Cid{
+		newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "a"),
+		newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "b"),
+	}
+	log := strings.Join([]string{
+		"2023-07-01T04:47:39.635Z ERROR chain sync.go:1 failed to load state tree: failed to load hamt node: ipld: could not find " + state.String(),
+		"2023-07-01T04:47:40.635Z ERROR chain sync.go:1 loading tipset {" + tipset[0].String() + "," + tipset[1].String() + "}:",
+		"  - get block " + header.String() + ":",
+		"    github.com/filecoin-project/lotus/chain/store.(*ChainStore).LoadTipSet",
+		"        /lotus/chain/store/store.go:1",
+		"  - EOF",
+		"2023-07-01T04:47:41.635Z ERROR stmgr call.go:1 failed to load receipts amt " + receipts.String() + ": blockstore: block not found",
+		// the tipset key does not tell which block failed to load
+		"2023-07-01T04:47:42.635Z ERROR chain sync.go:1 loading tipset [" + tipset[0].String() + " " + tipset[1].String() + "]: EOF",
+		// not a missing object
+		"2023-07-01T04:47:43.635Z WARN chain sync.go:1 block " + other.String() + " has invalid signature",
+	}, "\n")
+	plan, err := ParseLotusErrors(io.NopCloser(strings.NewReader(log)), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MissingObject{
+		{CID: state, Kind: KindStateNode},
+		{CID: header, Kind: KindBlockHeader},
+		{CID: receipts, Kind: KindReceiptAMT},
+	}
+	if len(plan.Missing) != len(expected) {
+		t.Fatalf("expected %d missing objects, got %+v", len(expected), plan.Missing)
+	}
+	for i, obj := range plan.Missing {
+		if !obj.CID.Equals(expected[i].CID) || obj.Kind != expected[i].Kind {
+			t.Errorf("expected missing %s %s, got %s %s", expected[i].Kind, expected[i].CID, obj.Kind, obj.CID)
+		}
+	}
+	if len(plan.Context) != 3 || !containsCID(plan.Context, other.String()) || !containsCID(plan.Context, tipset[0].String()) {
+		t.Fatalf("unexpected context %v", plan.Context)
+	}
+	if summary := plan.Summary(); summary != "1 block-header, 1 receipt-amt, 1 state-node" {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+
+	// an object missing in one error and context in another is missing
+	explicit := NewPlan([]cid.Cid{tipset[0], header})
+	explicit.Merge(plan)
+	if len(explicit.Missing) != 4 || explicit.Missing[1].Kind != KindBlockHeader || containsCID(explicit.Context, tipset[0].String()) {
+		t.Fatalf("unexpected merged plan %+v", explicit)
+	}
+	// a plan literal is indexed on first use
+	literal := Plan{Missing: []MissingObject{{CID: header, Kind: KindUnknown}}, Context: []cid.Cid{other}}
+	literal.Merge(plan)
+	if len(literal.Missing) != 3 || literal.Missing[0].Kind != KindBlockHeader || len(literal.Context) != 3 {
+		t.Fatalf("unexpected merged plan literal %+v", literal)
+	}
+}
+
+func containsCID(cids []cid.Cid, str string) bool {
+	for _, c := range cids {
+		if c.String() == str {
+			return true
+		}
+	}
+	return false
+}
@@ -2,26 +2,73 @@ package repair
 
 import (
 	"bufio"
+	"fmt"
 	"io"
-	"strings"
 
 	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
 )
 
-var v1DAGCBORCidPrefix = "bafy2bzace"
-var v1DAGCBORCidLength = 62
+// maxLineLength bounds the length of a log line scanned for CIDs, longer lines fail the parse
+const maxLineLength = 1 << 20
 
-func ParseMissingCIDs(src io.ReadCloser) ([]cid.Cid, error) {
+// Filter selects CIDs by their content codec and multihash function
+// an empty list matches any codec or multihash function
+type Filter struct {
+	Codecs      []multicodec.Code
+	Multihashes []multicodec.Code
+}
+
+// NewFilter returns the filter for the given multicodec names or numbers, e.g. "dag-cbor" or "0x71" for the codecs and
+// "blake2b-256" for the multihash functions
+func NewFilter(codecs, multihashes []string) (Filter, error) {
+	var f Filter
+	for _, name := range codecs {
+		var code multicodec.Code
+		if err := code.Set(name); err != nil {
+			return Filter{}, fmt.Errorf("codec filter: %w", err)
+		}
+		f.Codecs = append(f.Codecs, code)
+	}
+	for _, name := range multihashes {
+		var code multicodec.Code
+		if err := code.Set(name); err != nil {
+			return Filter{}, fmt.Errorf("multihash filter: %w", err)
+		}
+		f.Multihashes = append(f.Multihashes, code)
+	}
+	return f, nil
+}
+
+// Match returns whether the CID passes the filter
+func (f Filter) Match(c cid.Cid) bool {
+	prefix := c.Prefix()
+	return matchCode(f.Codecs, prefix.Codec) && matchCode(f.Multihashes, prefix.MhType)
+}
+
+func matchCode(codes []multicodec.Code, code uint64) bool {
+	if len(codes) == 0 {
+		return true
+	}
+	for _, c := range codes {
+		if uint64(c) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMissingCIDs extracts the distinct CIDs that pass the filter from the lines of src, e.g. Lotus error logs
+func ParseMissingCIDs(src io.ReadCloser, filter Filter) ([]cid.Cid, error) {
 	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineLength)
 	defer src.Close()
 	var missingCIDs []cid.Cid
 	for scanner.Scan() {
-		line := scanner.Text()
-		cids, err := parseLine(line)
-		if err != nil {
-			return nil, err
-		}
-		missingCIDs = append(missingCIDs, cids...)
+		missingCIDs = append(missingCIDs, ExtractCIDs(scanner.Text(), filter)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 	return dedupeCIDs(missingCIDs), nil
 }
@@ -38,31 +85,48 @@ func dedupeCIDs(cids []cid.Cid) []cid.Cid {
 	return returnCIDs
 }
 
-func parseLine(line string) ([]cid.Cid, error) {
+// ExtractCIDs returns the CIDs that pass the filter found in free text, in the order they appear
+// the text is split into runs of alphanumeric characters, and each run that is a CID in its canonical string form is
+// kept: CIDv0 (Qm...) and CIDv1 of any codec, multihash and length in a multibase whose alphabet is alphanumeric, e.g.
+// base32 (bafy..., bafk...), base36 (k...), base58btc (z...) or base16 (f...)
+// base64 encoded CIDs are not recognised, as their alphabet cannot be told apart from the punctuation around them
+func ExtractCIDs(text string, filter Filter) []cid.Cid {
 	cids := make([]cid.Cid, 0)
-	for {
-		c, remainder, ok, err := extractNextCID(line)
-		if err != nil {
-			return nil, err
+	for start := 0; start < len(text); {
+		if !isTokenChar(text[start]) {
+			start++
+			continue
+		}
+		end := start + 1
+		for end < len(text) && isTokenChar(text[end]) {
+			end++
 		}
-		if !ok {
-			break
+		if c, ok := decodeCID(text[start:end]); ok && filter.Match(c) {
+			cids = append(cids, c)
 		}
-		cids = append(cids, c)
-		line = remainder
+		start = end
 	}
-	return cids, nil
+	return cids
 }
 
-func extractNextCID(str string) (cid.Cid, string, bool, error) {
-	if strings.Contains(str, v1DAGCBORCidPrefix) {
-		index := strings.Index(str, v1DAGCBORCidPrefix)
-		cidStr := str[index : index+v1DAGCBORCidLength]
-		c, err := cid.Decode(cidStr)
-		if err != nil {
-			return cid.Cid{}, "", false, err
-		}
-		return c, str[index+v1DAGCBORCidLength:], true, nil
+// decodeCID decodes the token if it is a CID in canonical form, i.e. it encodes back to the same string
+// this rejects words that happen to decode, e.g. through the case insensitivity of some bases
+func decodeCID(token string) (cid.Cid, bool) {
+	c, err := cid.Decode(token)
+	if err != nil {
+		return cid.Undef, false
+	}
+	encoding, err := cid.ExtractEncoding(token)
+	if err != nil {
+		return cid.Undef, false
+	}
+	str, err := c.StringOfBase(encoding)
+	if err != nil || str != token {
+		return cid.Undef, false
 	}
-	return cid.Cid{}, "", false, nil
+	return c, true
+}
+
+func isTokenChar(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
 }
@@ -0,0 +1,185 @@
+package repair
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multicodec"
+)
+
+// newTestCID returns the CID of the data with the given codec and multihash function
+func newTestCID(t testing.TB, version uint64, codec, mhType multicodec.Code, data string) cid.Cid {
+	t.Helper()
+	c, err := cid.Prefix{Version: version, Codec: uint64(codec), MhType: uint64(mhType), MhLength: -1}.Sum([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// encodeTestCID returns the CID encoded in the multibase
+func encodeTestCID(t testing.TB, c cid.Cid, base multibase.Encoding) string {
+	t.Helper()
+	str, err := c.StringOfBase(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return str
+}
+
+func TestExtractCIDs(t *testing.T) {
+	block := newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "block")
+	raw := newTestCID(t, 1, multicodec.Raw, multicodec.Sha2_256, "raw")
+	identity := newTestCID(t, 1, multicodec.Raw, multicodec.Identity, "id")
+	v0 := newTestCID(t, 0, multicodec.DagPb, multicodec.Sha2_256, "v0")
+	base36 := encodeTestCID(t, raw, multibase.Base36)
+	base58 := encodeTestCID(t, block, multibase.Base58BTC)
+	base16 := encodeTestCID(t, raw, multibase.Base16)
+
+	for _, test := range []struct {
+		name     string
+		line     string
+		filter   Filter
+		expected []cid.Cid
+	}{
+		{
+			name:     "lotus error",
+			line:     `2023-06-01T00:00:00.000Z ERROR chainstore store/store.go:123 failed to load state tree: ipld: could not find ` + block.String(),
+			expected: []cid.Cid{block},
+		},
+		{
+			name:     "json and punctuation",
+			line:     `{"Cids":[{"/":"` + block.String() + `"},{"/":"` + raw.String() + `"}]} (` + identity.String() + `).`,
+			expected: []cid.Cid{block, raw, identity},
+		},
+		{
+			name:     "other multibases",
+			line:     "v0=" + v0.String() + " base36=" + base36 + " base58=" + base58 + " base16=" + base16,
+			expected: []cid.Cid{v0, raw, block, raw},
+		},
+		{
+			name:     "codec filter",
+			line:     block.String() + " " + raw.String() + " " + identity.String(),
+			filter:   Filter{Codecs: []multicodec.Code{multicodec.Raw}},
+			expected: []cid.Cid{raw, identity},
+		},
+		{
+			name:     "multihash filter",
+			line:     block.String() + " " + raw.String() + " " + identity.String(),
+			filter:   Filter{Codecs: []multicodec.Code{multicodec.Raw}, Multihashes: []multicodec.Code{multicodec.Identity}},
+			expected: []cid.Cid{identity},
+		},
+		{
+			name:     "base32 upper",
+			line:     "missing " + strings.ToUpper(block.String()),
+			expected: []cid.Cid{block},
+		},
+		{
+			name: "not cids",
+			line: "baggage before face zebra Qm b" + strings.ToUpper(block.String()[1:]) + " " + block.String()[:30],
+		},
+		{
+			name: "truncated prefix at the end of the line",
+			line: "could not find bafy2bzace",
+		},
+		{
+			name: "cid glued to other characters",
+			line: "x" + block.String() + " " + block.String() + "0",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cids := ExtractCIDs(test.line, test.filter)
+			if len(cids) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, cids)
+			}
+			for i, c := range cids {
+				if !c.Equals(test.expected[i]) {
+					t.Fatalf("expected %v, got %v", test.expected, cids)
+				}
+			}
+		})
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	f, err := NewFilter([]string{"dag-cbor", "0x55"}, []string{"blake2b-256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Codecs) != 2 || f.Codecs[0] != multicodec.DagCbor || f.Codecs[1] != multicodec.Raw {
+		t.Fatalf("unexpected codecs %v", f.Codecs)
+	}
+	if len(f.Multihashes) != 1 || f.Multihashes[0] != multicodec.Blake2b256 {
+		t.Fatalf("unexpected multihashes %v", f.Multihashes)
+	}
+	if _, err := NewFilter([]string{"not-a-codec"}, nil); err == nil {
+		t.Fatal("expected an error for an unknown codec")
+	}
+}
+
+func TestParseMissingCIDs(t *testing.T) {
+	block := newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, "block")
+	raw := newTestCID(t, 1, multicodec.Raw, multicodec.Sha2_256, "raw")
+	log := strings.Join([]string{
+		"ERROR failed to get block " + block.String(),
+		"ERROR failed to get block " + block.String() + " again",
+		"WARN missing " + raw.String(),
+		strings.Repeat("long line ", 10000) + raw.String(),
+	}, "\n")
+	cids, err := ParseMissingCIDs(io.NopCloser(strings.NewReader(log)), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cids) != 2 {
+		t.Fatalf("expected the 2 distinct CIDs, got %v", cids)
+	}
+
+	if _, err := ParseMissingCIDs(io.NopCloser(strings.NewReader(strings.Repeat("x", maxLineLength+1))), Filter{}); err == nil {
+		t.Fatal("expected an error for a line that is too long")
+	}
+}
+
+func FuzzExtractCIDs(f *testing.F) {
+	block := newTestCID(f, 1, multicodec.DagCbor, multicodec.Blake2b256, "block")
+	raw := newTestCID(f, 1, multicodec.Raw, multicodec.Sha2_256, "raw")
+	for _, seed := range []string{
+		"",
+		"ipld: could not find " + block.String(),
+		"could not find bafy2bzace",
+		"bafk",
+		`{"/":"` + raw.String() + `"}`,
+		encodeTestCID(f, raw, multibase.Base36) + " " + encodeTestCID(f, block, multibase.Base16),
+		block.String()[:40],
+		"Qm" + strings.Repeat("1", 44),
+	} {
+		f.Add(seed, false)
+	}
+	f.Fuzz(func(t *testing.T, line string, filterRaw bool) {
+		var filter Filter
+		if filterRaw {
+			filter.Codecs = []multicodec.Code{multicodec.Raw}
+		}
+		for _, c := range ExtractCIDs(line, filter) {
+			if !filter.Match(c) {
+				t.Fatalf("extracted %s, which does not pass the filter", c)
+			}
+			if !strings.Contains(line, c.String()) && !containsEncoding(line, c) {
+				t.Fatalf("extracted %s, which is not in the line", c)
+			}
+		}
+	})
+}
+
+// containsEncoding returns whether the line contains the CID in any of the multibases it is extracted from
+func containsEncoding(line string, c cid.Cid) bool {
+	for _, base := range []multibase.Encoding{multibase.Base32, multibase.Base32Upper, multibase.Base36, multibase.Base36Upper,
+		multibase.Base58BTC, multibase.Base16, multibase.Base16Upper} {
+		if str, err := c.StringOfBase(base); err == nil && strings.Contains(line, str) {
+			return true
+		}
+	}
+	return false
+}
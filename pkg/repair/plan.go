@@ -0,0 +1,138 @@
+package repair
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ObjectKind is the kind of chain object a missing CID refers to, as far as the error that named it tells
+type ObjectKind string
+
+const (
+	KindUnknown     ObjectKind = "unknown"
+	KindBlockHeader ObjectKind = "block-header"
+	KindMessage     ObjectKind = "message"
+	KindStateNode   ObjectKind = "state-node"
+	KindReceiptAMT  ObjectKind = "receipt-amt"
+)
+
+// MissingObject is an object Lotus failed to load from its blockstore
+type MissingObject struct {
+	CID  cid.Cid
+	Kind ObjectKind
+	// Context is the error frames the object failed to load in, outermost first, empty if it was given explicitly
+	Context []string
+}
+
+// Plan is what a repair fetches from the gateway: the missing objects, and the CIDs that were only mentioned as the
+// context of the failures, e.g. the block marked as bad and the members of its tipset, which are left alone
+type Plan struct {
+	Missing []MissingObject
+	Context []cid.Cid
+
+	// missing and context map the CIDs of Missing and Context to their index, they are built on first use and rebuilt
+	// if the slices were changed directly
+	missing map[cid.Cid]int
+	context map[cid.Cid]int
+}
+
+// NewPlan returns the plan for fetching the given CIDs, of unknown kind
+func NewPlan(cids []cid.Cid) Plan {
+	var p Plan
+	for _, c := range cids {
+		p.add(MissingObject{CID: c, Kind: KindUnknown})
+	}
+	return p
+}
+
+// Merge adds the missing objects and context CIDs of the other plan to the plan
+// an object missing in either plan is missing in the merged plan, and keeps the kind it is known as
+func (p *Plan) Merge(other Plan) {
+	for _, obj := range other.Missing {
+		p.add(obj)
+	}
+	for _, c := range other.Context {
+		p.addContext(c)
+	}
+}
+
+// CIDs returns the CIDs of the missing objects
+func (p Plan) CIDs() []cid.Cid {
+	cids := make([]cid.Cid, len(p.Missing))
+	for i, obj := range p.Missing {
+		cids[i] = obj.CID
+	}
+	return cids
+}
+
+// Summary describes the number of missing objects of each kind, e.g. "2 message, 1 state-node"
+func (p Plan) Summary() string {
+	counts := make(map[ObjectKind]int)
+	for _, obj := range p.Missing {
+		counts[obj.Kind]++
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%d %s", counts[ObjectKind(kind)], kind)
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// index builds the indexes of the plan's CIDs if they are missing or out of date, e.g. for a plan literal
+func (p *Plan) index() {
+	if p.missing != nil && len(p.missing) == len(p.Missing) && len(p.context) == len(p.Context) {
+		return
+	}
+	p.missing = make(map[cid.Cid]int, len(p.Missing))
+	for i, obj := range p.Missing {
+		p.missing[obj.CID] = i
+	}
+	p.context = make(map[cid.Cid]int, len(p.Context))
+	for i, c := range p.Context {
+		p.context[c] = i
+	}
+}
+
+func (p *Plan) add(obj MissingObject) {
+	p.index()
+	if i, ok := p.missing[obj.CID]; ok {
+		if p.Missing[i].Kind == KindUnknown {
+			p.Missing[i] = obj
+		}
+		return
+	}
+	p.missing[obj.CID] = len(p.Missing)
+	p.Missing = append(p.Missing, obj)
+	p.removeContext(obj.CID)
+}
+
+func (p *Plan) addContext(c cid.Cid) {
+	p.index()
+	if _, ok := p.missing[c]; ok {
+		return
+	}
+	if _, ok := p.context[c]; ok {
+		return
+	}
+	p.context[c] = len(p.Context)
+	p.Context = append(p.Context, c)
+}
+
+func (p *Plan) removeContext(c cid.Cid) {
+	i, ok := p.context[c]
+	if !ok {
+		return
+	}
+	delete(p.context, c)
+	p.Context = append(p.Context[:i], p.Context[i+1:]...)
+	for j := i; j < len(p.Context); j++ {
+		p.context[p.Context[j]] = j
+	}
+}
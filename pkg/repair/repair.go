@@ -1,52 +1,180 @@
 package repair
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/blockstore"
 	block "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
 	"github.com/sirupsen/logrus"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/time/rate"
+
+	"github.com/vulcanize/lotus-utils/pkg/prom"
 )
 
 type Service struct {
 	srcAPI api.Gateway
 	dstBS  blockstore.Blockstore
+	limits Limits
+	fetch  FetchOptions
+	// limiter limits the requests to the gateway, nil if they are not limited
+	limiter *rate.Limiter
+}
+
+// Limits bound how far a repair follows the links of the blocks it fetches
+type Limits struct {
+	// MaxDepth is the number of levels of links followed below the requested CIDs, 0 fetches only the requested CIDs
+	MaxDepth uint
+	// MaxObjects is the number of blocks a repair tries to fetch at most, 0 for no limit
+	MaxObjects uint
 }
 
-func NewRepairService(srcAPI api.Gateway, dstBS blockstore.Blockstore) *Service {
+func NewRepairService(srcAPI api.Gateway, dstBS blockstore.Blockstore, limits Limits, fetch FetchOptions) *Service {
 	return &Service{
-		srcAPI: srcAPI,
-		dstBS:  dstBS,
+		srcAPI:  srcAPI,
+		dstBS:   dstBS,
+		limits:  limits,
+		fetch:   fetch,
+		limiter: fetch.newLimiter(),
 	}
 }
 
-func (rs *Service) Repair(ctx context.Context, missingCIDs []cid.Cid) error {
-	logrus.Infof("retrieving missing blocks for CIDs: %+v", missingCIDs)
-	blocks, err := rs.retrieveMissingBlocks(ctx, missingCIDs)
+// Repair fetches the missing objects of the plan from the gateway and writes them to the blockstore
+// the links of the fetched dag-cbor blocks are followed level by level, up to the depth and object limits, and the
+// linked blocks missing from the blockstore are fetched too, so that a single run closes the subgraph below the
+// missing objects rather than moving the failure one level deeper
+// the links of blocks already present in the blockstore are not followed
+func (rs *Service) Repair(ctx context.Context, plan Plan) error {
+	if len(plan.Context) > 0 {
+		logrus.Infof("not repairing %d CIDs only mentioned as the context of the errors: %+v", len(plan.Context), plan.Context)
+	}
+	for _, obj := range plan.Missing {
+		logrus.Debugf("missing %s %s, failed in: %s", obj.Kind, obj.CID, strings.Join(obj.Context, ": "))
+	}
+	requested := plan.CIDs()
+	prom.CIDsRequested(len(requested))
+	present, missingCIDs, err := rs.partition(ctx, requested)
 	if err != nil {
 		return err
 	}
-	logrus.Infof("inserting missing blocks for CIDs: %+v", missingCIDs)
-	if err := rs.dstBS.PutMany(ctx, blocks); err != nil {
-		return err
+	prom.CIDsPresent(len(present))
+	if len(present) > 0 {
+		logrus.Infof("skipping %d CIDs already present in the blockstore: %+v", len(present), present)
+	}
+	if len(missingCIDs) == 0 {
+		logrus.Infof("all %d CIDs (%s) are already present in the blockstore, nothing to repair", len(requested), plan.Summary())
+		return nil
 	}
+	logrus.Infof("retrieving %d of %d missing blocks (%s) for CIDs: %+v", len(missingCIDs), len(requested), plan.Summary(), missingCIDs)
+
+	seen := make(map[cid.Cid]struct{}, len(requested))
+	for _, c := range requested {
+		seen[c] = struct{}{}
+	}
+	var attempted, fetched uint
+	var failures FetchErrors
+	for depth := uint(0); len(missingCIDs) > 0; depth++ {
+		if rs.limits.MaxObjects > 0 && attempted+uint(len(missingCIDs)) > rs.limits.MaxObjects {
+			logrus.Warnf("object limit of %d reached, not fetching %d missing blocks at depth %d",
+				rs.limits.MaxObjects, attempted+uint(len(missingCIDs))-rs.limits.MaxObjects, depth)
+			missingCIDs = missingCIDs[:rs.limits.MaxObjects-attempted]
+		}
+		blocks, failed := rs.retrieveMissingBlocks(ctx, missingCIDs)
+		attempted += uint(len(missingCIDs))
+		failures = append(failures, failed...)
+		// the blocks that were fetched are written even if others failed
+		if len(blocks) > 0 {
+			logrus.Infof("inserting %d missing blocks at depth %d", len(blocks), depth)
+			if err := rs.dstBS.PutMany(ctx, blocks); err != nil {
+				return err
+			}
+			var written int
+			for _, blk := range blocks {
+				written += len(blk.RawData())
+			}
+			prom.BytesWritten(written)
+			fetched += uint(len(blocks))
+		}
+		if ctx.Err() != nil || rs.limits.MaxObjects > 0 && attempted == rs.limits.MaxObjects {
+			break
+		}
+
+		links := linksOf(blocks, seen)
+		present, missingCIDs, err = rs.partition(ctx, links)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("%d of the %d new links at depth %d are already present in the blockstore", len(present), len(links), depth+1)
+		if depth == rs.limits.MaxDepth {
+			if len(missingCIDs) > 0 {
+				logrus.Warnf("depth limit of %d reached, not fetching %d missing blocks linked below it", rs.limits.MaxDepth, len(missingCIDs))
+			}
+			break
+		}
+	}
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			logrus.Errorf("failed to fetch %s: %v", failure.CID, failure.Err)
+		}
+		logrus.Warnf("fetched %d missing blocks, failed to fetch %d", fetched, len(failures))
+		return failures
+	}
+	logrus.Infof("fetched %d missing blocks", fetched)
 	return nil
 }
 
-func (rs *Service) retrieveMissingBlocks(ctx context.Context, missingCIDs []cid.Cid) ([]block.Block, error) {
-	blocks := make([]block.Block, len(missingCIDs))
-	for i, c := range missingCIDs {
-		b, err := rs.srcAPI.ChainReadObj(ctx, c)
+// linksOf returns the links of the dag-cbor blocks that are not yet seen, and marks them seen
+// links to blocks that cannot be fetched, e.g. identity CIDs inlining their data or sector commitments, are skipped
+func linksOf(blocks []block.Block, seen map[cid.Cid]struct{}) []cid.Cid {
+	links := make([]cid.Cid, 0)
+	for _, blk := range blocks {
+		if blk.Cid().Prefix().Codec != cid.DagCBOR {
+			continue
+		}
+		err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(c cid.Cid) {
+			if _, ok := seen[c]; ok || !isFetchable(c) {
+				return
+			}
+			seen[c] = struct{}{}
+			links = append(links, c)
+		})
 		if err != nil {
-			return nil, err
+			logrus.Warnf("unable to scan block %s for links: %v", blk.Cid(), err)
 		}
-		blk, err := block.NewBlockWithCid(b, c)
+	}
+	return links
+}
+
+// isFetchable returns whether the CID names a block the gateway can serve
+func isFetchable(c cid.Cid) bool {
+	prefix := c.Prefix()
+	if prefix.MhType == mh.IDENTITY {
+		return false
+	}
+	return prefix.Codec == cid.DagCBOR || prefix.Codec == cid.Raw
+}
+
+// partition splits the CIDs into those already present in the blockstore, which are not fetched again so that
+// repeating a repair is cheap, and those missing from it
+func (rs *Service) partition(ctx context.Context, cids []cid.Cid) ([]cid.Cid, []cid.Cid, error) {
+	present := make([]cid.Cid, 0)
+	missing := make([]cid.Cid, 0, len(cids))
+	for _, c := range cids {
+		has, err := rs.dstBS.Has(ctx, c)
 		if err != nil {
-			return nil, err
+			return nil, nil, fmt.Errorf("checking the blockstore for %s: %w", c, err)
+		}
+		if has {
+			present = append(present, c)
+			continue
 		}
-		blocks[i] = blk
+		missing = append(missing, c)
 	}
-	return blocks, nil
+	return present, missing, nil
 }
@@ -0,0 +1,205 @@
+package repair
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// fakeGateway serves ChainReadObj from its blocks and records the CIDs read
+// a read of a CID in fails fails as many times as it is set to, and every read takes delay
+type fakeGateway struct {
+	api.Gateway
+
+	mu          sync.Mutex
+	blocks      map[cid.Cid][]byte
+	fails       map[cid.Cid]int
+	delay       time.Duration
+	reads       []cid.Cid
+	inFlight    int
+	maxInFlight int
+}
+
+func newFakeGateway(blocks ...block.Block) *fakeGateway {
+	gw := &fakeGateway{blocks: make(map[cid.Cid][]byte), fails: make(map[cid.Cid]int)}
+	for _, blk := range blocks {
+		gw.blocks[blk.Cid()] = blk.RawData()
+	}
+	return gw
+}
+
+func (gw *fakeGateway) ChainReadObj(_ context.Context, c cid.Cid) ([]byte, error) {
+	gw.mu.Lock()
+	gw.reads = append(gw.reads, c)
+	gw.inFlight++
+	if gw.inFlight > gw.maxInFlight {
+		gw.maxInFlight = gw.inFlight
+	}
+	gw.mu.Unlock()
+	time.Sleep(gw.delay)
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.inFlight--
+	if gw.fails[c] > 0 {
+		gw.fails[c]--
+		return nil, fmt.Errorf("gateway timeout")
+	}
+	data, ok := gw.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("blockstore: block not found")
+	}
+	return data, nil
+}
+
+func (gw *fakeGateway) read() []cid.Cid {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return append([]cid.Cid(nil), gw.reads...)
+}
+
+// newTestBlock returns a dag-cbor block with the given data
+func newTestBlock(t testing.TB, data string) block.Block {
+	t.Helper()
+	blk, err := block.NewBlockWithCid([]byte(data), newTestCID(t, 1, multicodec.DagCbor, multicodec.Blake2b256, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blk
+}
+
+// newTestNode returns a dag-cbor block of an array of the data and the links
+func newTestNode(t testing.TB, data string, links ...cid.Cid) block.Block {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := cbg.WriteMajorTypeHeader(buf, cbg.MajArray, uint64(len(links)+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cbg.WriteMajorTypeHeader(buf, cbg.MajTextString, uint64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString(data)
+	for _, link := range links {
+		if err := cbg.WriteCid(buf, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: uint64(multicodec.Blake2b256), MhLength: -1}.Sum(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := block.NewBlockWithCid(buf.Bytes(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blk
+}
+
+func TestRepairSkipsPresentCIDs(t *testing.T) {
+	ctx := context.Background()
+	present, missing := newTestBlock(t, "present"), newTestBlock(t, "missing")
+	gw := newFakeGateway(present, missing)
+	bs := blockstore.NewMemorySync()
+	if err := bs.Put(ctx, present); err != nil {
+		t.Fatal(err)
+	}
+	service := NewRepairService(gw, bs, Limits{}, DefaultFetchOptions())
+
+	if err := service.Repair(ctx, NewPlan([]cid.Cid{present.Cid(), missing.Cid()})); err != nil {
+		t.Fatal(err)
+	}
+	if reads := gw.read(); len(reads) != 1 || !reads[0].Equals(missing.Cid()) {
+		t.Fatalf("expected only the missing CID to be fetched, got %v", reads)
+	}
+	if has, err := bs.Has(ctx, missing.Cid()); err != nil || !has {
+		t.Fatalf("expected the missing block to be written (err: %v)", err)
+	}
+
+	// repeating the repair fetches nothing
+	if err := service.Repair(ctx, NewPlan([]cid.Cid{present.Cid(), missing.Cid()})); err != nil {
+		t.Fatal(err)
+	}
+	if reads := gw.read(); len(reads) != 1 {
+		t.Fatalf("expected a repeated repair not to fetch, got %v", reads)
+	}
+}
+
+func TestRepairFollowsLinks(t *testing.T) {
+	ctx := context.Background()
+	// root -> a, b, raw; a -> c and links that cannot be fetched; c -> d; b, which is present, -> e
+	raw, err := block.NewBlockWithCid([]byte("raw"), newTestCID(t, 1, multicodec.Raw, multicodec.Sha2_256, "raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := newTestCID(t, 1, multicodec.Raw, multicodec.Identity, "inline")
+	commitment := newTestCID(t, 1, multicodec.FilCommitmentSealed, multicodec.Sha2_256, "sector")
+	d := newTestNode(t, "d")
+	c := newTestNode(t, "c", d.Cid())
+	a := newTestNode(t, "a", c.Cid(), identity, commitment)
+	e := newTestNode(t, "e")
+	b := newTestNode(t, "b", e.Cid())
+	root := newTestNode(t, "root", a.Cid(), b.Cid(), raw.Cid(), a.Cid())
+
+	for _, test := range []struct {
+		name    string
+		limits  Limits
+		fetched []block.Block
+	}{
+		{
+			name:    "requested only",
+			fetched: []block.Block{root},
+		},
+		{
+			name:    "closed subgraph",
+			limits:  Limits{MaxDepth: 10},
+			fetched: []block.Block{root, a, raw, c, d},
+		},
+		{
+			name:    "depth limit",
+			limits:  Limits{MaxDepth: 1},
+			fetched: []block.Block{root, a, raw},
+		},
+		{
+			name:    "object limit",
+			limits:  Limits{MaxDepth: 10, MaxObjects: 2},
+			fetched: []block.Block{root, a},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			gw := newFakeGateway(root, a, b, c, d, e, raw)
+			bs := blockstore.NewMemorySync()
+			if err := bs.Put(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+			if err := NewRepairService(gw, bs, test.limits, DefaultFetchOptions()).Repair(ctx, NewPlan([]cid.Cid{root.Cid()})); err != nil {
+				t.Fatal(err)
+			}
+			reads := gw.read()
+			if len(reads) != len(test.fetched) {
+				t.Fatalf("expected %d blocks to be fetched, got %v", len(test.fetched), reads)
+			}
+			for i, blk := range test.fetched {
+				if !reads[i].Equals(blk.Cid()) {
+					t.Fatalf("expected %s to be fetched, got %v", blk.Cid(), reads)
+				}
+				if has, err := bs.Has(ctx, blk.Cid()); err != nil || !has {
+					t.Fatalf("expected %s to be written (err: %v)", blk.Cid(), err)
+				}
+			}
+			// the links of blocks already present are not followed
+			if has, _ := bs.Has(ctx, e.Cid()); has {
+				t.Fatal("expected the link of a present block not to be fetched")
+			}
+		})
+	}
+}
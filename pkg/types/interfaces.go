@@ -3,44 +3,332 @@ package types
 import (
 	"context"
 	"io"
+	"net/http"
 	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-jsonrpc/auth"
 )
 
 // Checksummer is the interface for the checksummer
 type Checksummer interface {
 	FindGaps(start, stop int) ([][2]uint, error)
-	Checksum(start, stop uint) (string, error)
+	Checksum(start, stop uint) (Checksum, error)
+	Messages(start, stop uint) ([]MessageRow, error)
 	CheckRangeIsPopulated(start, stop uint) (bool, error)
+	HighestEpoch() (uint, bool, error)
+	Algorithm() string
 	io.Closer
 }
 
 // ChecksumRepository is the interface for the checksum repository
 type ChecksumRepository interface {
-	PublishChecksum(start, stop uint, hash string) error
-	ChecksumExists(hash string) (bool, error)
-	GetChecksum(start, stop uint) (string, error)
+	PublishChecksum(checksum Checksum) error
+	ChecksumExists(hash, algo string) (bool, error)
+	GetChecksum(start, stop uint, algo string) (string, error)
+	ListChecksums(req ListChecksumsRequest) ([]Checksum, error)
+	GetChecksumByHash(hash string) (Checksum, bool, error)
+	DeleteChecksum(start, stop uint, algo string) (bool, error)
 	FindNextChecksum() (uint, error)
 	FindGaps(start, stop int) ([][2]uint, error)
+	RecordComparison(comparison Comparison) error
+	ListComparisons(req ListComparisonsRequest) ([]Comparison, error)
 	Interval() uint
 	io.Closer
 }
 
+// Peer is a remote attestation server whose checksums we compare against our own
+type Peer interface {
+	// ID identifies the peer, e.g. by its address
+	ID() string
+	GetChecksum(rng GetChecksumRequest) (string, error)
+	ComputeChecksum(rng GetChecksumRequest) (string, error)
+	GetMessages(rng GetMessagesRequest) ([]MessageRow, error)
+	io.Closer
+}
+
+// Checksum is a checksum over an epoch range (inclusive), along with the algorithm and serialization format version
+// that produced it
+// Signer and Signature are set when the checksum is a signed attestation, the signature is hex encoded
+type Checksum struct {
+	Start         uint
+	Stop          uint
+	Hash          string
+	Algorithm     string
+	FormatVersion uint
+	Signer        string
+	Signature     string
+}
+
+// Signer signs attestations with an operator key
+type Signer interface {
+	// ID identifies the key that verifiers use to check the signature
+	ID() string
+	Sign(msg []byte) ([]byte, error)
+}
+
+// Comparison is the result of comparing our checksum for a range with a peer's checksum for the same range and algorithm
+// CheckedAt is a unix timestamp in seconds
+type Comparison struct {
+	Peer       string
+	Start      uint
+	Stop       uint
+	Algorithm  string
+	LocalHash  string
+	RemoteHash string
+	Agree      bool
+	CheckedAt  int64
+}
+
+// MessageRow is a single row of the msgindex.db `messages` table
+type MessageRow struct {
+	Cid       string
+	TipSetCid string
+	Epoch     int64
+}
+
 // GetChecksumRequest holds the arguments to `GetChecksum` since net/rpc only supports a single request argument
+// an empty Algorithm matches a checksum produced by any algorithm
 type GetChecksumRequest struct {
+	Start     uint
+	Stop      uint
+	Algorithm string
+	// NoCompute has a server that computes checksums on demand return an empty checksum for a chunk it has not
+	// published, instead of computing it
+	NoCompute bool
+}
+
+// ChecksumExistsRequest holds the arguments to `ChecksumExists`
+// an empty Algorithm matches a checksum produced by any algorithm
+type ChecksumExistsRequest struct {
+	Hash      string
+	Algorithm string
+}
+
+// ListChecksumsRequest selects the published checksums with From <= start and stop <= To, ordered by start and algorithm
+// an empty Algorithm matches checksums produced by any algorithm, a zero To is unbounded and a zero Limit is unlimited
+// if AfterAlgorithm is set, the listing resumes after the checksum with the AfterStart start epoch and that algorithm
+type ListChecksumsRequest struct {
+	Algorithm      string
+	From           uint
+	To             uint
+	Limit          uint
+	AfterStart     uint
+	AfterAlgorithm string
+}
+
+// MerkleRootRequest holds the arguments to `GetMerkleRoot`
+// the root is computed over the first Size consecutive chunks from epoch 0, or all of them if Size is zero
+type MerkleRootRequest struct {
+	Algorithm string
+	Size      uint
+}
+
+// MerkleRoot is the root of the Merkle tree over the first Size consecutive chunk checksums, which span Start to Stop
+// the tree is anchored at epoch 0, so Start is always 0
+type MerkleRoot struct {
+	Algorithm string
+	Root      string
+	Size      uint
+	Start     uint
+	Stop      uint
+}
+
+// InclusionProofRequest holds the arguments to `GetInclusionProof`
+// the proof is against the tree over the first Size consecutive chunks, or all of them if Size is zero
+type InclusionProofRequest struct {
+	Start     uint
+	Stop      uint
+	Algorithm string
+	Size      uint
+}
+
+// InclusionProof proves that Checksum is the leaf at Index in the Merkle tree of the given Size and Root
+// Path holds the sibling hashes from the leaf up to the root
+type InclusionProof struct {
+	Checksum Checksum
+	Index    uint
+	Size     uint
+	Root     string
+	Path     []string
+}
+
+// GetMessagesRequest holds the arguments to `GetMessages`, selecting the msgindex.db rows with Start <= epoch <= Stop
+type GetMessagesRequest struct {
 	Start uint
 	Stop  uint
 }
 
+// ListComparisonsRequest selects recorded peer comparisons, ordered by peer and start
+// an empty Peer matches every peer and a zero Limit is unlimited
+type ListComparisonsRequest struct {
+	Peer              string
+	DisagreementsOnly bool
+	Limit             uint
+}
+
+// Checksum job statuses
+const (
+	ChecksumJobPending = "pending"
+	ChecksumJobDone    = "done"
+	ChecksumJobFailed  = "failed"
+)
+
+// RequestChecksumRequest requests that the checksum for a chunk be computed and published
+type RequestChecksumRequest struct {
+	Start     uint
+	Stop      uint
+	Algorithm string
+	// Timeout is how long to wait for the job to finish before returning it, zero returns the job immediately
+	Timeout time.Duration
+}
+
+// GetChecksumJobRequest requests the state of a checksum job
+type GetChecksumJobRequest struct {
+	ID string
+}
+
+// ChecksumJob is an on-demand checksum computation
+type ChecksumJob struct {
+	// ID is empty if the checksum was already published and no job was needed
+	ID        string
+	Start     uint
+	Stop      uint
+	Algorithm string
+	Status    string
+	// Hash is set once the job is done
+	Hash string
+	// Error is set if the job failed
+	Error string
+}
+
+// BackfillStatusRequest is the request for the backfill status, it has no parameters
+type BackfillStatusRequest struct{}
+
+// BackfillStatus reports the progress of backfilling gaps in the checksum repository
+type BackfillStatus struct {
+	// Pending are the chunk ranges queued for backfilling
+	Pending [][2]uint
+	// Backfilled is the number of chunks checksummed and published by the backfill
+	Backfilled uint
+	// Skipped is the number of queued chunks that could not be backfilled because their range has gaps in msgindex.db
+	Skipped uint
+	// LastCheckedAt is the unix time of the last search for gaps, zero if none has run yet
+	LastCheckedAt int64
+}
+
+// Checksumming loop states
+const (
+	// ChecksumLoopDisabled is the state of a service that has not started a checksumming loop
+	ChecksumLoopDisabled = "disabled"
+	ChecksumLoopRunning  = "running"
+	// ChecksumLoopWaiting is the state of the loop while the next chunk is not final or not populated in msgindex.db
+	ChecksumLoopWaiting = "waiting"
+	// ChecksumLoopRetrying is the state of the loop while it waits to try a failed step again
+	ChecksumLoopRetrying = "retrying"
+	// ChecksumLoopPaused is the state of the loop after an error, until it is resumed through the API
+	ChecksumLoopPaused  = "paused"
+	ChecksumLoopFailed  = "failed"
+	ChecksumLoopStopped = "stopped"
+)
+
+// StatusRequest is the request for the service status, it has no parameters
+type StatusRequest struct{}
+
+// Status reports the state of the attestation service and its checksumming loop
+type Status struct {
+	// LoopState is one of the checksumming loop states
+	LoopState string
+	// LastError is the last error of the checksumming loop, LastErrorAt its unix time
+	LastError   string
+	LastErrorAt int64
+	// Retries is the number of transient errors the loop retried, Failures the number of errors it gave up on
+	Retries  uint
+	Failures uint
+	// LastPublishedStart and LastPublishedStop are the range of the last chunk published by the checksumming loop
+	// Published is false if the loop has not published a chunk, and the checksum repository was empty when it started
+	Published          bool
+	LastPublishedStart uint
+	LastPublishedStop  uint
+	// HighestEpoch is the highest epoch indexed in msgindex.db, zero if the service has no checksummer
+	HighestEpoch uint
+	// Lag is the number of epochs indexed in msgindex.db that the checksumming loop has not published yet
+	Lag uint
+	// StartedAt is the unix time the service was created, Uptime the number of seconds since
+	StartedAt int64
+	Uptime    int64
+}
+
+// DeleteChecksumRequest deletes the published checksum for a range, produced by any algorithm if none is given
+type DeleteChecksumRequest struct {
+	Start     uint
+	Stop      uint
+	Algorithm string
+}
+
+// SubscribeChecksumsRequest subscribes to the checksums published from now on, optionally filtered by algorithm
+// if Resume is set, the published checksums starting at or after From are replayed first, and if AfterAlgorithm is set
+// the replay begins after the checksum starting at AfterStart produced by AfterAlgorithm, as in ListChecksumsRequest
+type SubscribeChecksumsRequest struct {
+	Algorithm      string
+	Resume         bool
+	From           uint
+	AfterStart     uint
+	AfterAlgorithm string
+}
+
 // API is the interface for the attestation service API
 type API interface {
-	ChecksumExists(hash string, res *bool) error
+	ChecksumExists(req ChecksumExistsRequest, res *bool) error
 	GetChecksum(rng GetChecksumRequest, res *string) error
+	GetAttestation(rng GetChecksumRequest, res *Checksum) error
+	GetMerkleRoot(req MerkleRootRequest, res *MerkleRoot) error
+	GetInclusionProof(req InclusionProofRequest, res *InclusionProof) error
+	GetComparisons(req ListComparisonsRequest, res *[]Comparison) error
+	ComputeChecksum(rng GetChecksumRequest, res *string) error
+	GetMessages(req GetMessagesRequest, res *[]MessageRow) error
+	GetBackfillStatus(req BackfillStatusRequest, res *BackfillStatus) error
+	RequestChecksum(req RequestChecksumRequest, res *ChecksumJob) error
+	GetChecksumJob(req GetChecksumJobRequest, res *ChecksumJob) error
+	GetStatus(req StatusRequest, res *Status) error
+}
+
+// AttestationAPI is the interface for the attestation service API served over JSON-RPC 2.0
+// it exposes the same methods as API in the calling convention of go-jsonrpc, under the "Attestation" namespace
+type AttestationAPI interface {
+	ChecksumExists(ctx context.Context, req ChecksumExistsRequest) (bool, error)
+	GetChecksum(ctx context.Context, rng GetChecksumRequest) (string, error)
+	GetAttestation(ctx context.Context, rng GetChecksumRequest) (Checksum, error)
+	GetMerkleRoot(ctx context.Context, req MerkleRootRequest) (MerkleRoot, error)
+	GetInclusionProof(ctx context.Context, req InclusionProofRequest) (InclusionProof, error)
+	GetComparisons(ctx context.Context, req ListComparisonsRequest) ([]Comparison, error)
+	ComputeChecksum(ctx context.Context, rng GetChecksumRequest) (string, error)
+	GetMessages(ctx context.Context, req GetMessagesRequest) ([]MessageRow, error)
+	GetBackfillStatus(ctx context.Context) (BackfillStatus, error)
+	RequestChecksum(ctx context.Context, req RequestChecksumRequest) (ChecksumJob, error)
+	GetChecksumJob(ctx context.Context, req GetChecksumJobRequest) (ChecksumJob, error)
+	GetStatus(ctx context.Context) (Status, error)
+	// SubscribeChecksums requires a WebSocket connection, the channel is closed when the subscription ends
+	SubscribeChecksums(ctx context.Context, req SubscribeChecksumsRequest) (<-chan Checksum, error)
+
+	// the admin methods require the admin permission
+	RecomputeChecksum(ctx context.Context, req RequestChecksumRequest) (ChecksumJob, error)
+	TriggerBackfill(ctx context.Context) (BackfillStatus, error)
+	ResumeChecksumming(ctx context.Context) (Status, error)
+	DeleteChecksum(ctx context.Context, req DeleteChecksumRequest) (bool, error)
 }
 
 // AttestationService is the top-level interface for the attestation service
 type AttestationService interface {
 	Checksum(ctx context.Context, wg *sync.WaitGroup) (error, <-chan error)
+	Compare(ctx context.Context, wg *sync.WaitGroup) error
 	Serve(ctx context.Context, wg *sync.WaitGroup) error
 	Register(reg func(any) error) error
+	RegisterJSONRPC(reg func(namespace string, handler any))
+	RESTHandler() http.Handler
+	AuthHandler(required auth.Permission, next http.Handler) http.Handler
+	HealthHandler() http.Handler
+	Status() Status
+	Stop()
 	io.Closer
 }
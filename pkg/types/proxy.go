@@ -0,0 +1,98 @@
+package types
+
+import "context"
+
+var _ AttestationAPI = (*AttestationStruct)(nil)
+
+// AttestationStruct is the go-jsonrpc client proxy for the AttestationAPI
+// go-jsonrpc fills in the Internal functions, each field must match the name and signature of an AttestationAPI method
+// the perm tag of each field is the permission required to call the method, see auth.PermissionedProxy
+type AttestationStruct struct {
+	Internal struct {
+		ChecksumExists     func(ctx context.Context, req ChecksumExistsRequest) (bool, error)                `perm:"read"`
+		GetChecksum        func(ctx context.Context, rng GetChecksumRequest) (string, error)                 `perm:"read"`
+		GetAttestation     func(ctx context.Context, rng GetChecksumRequest) (Checksum, error)               `perm:"read"`
+		GetMerkleRoot      func(ctx context.Context, req MerkleRootRequest) (MerkleRoot, error)              `perm:"read"`
+		GetInclusionProof  func(ctx context.Context, req InclusionProofRequest) (InclusionProof, error)      `perm:"read"`
+		GetComparisons     func(ctx context.Context, req ListComparisonsRequest) ([]Comparison, error)       `perm:"read"`
+		ComputeChecksum    func(ctx context.Context, rng GetChecksumRequest) (string, error)                 `perm:"read"`
+		GetMessages        func(ctx context.Context, req GetMessagesRequest) ([]MessageRow, error)           `perm:"read"`
+		GetBackfillStatus  func(ctx context.Context) (BackfillStatus, error)                                 `perm:"read"`
+		RequestChecksum    func(ctx context.Context, req RequestChecksumRequest) (ChecksumJob, error)        `perm:"read"`
+		GetChecksumJob     func(ctx context.Context, req GetChecksumJobRequest) (ChecksumJob, error)         `perm:"read"`
+		GetStatus          func(ctx context.Context) (Status, error)                                         `perm:"read"`
+		SubscribeChecksums func(ctx context.Context, req SubscribeChecksumsRequest) (<-chan Checksum, error) `perm:"read"`
+		RecomputeChecksum  func(ctx context.Context, req RequestChecksumRequest) (ChecksumJob, error)        `perm:"admin"`
+		TriggerBackfill    func(ctx context.Context) (BackfillStatus, error)                                 `perm:"admin"`
+		ResumeChecksumming func(ctx context.Context) (Status, error)                                         `perm:"admin"`
+		DeleteChecksum     func(ctx context.Context, req DeleteChecksumRequest) (bool, error)                `perm:"admin"`
+	}
+}
+
+func (s *AttestationStruct) ChecksumExists(ctx context.Context, req ChecksumExistsRequest) (bool, error) {
+	return s.Internal.ChecksumExists(ctx, req)
+}
+
+func (s *AttestationStruct) GetChecksum(ctx context.Context, rng GetChecksumRequest) (string, error) {
+	return s.Internal.GetChecksum(ctx, rng)
+}
+
+func (s *AttestationStruct) GetAttestation(ctx context.Context, rng GetChecksumRequest) (Checksum, error) {
+	return s.Internal.GetAttestation(ctx, rng)
+}
+
+func (s *AttestationStruct) GetMerkleRoot(ctx context.Context, req MerkleRootRequest) (MerkleRoot, error) {
+	return s.Internal.GetMerkleRoot(ctx, req)
+}
+
+func (s *AttestationStruct) GetInclusionProof(ctx context.Context, req InclusionProofRequest) (InclusionProof, error) {
+	return s.Internal.GetInclusionProof(ctx, req)
+}
+
+func (s *AttestationStruct) GetComparisons(ctx context.Context, req ListComparisonsRequest) ([]Comparison, error) {
+	return s.Internal.GetComparisons(ctx, req)
+}
+
+func (s *AttestationStruct) ComputeChecksum(ctx context.Context, rng GetChecksumRequest) (string, error) {
+	return s.Internal.ComputeChecksum(ctx, rng)
+}
+
+func (s *AttestationStruct) GetMessages(ctx context.Context, req GetMessagesRequest) ([]MessageRow, error) {
+	return s.Internal.GetMessages(ctx, req)
+}
+
+func (s *AttestationStruct) GetBackfillStatus(ctx context.Context) (BackfillStatus, error) {
+	return s.Internal.GetBackfillStatus(ctx)
+}
+
+func (s *AttestationStruct) RequestChecksum(ctx context.Context, req RequestChecksumRequest) (ChecksumJob, error) {
+	return s.Internal.RequestChecksum(ctx, req)
+}
+
+func (s *AttestationStruct) GetChecksumJob(ctx context.Context, req GetChecksumJobRequest) (ChecksumJob, error) {
+	return s.Internal.GetChecksumJob(ctx, req)
+}
+
+func (s *AttestationStruct) GetStatus(ctx context.Context) (Status, error) {
+	return s.Internal.GetStatus(ctx)
+}
+
+func (s *AttestationStruct) SubscribeChecksums(ctx context.Context, req SubscribeChecksumsRequest) (<-chan Checksum, error) {
+	return s.Internal.SubscribeChecksums(ctx, req)
+}
+
+func (s *AttestationStruct) RecomputeChecksum(ctx context.Context, req RequestChecksumRequest) (ChecksumJob, error) {
+	return s.Internal.RecomputeChecksum(ctx, req)
+}
+
+func (s *AttestationStruct) TriggerBackfill(ctx context.Context) (BackfillStatus, error) {
+	return s.Internal.TriggerBackfill(ctx)
+}
+
+func (s *AttestationStruct) ResumeChecksumming(ctx context.Context) (Status, error) {
+	return s.Internal.ResumeChecksumming(ctx)
+}
+
+func (s *AttestationStruct) DeleteChecksum(ctx context.Context, req DeleteChecksumRequest) (bool, error) {
+	return s.Internal.DeleteChecksum(ctx, req)
+}